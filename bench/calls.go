@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc"
+	"io"
+	"sort"
+	"strings"
+)
+
+// request 每次调用的参数
+type request struct {
+	payload  int
+	messages int
+}
+
+// callFunc 发起一次调用，返回收发的业务数据字节数
+type callFunc func(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error)
+
+// calls 可以压测的方法
+var calls = map[string]callFunc{
+	"SayHello":        sayHello,
+	"SayMessage":      sayMessage,
+	"LotsOfReplies":   lotsOfReplies,
+	"LotsOfGreetings": lotsOfGreetings,
+	"BidiHello":       bidiHello,
+	"DownLoadFile":    downLoadFile,
+	"UploadFile":      uploadFile,
+}
+
+func sortedMethods() []string {
+	names := make([]string, 0, len(calls))
+	for name := range calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func helloRequest(req request) *hello.HelloRequest {
+	return &hello.HelloRequest{Name: "bench", Message: strings.Repeat("x", req.payload)}
+}
+
+func sayHello(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	in := helloRequest(req)
+	resp, err := hello.NewHelloServiceClient(conn).SayHello(ctx, in)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(in.GetMessage()) + len(resp.GetMessage())), nil
+}
+
+func sayMessage(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	in := helloRequest(req)
+	resp, err := hello.NewGatewayServiceClient(conn).SayMessage(ctx, in)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(in.GetMessage()) + len(resp.GetMessage())), nil
+}
+
+func lotsOfReplies(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	stream, err := hello.NewHelloServiceClient(conn).LotsOfReplies(ctx, helloRequest(req))
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(resp.GetName()))
+	}
+}
+
+func lotsOfGreetings(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	stream, err := hello.NewHelloServiceClient(conn).LotsOfGreetings(ctx)
+	if err != nil {
+		return 0, err
+	}
+	in := helloRequest(req)
+	var n int64
+	for i := 0; i < req.messages; i++ {
+		if err := stream.Send(in); err != nil {
+			if err == io.EOF {
+				// 服务端提前结束，通过 CloseAndRecv 获取真正的错误
+				_, err = stream.CloseAndRecv()
+			}
+			return n, err
+		}
+		n += int64(len(in.GetMessage()))
+	}
+	_, err = stream.CloseAndRecv()
+	return n, err
+}
+
+func bidiHello(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := hello.NewHelloServiceClient(conn).BidiHello(ctx)
+	if err != nil {
+		return 0, err
+	}
+	in := helloRequest(req)
+	var n int64
+	// 一问一答，测量的是完整往返
+	for i := 0; i < req.messages; i++ {
+		if err := stream.Send(in); err != nil {
+			if err == io.EOF {
+				_, err = stream.Recv()
+			}
+			return n, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(in.GetMessage()) + len(resp.GetName()))
+	}
+	if err := stream.CloseSend(); err != nil {
+		return n, err
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		return n, err
+	}
+	return n, nil
+}
+
+func downLoadFile(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	stream, err := hello.NewFileServiceClient(conn).DownLoadFile(ctx, &hello.HelloRequest{Name: "bench"})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(resp.GetContent()))
+	}
+}
+
+// uploadChunkSize 与 sdk 上传时每条消息的大小一致
+const uploadChunkSize = 32 * 1024
+
+func uploadFile(ctx context.Context, conn *grpc.ClientConn, req request) (int64, error) {
+	stream, err := hello.NewFileServiceClient(conn).UploadFile(ctx)
+	if err != nil {
+		return 0, err
+	}
+	content := make([]byte, req.payload)
+	var n int64
+	for len(content) > 0 {
+		size := len(content)
+		if size > uploadChunkSize {
+			size = uploadChunkSize
+		}
+		if err := stream.Send(&hello.FileRequest{FileName: "bench", Content: content[:size]}); err != nil {
+			if err == io.EOF {
+				_, err = stream.CloseAndRecv()
+			}
+			return n, err
+		}
+		n += int64(size)
+		content = content[size:]
+	}
+	_, err = stream.CloseAndRecv()
+	return n, err
+}
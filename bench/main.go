@@ -0,0 +1,147 @@
+// bench 压测工具，对任意方法发起指定并发、速率、时长的调用，输出延迟分位数、吞吐量和错误统计
+//
+//	go run ./bench -method SayHello -c 50 -d 10s
+//	go run ./bench -method BidiHello -addr 192.168.2.166:8080 -connections 4 -messages 100 -format json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	"github.com/keepon-online/go-grpc-example/client/handler"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+var (
+	method      = flag.String("method", "SayHello", "压测的方法："+methodNames())
+	addr        = flag.String("addr", "", "服务端地址，为空时在进程内启动服务端")
+	configFile  = flag.String("config", "conf/config.yaml", "配置文件路径，压测远程地址时读取 client.serverName 和 client.auth")
+	certFile    = flag.String("cert", "conf/server.crt", "压测远程地址时校验服务端的证书")
+	concurrency = flag.Int("c", 10, "并发数")
+	rate        = flag.Float64("rate", 0, "每秒最多发起的调用数，0 表示不限制")
+	duration    = flag.Duration("d", 10*time.Second, "压测时长")
+	total       = flag.Int("n", 0, "最多发起的调用数，0 表示只受时长限制")
+	payload     = flag.Int("payload", 64, "请求大小（字节）：一元和流式方法的 Message 长度，上传和进程内下载的文件大小")
+	messages    = flag.Int("messages", 10, "LotsOfGreetings、BidiHello 每次调用发送的消息数")
+	connections = flag.Int("connections", 1, "连接数，调用平均分配到各个连接")
+	format      = flag.String("format", "text", "报告格式：text 或 json")
+)
+
+func main() {
+	flag.Parse()
+	call, ok := calls[*method]
+	if !ok {
+		log.Fatalf("unknown method %q, want one of: %s", *method, methodNames())
+	}
+	if *concurrency < 1 || *connections < 1 {
+		log.Fatalf("-c and -connections must be at least 1")
+	}
+	// 调用间隔按纳秒计算，超过 1e9 时间隔为 0
+	if *rate < 0 || *rate > float64(time.Second) {
+		log.Fatalf("-rate must be between 0 and %d", time.Second)
+	}
+
+	conns, target, cleanup, err := dial()
+	if err != nil {
+		log.Fatalf("dial: %v", err)
+	}
+	defer cleanup()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	r := &runner{
+		call:        call,
+		conns:       conns,
+		concurrency: *concurrency,
+		rate:        *rate,
+		duration:    *duration,
+		total:       *total,
+		req: request{
+			payload:  *payload,
+			messages: *messages,
+		},
+	}
+	report := r.run(ctx)
+	report.Method = *method
+	report.Target = target
+	if err := report.write(os.Stdout, *format); err != nil {
+		log.Fatalf("write report: %v", err)
+	}
+}
+
+// dial 建立 -connections 个连接，-addr 为空时先在进程内启动服务端
+func dial() (conns []*grpc.ClientConn, target string, cleanup func(), err error) {
+	if *addr == "" {
+		return dialInProcess()
+	}
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	creds, err := credentials.NewClientTLSFromFile(*certFile, cfg.Client.ServerName)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if src := auth.FromConfig(cfg.Client.Auth); src != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(&handler.Token{Uid: "bench", Source: src}))
+	}
+	// 不使用 conn.Dial，避免客户端的重试、对冲和默认超时影响压测结果
+	for i := 0; i < *connections; i++ {
+		conn, err := grpc.Dial(*addr, opts...)
+		if err != nil {
+			closeAll(conns)
+			return nil, "", nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, *addr, func() { closeAll(conns) }, nil
+}
+
+func dialInProcess() (conns []*grpc.ClientConn, target string, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "bench")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// 进程内下载的文件大小由 -payload 决定
+	path := filepath.Join(dir, "download")
+	if err := os.WriteFile(path, make([]byte, *payload), 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", nil, err
+	}
+	s := startServer(path)
+	for i := 0; i < *connections; i++ {
+		conn, err := s.dial()
+		if err != nil {
+			closeAll(conns)
+			s.stop()
+			os.RemoveAll(dir)
+			return nil, "", nil, err
+		}
+		conns = append(conns, conn)
+	}
+	cleanup = func() {
+		closeAll(conns)
+		s.stop()
+		os.RemoveAll(dir)
+	}
+	return conns, "in-process", cleanup, nil
+}
+
+func closeAll(conns []*grpc.ClientConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func methodNames() string {
+	return fmt.Sprint(sortedMethods())
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Report 压测结果，时间单位为毫秒
+type Report struct {
+	Method      string  `json:"method"`
+	Target      string  `json:"target"`
+	Concurrency int     `json:"concurrency"`
+	Connections int     `json:"connections"`
+	Rate        float64 `json:"rate"`
+	Payload     int     `json:"payload"`
+	Messages    int     `json:"messages"`
+	// DurationMs 实际耗时，包括结束时等待已经发起的调用完成
+	DurationMs float64 `json:"durationMs"`
+	Total      int64   `json:"total"`
+	Succeeded  int64   `json:"succeeded"`
+	// Errors 按状态码统计失败的调用
+	Errors map[string]int64 `json:"errors"`
+	// RPS 每秒完成的调用数
+	RPS float64 `json:"rps"`
+	// Throughput 每秒收发的业务数据字节数
+	Throughput float64 `json:"throughputBytes"`
+	Latency    Latency `json:"latency"`
+}
+
+// Latency 所有调用（包括失败的）的延迟分布
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+func newReport(results []result, elapsed time.Duration, r *runner) *Report {
+	report := &Report{
+		Concurrency: r.concurrency,
+		Connections: len(r.conns),
+		Rate:        r.rate,
+		Payload:     r.req.payload,
+		Messages:    r.req.messages,
+		DurationMs:  ms(elapsed),
+		Errors:      make(map[string]int64),
+	}
+	var latencies []time.Duration
+	var bytes int64
+	for _, res := range results {
+		latencies = append(latencies, res.latencies...)
+		bytes += res.bytes
+		for code, n := range res.errors {
+			report.Errors[code] += n
+		}
+	}
+	report.Total = int64(len(latencies))
+	report.Succeeded = report.Total
+	for _, n := range report.Errors {
+		report.Succeeded -= n
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		report.RPS = float64(report.Total) / seconds
+		report.Throughput = float64(bytes) / seconds
+	}
+	if len(latencies) == 0 {
+		return report
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	report.Latency = Latency{
+		Min:  ms(latencies[0]),
+		Mean: ms(sum / time.Duration(len(latencies))),
+		P50:  ms(percentile(latencies, 50)),
+		P90:  ms(percentile(latencies, 90)),
+		P95:  ms(percentile(latencies, 95)),
+		P99:  ms(percentile(latencies, 99)),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+	return report
+}
+
+// percentile 最近秩法，sorted 必须已经排序且不为空
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *Report) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "text":
+		return r.writeText(w)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func (r *Report) writeText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `Summary:
+  Method:       %s
+  Target:       %s
+  Concurrency:  %d
+  Connections:  %d
+  Duration:     %.2fs
+  Total:        %d
+  Succeeded:    %d
+  Requests/sec: %.2f
+  Throughput:   %.2f MB/s
+
+Latency (ms):
+  Min:   %.3f
+  Mean:  %.3f
+  P50:   %.3f
+  P90:   %.3f
+  P95:   %.3f
+  P99:   %.3f
+  Max:   %.3f
+`, r.Method, r.Target, r.Concurrency, r.Connections, r.DurationMs/1000, r.Total, r.Succeeded,
+		r.RPS, r.Throughput/(1<<20),
+		r.Latency.Min, r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P95, r.Latency.P99, r.Latency.Max)
+	if err != nil || len(r.Errors) == 0 {
+		return err
+	}
+	codes := make([]string, 0, len(r.Errors))
+	for code := range r.Errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	if _, err := fmt.Fprintln(w, "\nErrors:"); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := fmt.Fprintf(w, "  %-18s %d\n", code+":", r.Errors[code]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
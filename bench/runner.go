@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runner 按并发数和速率重复发起调用，直到时长或调用数达到上限
+type runner struct {
+	call        callFunc
+	conns       []*grpc.ClientConn
+	concurrency int
+	rate        float64
+	duration    time.Duration
+	total       int
+	req         request
+}
+
+// result 单个协程的统计，结束后合并，避免压测过程中争用锁
+type result struct {
+	latencies []time.Duration
+	errors    map[string]int64
+	bytes     int64
+}
+
+func (r *runner) run(ctx context.Context) *Report {
+	// stop 结束后不再发起新的调用，已经发起的调用使用 ctx 继续完成
+	stop, cancel := context.WithTimeout(ctx, r.duration)
+	defer cancel()
+
+	var ticks <-chan time.Time
+	if r.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var issued int64
+	results := make([]result, r.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res := &results[i]
+			res.errors = make(map[string]int64)
+			conn := r.conns[i%len(r.conns)]
+			for {
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-stop.Done():
+						return
+					}
+				} else if stop.Err() != nil {
+					return
+				}
+				if r.total > 0 && atomic.AddInt64(&issued, 1) > int64(r.total) {
+					return
+				}
+				begin := time.Now()
+				n, err := r.call(ctx, conn, r.req)
+				res.latencies = append(res.latencies, time.Since(begin))
+				res.bytes += n
+				if err != nil {
+					res.errors[status.Code(err).String()]++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	return newReport(results, time.Since(start), r)
+}
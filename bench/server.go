@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+)
+
+// bufSize bufconn 的缓冲区大小
+const bufSize = 1 << 20
+
+// inProcessServer 进程内的服务端，通过 bufconn 连接，不经过网络和 TLS，也不做认证
+type inProcessServer struct {
+	grpc     *grpc.Server
+	listener *bufconn.Listener
+}
+
+// startServer 注册和 server/main.go 相同的服务，DownLoadFile 下载 downloadPath
+func startServer(downloadPath string) *inProcessServer {
+	cfg := config.Default()
+	s := grpc.NewServer(append(handler.ServerOptions(cfg.Server),
+		grpc.ChainUnaryInterceptor(handler.ServerInterceptorTenant(cfg.Tenants)),
+		grpc.ChainStreamInterceptor(handler.StreamServerInterceptorTenant(cfg.Tenants)),
+	)...)
+	hello.RegisterHelloServiceServer(s, &service.HelloServer{
+		Rooms: service.NewRooms(cfg.Chat),
+		Batch: service.NewBatch(cfg.Batch),
+	})
+	hello.RegisterGatewayServiceServer(s, &service.GateWayServer{})
+	hello.RegisterFileServiceServer(s, &service.FileServer{DownloadPath: downloadPath})
+	lis := bufconn.Listen(bufSize)
+	go s.Serve(lis)
+	return &inProcessServer{grpc: s, listener: lis}
+}
+
+func (s *inProcessServer) dial() (*grpc.ClientConn, error) {
+	return grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+}
+
+func (s *inProcessServer) stop() {
+	s.grpc.Stop()
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// CachedTokenSource 缓存token直到快要过期，可以被多个协程同时使用：
+// 距离过期不足 refreshBefore 时在后台刷新并继续使用旧token，已经过期时同步刷新
+type CachedTokenSource struct {
+	src           TokenSource
+	refreshBefore time.Duration
+
+	mu         sync.Mutex
+	token      *Token
+	refreshing chan struct{}
+	err        error
+}
+
+// NewCachedTokenSource 创建带缓存的 TokenSource
+func NewCachedTokenSource(src TokenSource, refreshBefore time.Duration) *CachedTokenSource {
+	return &CachedTokenSource{src: src, refreshBefore: refreshBefore}
+}
+
+func (c *CachedTokenSource) Token(ctx context.Context) (*Token, error) {
+	c.mu.Lock()
+	token := c.token
+	if token.Valid() {
+		if !token.Expiry.IsZero() && time.Until(token.Expiry) < c.refreshBefore {
+			// 提前刷新，不阻塞当前调用
+			c.refreshLocked()
+		}
+		c.mu.Unlock()
+		return token, nil
+	}
+	done := c.refreshLocked()
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.token.Valid() {
+		if c.err == nil {
+			return nil, errors.New("auth: token source returned an invalid token")
+		}
+		return nil, c.err
+	}
+	return c.token, nil
+}
+
+// Invalidate 丢弃缓存的token，服务端返回 Unauthenticated 时调用，下次获取时重新请求
+func (c *CachedTokenSource) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = nil
+}
+
+// refreshLocked 同一时间只有一个刷新请求，返回的通道在刷新结束时关闭
+func (c *CachedTokenSource) refreshLocked() <-chan struct{} {
+	if c.refreshing != nil {
+		return c.refreshing
+	}
+	done := make(chan struct{})
+	c.refreshing = done
+	go func() {
+		// 刷新不跟随某一次调用的上下文，避免调用取消后影响其他等待者
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		token, err := c.src.Token(ctx)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			log.Printf("auth: refresh token failed: %v", err)
+			c.err = err
+		} else {
+			c.token, c.err = token, nil
+		}
+		c.refreshing = nil
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,121 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSource 每次调用返回新的token，release 关闭前阻塞
+type countingSource struct {
+	calls   int32
+	ttl     time.Duration
+	err     error
+	release chan struct{}
+}
+
+func (s *countingSource) Token(ctx context.Context) (*auth.Token, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &auth.Token{AccessToken: string(rune('a' + n - 1)), Expiry: time.Now().Add(s.ttl)}, nil
+}
+
+func (s *countingSource) count() int {
+	return int(atomic.LoadInt32(&s.calls))
+}
+
+func TestCachedTokenSourceConcurrent(t *testing.T) {
+	src := &countingSource{ttl: time.Hour, release: make(chan struct{})}
+	c := auth.NewCachedTokenSource(src, time.Minute)
+
+	// 多个协程同时获取，只发起一次请求
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := c.Token(context.Background())
+			if err != nil {
+				t.Errorf("Token: %v", err)
+				return
+			}
+			tokens[i] = token.AccessToken
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(src.release)
+	wg.Wait()
+	if src.count() != 1 {
+		t.Errorf("source called %d times, want 1", src.count())
+	}
+	for _, token := range tokens {
+		if token != "a" {
+			t.Errorf("tokens = %v", tokens)
+			break
+		}
+	}
+
+	// 缓存的token没有过期时不再请求
+	if token, _ := c.Token(context.Background()); token.AccessToken != "a" || src.count() != 1 {
+		t.Errorf("cached token = %v, source calls = %d", token, src.count())
+	}
+}
+
+func TestCachedTokenSourceInvalidate(t *testing.T) {
+	src := &countingSource{ttl: time.Hour}
+	c := auth.NewCachedTokenSource(src, time.Minute)
+	ctx := context.Background()
+	if token, _ := c.Token(ctx); token.AccessToken != "a" {
+		t.Fatalf("token = %v", token)
+	}
+	c.Invalidate()
+	if token, _ := c.Token(ctx); token.AccessToken != "b" || src.count() != 2 {
+		t.Errorf("token after Invalidate = %v, source calls = %d", token, src.count())
+	}
+}
+
+func TestCachedTokenSourceRefreshBefore(t *testing.T) {
+	// 有效期小于 refreshBefore，每次获取都在后台刷新，当前调用使用旧token
+	src := &countingSource{ttl: 30 * time.Second}
+	c := auth.NewCachedTokenSource(src, time.Minute)
+	ctx := context.Background()
+	c.Token(ctx)
+	if token, _ := c.Token(ctx); token.AccessToken != "a" {
+		t.Errorf("token = %v, want the cached one", token)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for src.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if src.count() < 2 {
+		t.Error("token not refreshed in background")
+	}
+}
+
+func TestCachedTokenSourceError(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	c := auth.NewCachedTokenSource(&countingSource{err: errFetch}, time.Minute)
+	if _, err := c.Token(context.Background()); !errors.Is(err, errFetch) {
+		t.Errorf("Token = %v, want %v", err, errFetch)
+	}
+
+	// 等待刷新时调用被取消
+	src := &countingSource{ttl: time.Hour, release: make(chan struct{})}
+	defer close(src.release)
+	c = auth.NewCachedTokenSource(src, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Token(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Token = %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/keepon-online/go-grpc-example/config"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Token 访问服务端使用的token
+type Token struct {
+	AccessToken string
+	// Expiry 过期时间，零值表示不会过期
+	Expiry time.Time
+}
+
+// Valid token不为空且没有过期
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Before(t.Expiry))
+}
+
+// TokenSource 获取token，客户端不再需要知道服务端的签名密钥
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc 函数形式的 TokenSource
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource 固定的token，过期时间从 JWT 的 exp 中读取
+func StaticTokenSource(token string) TokenSource {
+	t := &Token{AccessToken: token, Expiry: jwtExpiry(token)}
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return t, nil
+	})
+}
+
+// FileTokenSource 每次从文件读取token，文件内容可以是token本身，
+// 也可以是 {"access_token": "...", "expires_in": 3600} 格式的 JSON（与令牌接口的响应相同）
+func FileTokenSource(path string) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && data[0] == '{' {
+			var resp tokenResponse
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return nil, fmt.Errorf("auth: parse token file %s: %w", path, err)
+			}
+			return resp.token(), nil
+		}
+		token := string(data)
+		if token == "" {
+			return nil, fmt.Errorf("auth: token file %s is empty", path)
+		}
+		return &Token{AccessToken: token, Expiry: jwtExpiry(token)}, nil
+	})
+}
+
+// tokenResponse 令牌接口的响应，字段与 OAuth2 client_credentials 一致
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (r tokenResponse) token() *Token {
+	t := &Token{AccessToken: r.AccessToken}
+	if r.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	} else {
+		t.Expiry = jwtExpiry(r.AccessToken)
+	}
+	return t
+}
+
+// EndpointTokenSource 使用 client_id 和 client_secret 从令牌接口获取token，
+// 接口见服务端网关的 POST /v1/auth/token
+func EndpointTokenSource(tokenURL, clientID, clientSecret string, client *http.Client) TokenSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("auth: fetch token: %w", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, fmt.Errorf("auth: fetch token: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("auth: fetch token: %s: %s", resp.Status, bytes.TrimSpace(body))
+		}
+		var tr tokenResponse
+		if err := json.Unmarshal(body, &tr); err != nil {
+			return nil, fmt.Errorf("auth: parse token response: %w", err)
+		}
+		if tr.AccessToken == "" {
+			return nil, errors.New("auth: token response without access_token")
+		}
+		return tr.token(), nil
+	})
+}
+
+// FromConfig 按配置选择获取token的方式，配置了 TokenURL 时使用令牌接口，否则读取 TokenFile，
+// 返回的 TokenSource 带缓存；两者都为空时返回 nil
+func FromConfig(cfg config.ClientAuth) TokenSource {
+	var src TokenSource
+	switch {
+	case cfg.TokenURL != "":
+		src = EndpointTokenSource(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret, nil)
+	case cfg.TokenFile != "":
+		src = FileTokenSource(cfg.TokenFile)
+	default:
+		return nil
+	}
+	return NewCachedTokenSource(src, cfg.RefreshBefore)
+}
+
+// jwtExpiry 读取 JWT 的 exp，只解析不校验签名，不是 JWT 时返回零值
+func jwtExpiry(token string) time.Time {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return claims.ExpiresAt.Time
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEndpointTokenSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || r.FormValue("grant_type") != "client_credentials" || id != "client" || secret != "s%3Dcret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"abc","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer srv.Close()
+
+	// client_secret 在 Basic 认证中先做表单编码
+	token, err := auth.EndpointTokenSource(srv.URL, "client", "s=cret", nil).Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if token.AccessToken != "abc" || time.Until(token.Expiry) < 59*time.Minute {
+		t.Errorf("token = %+v", token)
+	}
+	if _, err := auth.EndpointTokenSource(srv.URL, "client", "wrong", nil).Token(context.Background()); err == nil {
+		t.Error("wrong secret accepted")
+	}
+}
+
+func TestFileTokenSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "raw token", content: "abc\n", want: "abc"},
+		{name: "token response", content: `{"access_token":"abc","expires_in":60}`, want: "abc"},
+		{name: "empty", content: "  \n", wantErr: true},
+		{name: "invalid json", content: `{"access_token":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "token")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			token, err := auth.FileTokenSource(path).Token(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Token = %+v, want error", token)
+				}
+				return
+			}
+			if err != nil || token.AccessToken != tt.want {
+				t.Errorf("Token = %+v, %v", token, err)
+			}
+		})
+	}
+}
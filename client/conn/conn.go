@@ -0,0 +1,176 @@
+package conn
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/client/discovery"
+	"github.com/keepon-online/go-grpc-example/client/handler"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	// 注册客户端健康检查函数，service config 中的 healthCheckConfig 依赖它
+	_ "google.golang.org/grpc/health"
+	"strconv"
+	"time"
+)
+
+// Dial 按客户端配置连接 cfg.Target：
+// 地址解析和负载均衡、连接保活和消息大小、默认超时、幂等方法的重试和对冲请求，以及重试次数的日志和指标。
+// opts 中的拦截器排在内置拦截器之后执行
+func Dial(cfg config.Client, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	sc, err := ServiceConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	k := cfg.Keepalive.WithDefaults()
+	size := cfg.MaxMessageSize.WithDefaults()
+	dopts := []grpc.DialOption{
+		grpc.WithResolvers(discovery.Builders(cfg.Balancer.FileResolverInterval)...),
+		grpc.WithDefaultServiceConfig(sc),
+		// 服务端因为 maxConnectionAge 发送 GOAWAY 后重新解析地址并连接，负载均衡策略把新的调用分配到其他后端
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                k.Time,
+			Timeout:             k.Timeout,
+			PermitWithoutStream: k.PermitWithoutStream,
+		}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(size.Recv), grpc.MaxCallSendMsgSize(size.Send)),
+		grpc.WithStatsHandler(attemptStats{}),
+		grpc.WithChainUnaryInterceptor(
+			// 超时在最外层，包含所有重试和对冲请求
+			handler.UnaryClientInterceptorTimeout(cfg.Timeout),
+			UnaryClientInterceptorRetry(cfg),
+		),
+		grpc.WithChainStreamInterceptor(
+			handler.StreamClientInterceptorTimeout(cfg.Timeout),
+			StreamClientInterceptorRetry(),
+		),
+	}
+	return grpc.Dial(cfg.Target, append(dopts, opts...)...)
+}
+
+type serviceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig,omitempty"`
+	HealthCheckConfig   *healthCheckConfig    `json:"healthCheckConfig,omitempty"`
+	MethodConfig        []methodConfig        `json:"methodConfig,omitempty"`
+}
+
+type healthCheckConfig struct {
+	// ServiceName 为空表示检查整个服务端的健康状态
+	ServiceName string `json:"serviceName"`
+}
+
+type methodConfig struct {
+	Name        []methodName `json:"name"`
+	RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+}
+
+type methodName struct {
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+}
+
+type retryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+// ServiceConfig 根据配置生成 gRPC service config，包含负载均衡策略、健康检查和 retry.methods 中流式方法的重试策略。
+// 健康检查只对 round_robin、weighted 这类会为每个后端建立连接的策略生效。
+// gRPC 内置的重试在流收到第一条响应后就不再重试；一元方法的重试和对冲由拦截器实现，便于单次调用覆盖
+func ServiceConfig(cfg config.Client) (string, error) {
+	var sc serviceConfig
+	if policy := cfg.Balancer.Policy; policy != "" {
+		sc.LoadBalancingConfig = []map[string]struct{}{{policy: {}}}
+	}
+	if cfg.Balancer.HealthCheck {
+		sc.HealthCheckConfig = &healthCheckConfig{}
+	}
+	if p := cfg.Retry; len(p.Methods) > 0 || p.MaxAttempts > 1 {
+		if err := validateRetry(p); err != nil {
+			return "", err
+		}
+		if names := streamingMethods(p.Methods); len(names) > 0 {
+			sc.MethodConfig = append(sc.MethodConfig, methodConfig{
+				Name: names,
+				RetryPolicy: &retryPolicy{
+					MaxAttempts:          p.MaxAttempts,
+					InitialBackoff:       formatDuration(p.InitialBackoff),
+					MaxBackoff:           formatDuration(p.MaxBackoff),
+					BackoffMultiplier:    p.BackoffMultiplier,
+					RetryableStatusCodes: p.RetryableStatusCodes,
+				},
+			})
+		}
+	}
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// validateRetry 检查 gRPC 对 retryPolicy 的要求，不满足时 Dial 只会返回 service config 不合法，这里指出具体的字段
+func validateRetry(p config.RetryPolicy) error {
+	switch {
+	case p.MaxAttempts < 2:
+		return fmt.Errorf("invalid retry config: maxAttempts must be at least 2, got %d", p.MaxAttempts)
+	case p.InitialBackoff <= 0:
+		return fmt.Errorf("invalid retry config: initialBackoff must be positive, got %v", p.InitialBackoff)
+	case p.MaxBackoff <= 0:
+		return fmt.Errorf("invalid retry config: maxBackoff must be positive, got %v", p.MaxBackoff)
+	case p.BackoffMultiplier <= 0:
+		return fmt.Errorf("invalid retry config: backoffMultiplier must be positive, got %v", p.BackoffMultiplier)
+	case len(p.RetryableStatusCodes) == 0:
+		return fmt.Errorf("invalid retry config: retryableStatusCodes must not be empty")
+	}
+	if _, err := parseCodes(p.RetryableStatusCodes); err != nil {
+		return fmt.Errorf("invalid retry config: retryableStatusCodes: %w", err)
+	}
+	return nil
+}
+
+// streamingMethods 从已注册的 proto 文件中找出 methods 中的流式方法
+func streamingMethods(methods []string) []methodName {
+	want := methodSet(methods)
+	var names []methodName
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			sd := services.Get(i)
+			methods := sd.Methods()
+			for j := 0; j < methods.Len(); j++ {
+				md := methods.Get(j)
+				full := "/" + string(sd.FullName()) + "/" + string(md.Name())
+				if want[full] && (md.IsStreamingClient() || md.IsStreamingServer()) {
+					names = append(names, methodName{Service: string(sd.FullName()), Method: string(md.Name())})
+				}
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// formatDuration service config 中的时间格式为秒数加 s 后缀，例如 0.1s
+func formatDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// parseCodes 将 UNAVAILABLE 这样的状态码名称转换为 codes.Code
+func parseCodes(names []string) ([]codes.Code, error) {
+	cs := make([]codes.Code, 0, len(names))
+	for _, name := range names {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return nil, fmt.Errorf("invalid status code %q: %w", name, err)
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
@@ -0,0 +1,155 @@
+package conn_test
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"github.com/keepon-online/go-grpc-example/client/conn"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServiceConfig(t *testing.T) {
+	cfg := config.Client{
+		Balancer: config.Balancer{Policy: "round_robin", HealthCheck: true},
+		Retry: config.RetryPolicy{
+			Methods: []string{
+				"/hello.v1.FileService/DownLoadFile",
+				// 一元方法由拦截器重试，不出现在 service config 中
+				"/hello.v1.HelloService/SayHello",
+			},
+			MaxAttempts:          3,
+			InitialBackoff:       time.Millisecond,
+			MaxBackoff:           time.Second,
+			BackoffMultiplier:    2,
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		},
+	}
+	sc, err := conn.ServiceConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		LoadBalancingConfig []map[string]interface{}
+		HealthCheckConfig   *struct{ ServiceName string }
+		MethodConfig        []struct {
+			Name []struct{ Service, Method string }
+		}
+	}
+	if err := json.Unmarshal([]byte(sc), &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", sc, err)
+	}
+	if len(got.LoadBalancingConfig) != 1 || got.LoadBalancingConfig[0]["round_robin"] == nil || got.HealthCheckConfig == nil {
+		t.Errorf("service config = %s", sc)
+	}
+	if len(got.MethodConfig) != 1 || len(got.MethodConfig[0].Name) != 1 ||
+		got.MethodConfig[0].Name[0].Service != "hello.v1.FileService" || got.MethodConfig[0].Name[0].Method != "DownLoadFile" {
+		t.Errorf("method config = %s, want only DownLoadFile", sc)
+	}
+}
+
+// 不合法的重试策略在生成 service config 时报错，错误中指出字段
+func TestServiceConfigInvalidRetry(t *testing.T) {
+	valid := config.RetryPolicy{
+		Methods:              []string{"/hello.v1.FileService/DownLoadFile"},
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		MaxBackoff:           time.Second,
+		BackoffMultiplier:    2,
+		RetryableStatusCodes: []string{"UNAVAILABLE"},
+	}
+	tests := []struct {
+		name   string
+		modify func(*config.RetryPolicy)
+		field  string
+	}{
+		{name: "max attempts", modify: func(p *config.RetryPolicy) { p.MaxAttempts = 1 }, field: "maxAttempts"},
+		{name: "initial backoff", modify: func(p *config.RetryPolicy) { p.InitialBackoff = 0 }, field: "initialBackoff"},
+		{name: "max backoff", modify: func(p *config.RetryPolicy) { p.MaxBackoff = 0 }, field: "maxBackoff"},
+		{name: "backoff multiplier", modify: func(p *config.RetryPolicy) { p.BackoffMultiplier = 0 }, field: "backoffMultiplier"},
+		{name: "no status codes", modify: func(p *config.RetryPolicy) { p.RetryableStatusCodes = nil }, field: "retryableStatusCodes"},
+		{name: "invalid status code", modify: func(p *config.RetryPolicy) { p.RetryableStatusCodes = []string{"NOPE"} }, field: "retryableStatusCodes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.modify(&p)
+			_, err := conn.Dial(config.Client{Target: "passthrough:///bufnet", Retry: p},
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("Dial = %v, want error naming %s", err, tt.field)
+			}
+		})
+	}
+
+	// 没有配置重试时不检查
+	if _, err := conn.ServiceConfig(config.Client{}); err != nil {
+		t.Errorf("empty retry config: %v", err)
+	}
+}
+
+// flaky 服务端拦截器，每个方法的前 failures 次调用返回 code
+type flaky struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	failures int
+	code     codes.Code
+}
+
+func newFlaky(failures int, code codes.Code) *flaky {
+	return &flaky{calls: make(map[string]int), failures: failures, code: code}
+}
+
+func (f *flaky) call(method string) (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+	return f.calls[method], f.calls[method] <= f.failures
+}
+
+func (f *flaky) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *flaky) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if _, fail := f.call(info.FullMethod); fail {
+		return nil, status.Error(f.code, "flaky")
+	}
+	return handler(ctx, req)
+}
+
+// dial 通过 conn.Dial 连接测试服务端，使用和真实客户端相同的拦截器和 stats.Handler
+func dial(t *testing.T, s *servertest.Server, cfg config.Client) *grpc.ClientConn {
+	t.Helper()
+	cfg.Target = "passthrough:///bufnet"
+	cc, err := conn.Dial(cfg,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.Listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+// counter 读取 expvar 中方法的计数，指标是全局的，测试中比较前后的差值
+func counter(name, method string) int64 {
+	if v, ok := expvar.Get(name).(*expvar.Map).Get(method).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
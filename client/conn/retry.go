@@ -0,0 +1,228 @@
+package conn
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// callOption 自定义的单次调用选项，嵌入 EmptyCallOption 后 gRPC 会忽略它，由拦截器读取
+type callOption struct {
+	grpc.EmptyCallOption
+	apply func(*callOptions)
+}
+
+type callOptions struct {
+	retry          *config.RetryPolicy
+	disableRetry   bool
+	hedging        *config.HedgingPolicy
+	disableHedging bool
+}
+
+// WithRetryPolicy 单次调用覆盖重试策略，只对一元方法生效
+func WithRetryPolicy(p config.RetryPolicy) grpc.CallOption {
+	return callOption{apply: func(o *callOptions) { o.retry = &p }}
+}
+
+// DisableRetry 单次调用关闭重试和对冲请求，例如非幂等的写操作，只对一元方法生效
+func DisableRetry() grpc.CallOption {
+	return callOption{apply: func(o *callOptions) { o.disableRetry = true }}
+}
+
+// WithHedgingPolicy 单次调用启用对冲请求，只对一元方法生效
+func WithHedgingPolicy(p config.HedgingPolicy) grpc.CallOption {
+	return callOption{apply: func(o *callOptions) { o.hedging = &p }}
+}
+
+// DisableHedging 单次调用关闭对冲请求
+func DisableHedging() grpc.CallOption {
+	return callOption{apply: func(o *callOptions) { o.disableHedging = true }}
+}
+
+func collectCallOptions(opts []grpc.CallOption) callOptions {
+	var o callOptions
+	for _, opt := range opts {
+		if co, ok := opt.(callOption); ok {
+			co.apply(&o)
+		}
+	}
+	return o
+}
+
+// UnaryClientInterceptorRetry 一元方法的重试和对冲，retry.methods、hedging.methods 中的方法默认使用配置中的策略，
+// 其他方法只在单次调用通过 WithRetryPolicy、WithHedgingPolicy 开启时重试。
+// service config 只为流式方法设置了内置重试，这里不会和内置重试叠加
+func UnaryClientInterceptorRetry(cfg config.Client) grpc.UnaryClientInterceptor {
+	hedgingMethods := methodSet(cfg.Hedging.Methods)
+	retryMethods := methodSet(cfg.Retry.Methods)
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = withAttempts(ctx)
+		co := collectCallOptions(opts)
+
+		hedging := co.hedging
+		if hedging == nil && hedgingMethods[method] {
+			hedging = &cfg.Hedging
+		}
+		if hedging != nil && !co.disableHedging && !co.disableRetry && hedging.MaxAttempts > 1 {
+			return invokeHedging(ctx, *hedging, method, req, reply, cc, invoker, opts)
+		}
+		policy := cfg.Retry
+		if co.retry != nil {
+			policy = *co.retry
+		} else if !retryMethods[method] {
+			// 不知道是否幂等的方法默认不重试
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if co.disableRetry {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return invokeRetry(ctx, policy, method, req, reply, cc, invoker, opts)
+	}
+}
+
+func methodSet(methods []string) map[string]bool {
+	set := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		set[m] = true
+	}
+	return set
+}
+
+// StreamClientInterceptorRetry 流式方法使用 service config 中的内置重试，
+// 流收到第一条响应后 gRPC 不再重试，这里只为每次调用加上重试计数
+func StreamClientInterceptorRetry() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withAttempts(ctx), desc, cc, method, opts...)
+	}
+}
+
+// invokeRetry 按指数退避重试，服务端返回 RetryInfo 时使用服务端建议的间隔
+func invokeRetry(ctx context.Context, p config.RetryPolicy, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	retryable, err := parseCodes(p.RetryableStatusCodes)
+	if err != nil {
+		return err
+	}
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil || attempt >= p.MaxAttempts || !containsCode(retryable, status.Code(err)) {
+			return err
+		}
+		wait := retryDelay(err, backoff)
+		log.Printf("RPC: %s attempt %d failed: %v, retry after %v", method, attempt, err, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = time.Duration(float64(backoff) * p.BackoffMultiplier)
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// retryDelay 与 gRPC 内置重试一致，在 [0, backoff) 之间随机等待
+func retryDelay(err error, backoff time.Duration) time.Duration {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// invokeHedging 先发出一次请求，每隔 HedgingDelay 或遇到非致命错误时再发出一次，
+// 使用第一个成功的响应并取消其他请求
+func invokeHedging(ctx context.Context, p config.HedgingPolicy, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	nonFatal, err := parseCodes(p.NonFatalStatusCodes)
+	if err != nil {
+		return err
+	}
+	out, ok := reply.(proto.Message)
+	if !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	results := make(chan result, p.MaxAttempts)
+	launched := 0
+	launch := func() {
+		launched++
+		r := out.ProtoReflect().New().Interface()
+		go func() {
+			results <- result{reply: r, err: invoker(ctx, method, req, r, cc, opts...)}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(p.HedgingDelay)
+	defer timer.Stop()
+	finished := 0
+	for {
+		select {
+		case <-timer.C:
+			if launched < p.MaxAttempts {
+				launch()
+				timer.Reset(p.HedgingDelay)
+			}
+		case res := <-results:
+			finished++
+			if res.err == nil {
+				proto.Reset(out)
+				proto.Merge(out, res.reply)
+				return nil
+			}
+			if !containsCode(nonFatal, status.Code(res.err)) {
+				return res.err
+			}
+			if launched < p.MaxAttempts {
+				launch()
+				resetTimer(timer, p.HedgingDelay)
+			} else if finished == launched {
+				return res.err
+			}
+		}
+	}
+}
+
+// resetTimer 定时器可能已经触发但还没有被读取，重置前先清空
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+func containsCode(cs []codes.Code, c codes.Code) bool {
+	for _, v := range cs {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package conn_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/client/conn"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	sayHello   = "/hello.v1.HelloService/SayHello"
+	sayMessage = "/hello.v1.GatewayService/SayMessage"
+	download   = "/hello.v1.FileService/DownLoadFile"
+)
+
+var retryPolicy = config.RetryPolicy{
+	Methods:              []string{sayHello, download},
+	MaxAttempts:          3,
+	InitialBackoff:       time.Millisecond,
+	MaxBackoff:           10 * time.Millisecond,
+	BackoffMultiplier:    2,
+	RetryableStatusCodes: []string{"UNAVAILABLE"},
+}
+
+func TestUnaryRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		failures  int
+		code      codes.Code
+		opts      []grpc.CallOption
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{name: "retried", method: sayHello, failures: 2, code: codes.Unavailable, wantCalls: 3},
+		{name: "attempts exhausted", method: sayHello, failures: 5, code: codes.Unavailable, wantCalls: 3, wantCode: codes.Unavailable},
+		{name: "not retryable code", method: sayHello, failures: 1, code: codes.Internal, wantCalls: 1, wantCode: codes.Internal},
+		// 不在 retry.methods 中的方法默认不重试
+		{name: "method not listed", method: sayMessage, failures: 1, code: codes.Unavailable, wantCalls: 1, wantCode: codes.Unavailable},
+		{name: "per-call policy", method: sayMessage, failures: 1, code: codes.Unavailable,
+			opts: []grpc.CallOption{conn.WithRetryPolicy(retryPolicy)}, wantCalls: 2},
+		{name: "per-call disable", method: sayHello, failures: 1, code: codes.Unavailable,
+			opts: []grpc.CallOption{conn.DisableRetry()}, wantCalls: 1, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFlaky(tt.failures, tt.code)
+			s := servertest.Start(t, servertest.WithUnaryInterceptors(f.unary))
+			cc := dial(t, s, config.Client{Retry: retryPolicy})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			attempts, retries := counter("grpc_client_attempts_total", tt.method), counter("grpc_client_retries_total", tt.method)
+			var err error
+			if tt.method == sayHello {
+				_, err = hello.NewHelloServiceClient(cc).SayHello(ctx, &hello.HelloRequest{Name: "a"}, tt.opts...)
+			} else {
+				_, err = hello.NewGatewayServiceClient(cc).SayMessage(ctx, &hello.HelloRequest{Name: "a"}, tt.opts...)
+			}
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("call = %v, want %v", err, tt.wantCode)
+			}
+			if got := f.count(tt.method); got != tt.wantCalls {
+				t.Errorf("server calls = %d, want %d", got, tt.wantCalls)
+			}
+			if got := counter("grpc_client_attempts_total", tt.method) - attempts; got != int64(tt.wantCalls) {
+				t.Errorf("attempts metric = %d, want %d", got, tt.wantCalls)
+			}
+			if got := counter("grpc_client_retries_total", tt.method) - retries; got != int64(tt.wantCalls-1) {
+				t.Errorf("retries metric = %d, want %d", got, tt.wantCalls-1)
+			}
+		})
+	}
+}
+
+// slowFirst 第一次调用等待 delay 或者被取消，之后的调用立即返回
+func slowFirst(f *flaky, delay time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if n, _ := f.call(info.FullMethod); n == 1 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func TestHedging(t *testing.T) {
+	hedging := config.HedgingPolicy{
+		Methods:             []string{sayHello},
+		MaxAttempts:         3,
+		HedgingDelay:        20 * time.Millisecond,
+		NonFatalStatusCodes: []string{"UNAVAILABLE"},
+	}
+	t.Run("slow attempt is hedged", func(t *testing.T) {
+		f := newFlaky(0, codes.OK)
+		s := servertest.Start(t, servertest.WithUnaryInterceptors(slowFirst(f, 5*time.Second)))
+		cc := dial(t, s, config.Client{Hedging: hedging})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		resp, err := hello.NewHelloServiceClient(cc).SayHello(ctx, &hello.HelloRequest{Name: "a"})
+		if err != nil {
+			t.Fatalf("SayHello: %v", err)
+		}
+		// 使用的是第二次请求的响应
+		if resp.GetName() != "a" {
+			t.Errorf("response = %v", resp)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("hedged call took %v", d)
+		}
+		if got := f.count(sayHello); got != 2 {
+			t.Errorf("server calls = %d, want 2", got)
+		}
+	})
+
+	t.Run("non-fatal error hedges immediately", func(t *testing.T) {
+		f := newFlaky(1, codes.Unavailable)
+		s := servertest.Start(t, servertest.WithUnaryInterceptors(f.unary))
+		slow := hedging
+		slow.HedgingDelay = time.Minute
+		cc := dial(t, s, config.Client{Hedging: slow})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := hello.NewHelloServiceClient(cc).SayHello(ctx, &hello.HelloRequest{Name: "a"}); err != nil {
+			t.Fatalf("SayHello: %v", err)
+		}
+		if got := f.count(sayHello); got != 2 {
+			t.Errorf("server calls = %d, want 2", got)
+		}
+	})
+
+	t.Run("fatal error", func(t *testing.T) {
+		f := newFlaky(1, codes.Internal)
+		s := servertest.Start(t, servertest.WithUnaryInterceptors(f.unary))
+		cc := dial(t, s, config.Client{Hedging: hedging})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := hello.NewHelloServiceClient(cc).SayHello(ctx, &hello.HelloRequest{Name: "a"}); status.Code(err) != codes.Internal {
+			t.Fatalf("SayHello = %v, want Internal", err)
+		}
+	})
+
+	// DisableRetry 用于非幂等的调用，同样关闭对冲
+	disables := []struct {
+		name string
+		opt  grpc.CallOption
+	}{
+		{name: "per-call disable", opt: conn.DisableHedging()},
+		{name: "disable retry", opt: conn.DisableRetry()},
+	}
+	for _, tt := range disables {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFlaky(0, codes.OK)
+			s := servertest.Start(t, servertest.WithUnaryInterceptors(slowFirst(f, 100*time.Millisecond)))
+			cc := dial(t, s, config.Client{Hedging: hedging})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := hello.NewHelloServiceClient(cc).SayHello(ctx, &hello.HelloRequest{Name: "a"}, tt.opt); err != nil {
+				t.Fatalf("SayHello: %v", err)
+			}
+			if got := f.count(sayHello); got != 1 {
+				t.Errorf("server calls = %d, want 1", got)
+			}
+		})
+	}
+}
+
+// 流式方法使用 gRPC 内置的重试，只在还没有收到响应时重试
+func TestStreamRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "download")
+	if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// sendFirst 失败前先发送一条消息
+		sendFirst bool
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "retried before first message", wantCalls: 2},
+		{name: "not retried after first message", sendFirst: true, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFlaky(1, codes.Unavailable)
+			fail := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if _, fail := f.call(info.FullMethod); fail {
+					if tt.sendFirst {
+						ss.SendMsg(&hello.FileResponse{Content: []byte("partial")})
+					}
+					return status.Error(codes.Unavailable, "flaky")
+				}
+				return handler(srv, ss)
+			}
+			s := servertest.Start(t, servertest.WithStreamInterceptors(fail),
+				servertest.WithFileServer(service.FileServer{DownloadPath: path}))
+			cc := dial(t, s, config.Client{Retry: retryPolicy})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			stream, err := hello.NewFileServiceClient(cc).DownLoadFile(ctx, &hello.HelloRequest{})
+			if err != nil {
+				t.Fatalf("DownLoadFile: %v", err)
+			}
+			var got []byte
+			for {
+				msg, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if !tt.wantErr || status.Code(err) != codes.Unavailable {
+						t.Fatalf("Recv = %v", err)
+					}
+					break
+				}
+				got = append(got, msg.GetContent()...)
+			}
+			if !tt.wantErr && string(got) != "content" {
+				t.Errorf("downloaded %q", got)
+			}
+			if n := f.count(download); n != tt.wantCalls {
+				t.Errorf("server calls = %d, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
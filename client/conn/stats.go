@@ -0,0 +1,62 @@
+package conn
+
+import (
+	"context"
+	"expvar"
+	"google.golang.org/grpc/stats"
+	"log"
+	"sync/atomic"
+)
+
+var (
+	// attemptsTotal 每个方法发出的请求次数，包含重试和对冲请求
+	attemptsTotal = expvar.NewMap("grpc_client_attempts_total")
+	// retriesTotal 每个方法的重试（含对冲）次数
+	retriesTotal = expvar.NewMap("grpc_client_retries_total")
+)
+
+type attemptsKey struct{}
+
+type rpcAttempts struct {
+	n int32
+}
+
+// withAttempts 在一次调用的上下文中放入计数器，gRPC 每次尝试都会回调 stats.Handler
+func withAttempts(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(attemptsKey{}).(*rpcAttempts); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, attemptsKey{}, &rpcAttempts{})
+}
+
+type methodKey struct{}
+
+// attemptStats 统计每次调用实际发出的请求次数，记录重试日志和指标
+type attemptStats struct{}
+
+func (attemptStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+func (attemptStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	begin, ok := s.(*stats.Begin)
+	if !ok || !begin.Client {
+		return
+	}
+	method, _ := ctx.Value(methodKey{}).(string)
+	attemptsTotal.Add(method, 1)
+	counter, ok := ctx.Value(attemptsKey{}).(*rpcAttempts)
+	if !ok {
+		return
+	}
+	if n := atomic.AddInt32(&counter.n, 1); n > 1 {
+		retriesTotal.Add(method, 1)
+		log.Printf("RPC: %s attempt %d (transparent: %v)", method, n, begin.IsTransparentRetryAttempt)
+	}
+}
+
+func (attemptStats) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (attemptStats) HandleConn(ctx context.Context, s stats.ConnStats) {}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/weightedroundrobin"
+	"sync"
+)
+
+// WeightedName 按解析器给出的权重轮询的负载均衡策略
+const WeightedName = "weighted"
+
+func init() {
+	// 负载均衡策略只能全局注册，在 service config 的 loadBalancingConfig 中按名称选择
+	balancer.Register(base.NewBalancerBuilder(WeightedName, weightedPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type weightedPickerBuilder struct{}
+
+func (weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &weightedPicker{}
+	for sc, scInfo := range info.ReadySCs {
+		weight := int(weightedroundrobin.GetAddrInfo(scInfo.Address).Weight)
+		if weight <= 0 {
+			weight = 1
+		}
+		p.items = append(p.items, &weightedItem{sc: sc, weight: weight})
+		p.total += weight
+	}
+	return p
+}
+
+type weightedItem struct {
+	sc      balancer.SubConn
+	weight  int
+	current int
+}
+
+// weightedPicker 平滑加权轮询，权重高的连接不会被连续选中
+type weightedPicker struct {
+	mu    sync.Mutex
+	items []*weightedItem
+	total int
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *weightedItem
+	for _, item := range p.items {
+		item.current += item.weight
+		if best == nil || item.current > best.current {
+			best = item
+		}
+	}
+	best.current -= p.total
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
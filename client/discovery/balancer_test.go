@@ -0,0 +1,102 @@
+package discovery_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/client/conn"
+	"github.com/keepon-online/go-grpc-example/client/discovery"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 三个后端的权重为 5、1、1，每 7 次调用按 a a b a c a a 的顺序分配
+func TestWeightedBalancer(t *testing.T) {
+	var mu sync.Mutex
+	var picked []string
+	backends := make(map[string]*servertest.Server)
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		record := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			mu.Lock()
+			picked = append(picked, name)
+			mu.Unlock()
+			return handler(ctx, req)
+		}
+		backends[name] = servertest.Start(t, servertest.WithUnaryInterceptors(record))
+	}
+	cc, err := conn.Dial(config.Client{
+		Target:   "static:///a=5,b,c",
+		Balancer: config.Balancer{Policy: discovery.WeightedName},
+	},
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return backends[addr].Listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := hello.NewHelloServiceClient(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call := func() {
+		if _, err := client.SayHello(ctx, &hello.HelloRequest{Name: "a"}); err != nil {
+			t.Fatalf("SayHello: %v", err)
+		}
+	}
+
+	// 等待三个后端都连接上，之后的调用使用同一个 picker
+	seen := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		all := strings.Join(picked, "")
+		return strings.Contains(all, "a") && strings.Contains(all, "b") && strings.Contains(all, "c")
+	}
+	for !seen() {
+		call()
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	picked = nil
+	mu.Unlock()
+
+	const rounds = 10
+	for i := 0; i < 7*rounds; i++ {
+		call()
+	}
+	counts := make(map[string]int)
+	run, maxRun := 0, 0
+	for i, name := range picked {
+		counts[name]++
+		if name == "a" {
+			run++
+		} else {
+			run = 0
+		}
+		if run > maxRun {
+			maxRun = run
+		}
+		// 任意连续 7 次调用都按权重分配
+		if i >= 6 {
+			window := strings.Join(picked[i-6:i+1], "")
+			if strings.Count(window, "a") != 5 || strings.Count(window, "b") != 1 {
+				t.Fatalf("picks %v: window %q not weighted", picked, window)
+			}
+		}
+	}
+	if counts["a"] != 5*rounds || counts["b"] != rounds || counts["c"] != rounds {
+		t.Errorf("counts = %v", counts)
+	}
+	// 平滑：权重 5 的后端最多连续被选中 4 次，普通加权轮询会连续 5 次
+	if maxRun > 4 {
+		t.Errorf("a picked %d times in a row: %v", maxRun, picked)
+	}
+}
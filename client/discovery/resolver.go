@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"google.golang.org/grpc/balancer/weightedroundrobin"
+	"google.golang.org/grpc/resolver"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StaticScheme 静态地址列表，例如 static:///10.0.0.1:8080,10.0.0.2:8080=3
+	StaticScheme = "static"
+	// FileScheme 从文件读取地址列表并监听文件变化，例如 file:///etc/hello/backends.txt
+	FileScheme = "file"
+)
+
+// Builders 返回自定义的解析器，通过 grpc.WithResolvers 注册到单个连接，
+// dns:/// 使用 gRPC 内置的解析器
+func Builders(fileInterval time.Duration) []resolver.Builder {
+	return []resolver.Builder{
+		staticBuilder{},
+		fileBuilder{interval: fileInterval},
+	}
+}
+
+// parseAddress 解析 host:port=weight 格式的地址，权重默认为 1
+func parseAddress(s string) (resolver.Address, error) {
+	addr, weight := s, uint32(1)
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		w, err := strconv.ParseUint(s[i+1:], 10, 32)
+		if err != nil || w == 0 {
+			return resolver.Address{}, fmt.Errorf("invalid weight in %q", s)
+		}
+		addr, weight = s[:i], uint32(w)
+	}
+	return weightedroundrobin.SetAddrInfo(resolver.Address{Addr: addr}, weightedroundrobin.AddrInfo{Weight: weight}), nil
+}
+
+type staticBuilder struct{}
+
+func (staticBuilder) Scheme() string {
+	return StaticScheme
+}
+
+func (staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	var addrs []resolver.Address
+	for _, s := range strings.Split(target.Endpoint(), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		addr, err := parseAddress(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("static resolver: no address in target %q", target.URL.String())
+	}
+	if err := cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		return nil, err
+	}
+	return nopResolver{}, nil
+}
+
+type nopResolver struct{}
+
+func (nopResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (nopResolver) Close() {}
+
+type fileBuilder struct {
+	interval time.Duration
+}
+
+func (fileBuilder) Scheme() string {
+	return FileScheme
+}
+
+// Build 文件每行一个地址，格式为 host:port [weight]，# 开头的行为注释
+func (b fileBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	interval := b.interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	// file:///etc/backends.txt 为绝对路径，file://conf/backends.txt 为相对路径
+	path := target.URL.Path
+	if target.URL.Host != "" {
+		path = target.URL.Host + path
+	}
+	r := &fileResolver{
+		path:     path,
+		cc:       cc,
+		interval: interval,
+		resolve:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if err := r.update(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// fileResolver 定时检查文件内容，变化时更新地址列表，用于测试时手动增删后端
+type fileResolver struct {
+	path     string
+	cc       resolver.ClientConn
+	interval time.Duration
+	last     []byte
+	resolve  chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (r *fileResolver) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		case <-r.resolve:
+		}
+		if err := r.update(); err != nil {
+			log.Printf("file resolver %s: %v", r.path, err)
+			r.cc.ReportError(err)
+		}
+	}
+}
+
+func (r *fileResolver) update() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if r.last != nil && bytes.Equal(data, r.last) {
+		return nil
+	}
+	var addrs []resolver.Address
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addr, err := parseAddress(strings.Join(strings.Fields(line), "="))
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+	}
+	r.last = data
+	return r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+func (r *fileResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolve <- struct{}{}:
+	default:
+	}
+}
+
+func (r *fileResolver) Close() {
+	r.once.Do(func() { close(r.done) })
+}
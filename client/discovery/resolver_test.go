@@ -0,0 +1,131 @@
+package discovery_test
+
+import (
+	"github.com/keepon-online/go-grpc-example/client/discovery"
+	"google.golang.org/grpc/balancer/weightedroundrobin"
+	"google.golang.org/grpc/resolver"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClientConn 记录解析器更新的地址
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func newFakeClientConn() *fakeClientConn {
+	return &fakeClientConn{states: make(chan resolver.State, 10)}
+}
+
+func (cc *fakeClientConn) UpdateState(s resolver.State) error {
+	cc.states <- s
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(error) {}
+
+// wait 等待下一次更新，返回 host:port=weight 格式的地址
+func (cc *fakeClientConn) wait(t *testing.T) []string {
+	t.Helper()
+	select {
+	case s := <-cc.states:
+		var addrs []string
+		for _, a := range s.Addresses {
+			addrs = append(addrs, a.Addr+"="+strconv.FormatUint(uint64(weightedroundrobin.GetAddrInfo(a).Weight), 10))
+		}
+		return addrs
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resolver update")
+		return nil
+	}
+}
+
+func build(t *testing.T, target string, interval time.Duration, cc resolver.ClientConn) (resolver.Resolver, error) {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range discovery.Builders(interval) {
+		if b.Scheme() == u.Scheme {
+			return b.Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+		}
+	}
+	t.Fatalf("no resolver for %s", target)
+	return nil, nil
+}
+
+func TestStaticResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "default weight", target: "static:///10.0.0.1:8080,10.0.0.2:8080", want: []string{"10.0.0.1:8080=1", "10.0.0.2:8080=1"}},
+		{name: "weights", target: "static:///a:1=3, b:2 ,c:3=1", want: []string{"a:1=3", "b:2=1", "c:3=1"}},
+		{name: "empty", target: "static:///", wantErr: true},
+		{name: "zero weight", target: "static:///a:1=0", wantErr: true},
+		{name: "invalid weight", target: "static:///a:1=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newFakeClientConn()
+			r, err := build(t, tt.target, 0, cc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Build succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+			defer r.Close()
+			if got := cc.wait(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addresses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.txt")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# 后端列表\n10.0.0.1:8080\n\n10.0.0.2:8080 3\n")
+
+	cc := newFakeClientConn()
+	r, err := build(t, "file://"+path, 10*time.Millisecond, cc)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	defer r.Close()
+	if got, want := cc.wait(t), []string{"10.0.0.1:8080=1", "10.0.0.2:8080=3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("addresses = %v, want %v", got, want)
+	}
+
+	// 文件变化后更新地址列表
+	write("10.0.0.3:8080 2\n")
+	if got, want := cc.wait(t), []string{"10.0.0.3:8080=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("addresses after update = %v, want %v", got, want)
+	}
+
+	// 文件不存在或者格式错误时无法创建
+	if _, err := build(t, "file://"+filepath.Join(t.TempDir(), "missing"), 0, newFakeClientConn()); err == nil {
+		t.Error("missing file accepted")
+	}
+	write("10.0.0.1:8080 x\n")
+	if _, err := build(t, "file://"+path, 0, newFakeClientConn()); err == nil {
+		t.Error("invalid weight accepted")
+	}
+}
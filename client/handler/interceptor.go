@@ -2,12 +2,14 @@ package handler
 
 import (
 	"context"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/client/auth"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"log"
-	"math/rand"
 	"runtime"
-	"strconv"
 	"time"
 )
 
@@ -87,25 +89,80 @@ func (e *streamClient) SendMsg(m interface{}) error {
 	return nil
 }
 
-// Token token认证
+// Token token认证，每次调用时从 Source 获取token
 type Token struct {
-	Uid   string
-	Token string
+	Uid    string
+	Source auth.TokenSource
+	// Insecure 允许在没有 TLS 的连接上发送token，只用于本地测试
+	Insecure bool
 }
 
 // GetRequestMetadata 获取当前请求认证所需的元数据（metadata）
 func (t *Token) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	// 设置一个种子
-	rand.Seed(time.Now().UnixNano())
-	// Intn返回一个取值范围在[0,n)的伪随机int值
-	num := rand.Intn(100) + 1 // 随机1-100
-	rangeSeed := strconv.Itoa(num)
-	log.Println("GetRequestMetadata 每次访问服务端方法都会被调用 添加自定义认证", rangeSeed)
-
-	return map[string]string{"uid": t.Uid, "token": t.Token, "range_seed": rangeSeed}, nil
+	token, err := t.Source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+	return map[string]string{"uid": t.Uid, "token": token.AccessToken}, nil
 }
 
 // RequireTransportSecurity 是否需要基于 TLS 认证进行安全传输,返回false不进行TLS验证
 func (t *Token) RequireTransportSecurity() bool {
-	return true
+	return !t.Insecure
+}
+
+// invalidator 可以丢弃缓存token的 TokenSource，例如 auth.CachedTokenSource
+type invalidator interface {
+	Invalidate()
+}
+
+// UnaryClientInterceptorReauth 服务端返回 Unauthenticated 时丢弃缓存的token并重试一次
+func UnaryClientInterceptorReauth(src auth.TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+		inv, ok := src.(invalidator)
+		if !ok {
+			return err
+		}
+		log.Printf("RPC: %s unauthenticated, refresh token and retry: %v", method, err)
+		inv.Invalidate()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptorReauth 流已经发出的消息无法重放，收到 Unauthenticated 时只丢弃缓存的token，
+// 下一次调用会使用新的token
+func StreamClientInterceptorReauth(src auth.TokenSource) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		inv, ok := src.(invalidator)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if !ok {
+			return stream, err
+		}
+		if status.Code(err) == codes.Unauthenticated {
+			inv.Invalidate()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &reauthStream{ClientStream: stream, inv: inv}, nil
+	}
+}
+
+type reauthStream struct {
+	grpc.ClientStream
+	inv invalidator
+}
+
+func (s *reauthStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if status.Code(err) == codes.Unauthenticated {
+		s.inv.Invalidate()
+	}
+	return err
 }
@@ -0,0 +1,108 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	"github.com/keepon-online/go-grpc-example/client/handler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// invalidatingSource 记录 Invalidate 的次数
+type invalidatingSource struct {
+	auth.TokenSource
+	invalidated int
+}
+
+func (s *invalidatingSource) Invalidate() {
+	s.invalidated++
+}
+
+func TestUnaryClientInterceptorReauth(t *testing.T) {
+	unauthenticated := status.Error(codes.Unauthenticated, "token expired")
+	tests := []struct {
+		name string
+		// errs 每次调用返回的错误，超出后返回 nil
+		errs        []error
+		cacheless   bool
+		wantCalls   int
+		wantCode    codes.Code
+		wantInvalid int
+	}{
+		{name: "ok", wantCalls: 1},
+		{name: "retried once", errs: []error{unauthenticated}, wantCalls: 2, wantInvalid: 1},
+		{name: "still unauthenticated", errs: []error{unauthenticated, unauthenticated}, wantCalls: 2, wantCode: codes.Unauthenticated, wantInvalid: 1},
+		{name: "other error", errs: []error{status.Error(codes.Unavailable, "down")}, wantCalls: 1, wantCode: codes.Unavailable},
+		// 没有缓存时重新获取也是同一个token，不重试
+		{name: "source without cache", errs: []error{unauthenticated}, cacheless: true, wantCalls: 1, wantCode: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			}
+			src := &invalidatingSource{TokenSource: auth.StaticTokenSource("token")}
+			var interceptor grpc.UnaryClientInterceptor
+			if tt.cacheless {
+				interceptor = handler.UnaryClientInterceptorReauth(src.TokenSource)
+			} else {
+				interceptor = handler.UnaryClientInterceptorReauth(src)
+			}
+			err := interceptor(context.Background(), "/m", nil, nil, nil, invoker)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("err = %v, want %v", err, tt.wantCode)
+			}
+			if calls != tt.wantCalls || src.invalidated != tt.wantInvalid {
+				t.Errorf("calls = %d, invalidated = %d, want %d, %d", calls, src.invalidated, tt.wantCalls, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+// unauthenticatedStream RecvMsg 返回 Unauthenticated 的流
+type unauthenticatedStream struct {
+	grpc.ClientStream
+}
+
+func (unauthenticatedStream) RecvMsg(interface{}) error {
+	return status.Error(codes.Unauthenticated, "token expired")
+}
+
+func TestStreamClientInterceptorReauth(t *testing.T) {
+	src := &invalidatingSource{TokenSource: auth.StaticTokenSource("token")}
+	interceptor := handler.StreamClientInterceptorReauth(src)
+
+	// 流不能重放，只丢弃缓存的token
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return unauthenticatedStream{}, nil
+	}
+	stream, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/m", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RecvMsg(nil); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RecvMsg = %v", err)
+	}
+	if src.invalidated != 1 {
+		t.Errorf("invalidated = %d after RecvMsg, want 1", src.invalidated)
+	}
+
+	calls := 0
+	failing := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		calls++
+		return nil, status.Error(codes.Unauthenticated, "token expired")
+	}
+	if _, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/m", failing); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stream = %v", err)
+	}
+	if calls != 1 || src.invalidated != 2 {
+		t.Errorf("calls = %d, invalidated = %d, want 1, 2", calls, src.invalidated)
+	}
+}
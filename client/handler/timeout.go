@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"sync"
+)
+
+// UnaryClientInterceptorTimeout 调用方没有设置截止时间时按方法设置默认超时
+func UnaryClientInterceptorTimeout(cfg config.Timeout) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			if timeout := cfg.ForMethod(method); timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptorTimeout 流式调用的默认超时，流结束（RecvMsg 返回错误或 io.EOF）时释放定时器
+func StreamClientInterceptorTimeout(cfg config.Timeout) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		timeout := cfg.ForMethod(method)
+		if timeout <= 0 {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &timeoutStream{ClientStream: stream, cancel: cancel}, nil
+	}
+}
+
+type timeoutStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (s *timeoutStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(s.cancel)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/client/handler"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"io"
+	"testing"
+	"time"
+)
+
+var timeoutConfig = config.Timeout{
+	Default: 10 * time.Second,
+	Methods: map[string]time.Duration{"/slow": time.Minute},
+}
+
+func TestUnaryClientInterceptorTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.Timeout
+		method string
+		caller time.Duration
+		// want 0 表示没有截止时间
+		want time.Duration
+	}{
+		{name: "default", cfg: timeoutConfig, method: "/m", want: 10 * time.Second},
+		{name: "method", cfg: timeoutConfig, method: "/slow", want: time.Minute},
+		{name: "caller deadline kept", cfg: timeoutConfig, method: "/m", caller: time.Hour, want: time.Hour},
+		{name: "no timeout", method: "/m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.caller > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.caller)
+				defer cancel()
+			}
+			var got time.Duration
+			var ok bool
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				var deadline time.Time
+				deadline, ok = ctx.Deadline()
+				got = time.Until(deadline)
+				return nil
+			}
+			handler.UnaryClientInterceptorTimeout(tt.cfg)(ctx, tt.method, nil, nil, nil, invoker)
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("deadline set: %v", got)
+				}
+				return
+			}
+			if !ok || got > tt.want || got < tt.want-time.Second {
+				t.Errorf("deadline in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// eofStream 第一次 RecvMsg 就结束的流
+type eofStream struct {
+	grpc.ClientStream
+}
+
+func (eofStream) RecvMsg(interface{}) error {
+	return io.EOF
+}
+
+func TestStreamClientInterceptorTimeout(t *testing.T) {
+	var streamCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return eofStream{}, nil
+	}
+	stream, err := handler.StreamClientInterceptorTimeout(timeoutConfig)(context.Background(), &grpc.StreamDesc{}, nil, "/slow", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline, ok := streamCtx.Deadline()
+	if !ok || time.Until(deadline) > time.Minute || time.Until(deadline) < time.Minute-time.Second {
+		t.Errorf("stream deadline in %v, want 1m", time.Until(deadline))
+	}
+	// 流结束后释放定时器
+	if err := stream.RecvMsg(nil); err != io.EOF {
+		t.Fatalf("RecvMsg = %v", err)
+	}
+	if streamCtx.Err() != context.Canceled {
+		t.Errorf("context after end = %v, want canceled", streamCtx.Err())
+	}
+
+	// 调用方设置了截止时间时不修改
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	handler.StreamClientInterceptorTimeout(timeoutConfig)(ctx, &grpc.StreamDesc{}, nil, "/m", streamer)
+	if streamCtx != ctx {
+		t.Error("caller context replaced")
+	}
+}
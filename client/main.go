@@ -3,210 +3,193 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
-	"github.com/keepon-online/go-grpc-example/client/handler"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	"github.com/keepon-online/go-grpc-example/client/sdk"
+	"github.com/keepon-online/go-grpc-example/config"
 	"github.com/keepon-online/go-grpc-example/gen/hello"
-	"github.com/keepon-online/go-grpc-example/util"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 	"io"
 	"log"
 	"os"
 	"time"
 )
 
-func main() {
-	addr := "192.168.2.166:8080"
-	// 使用 grpc.Dial 创建一个到指定地址的 gRPC 连接。
-	// 此处使用不安全的证书来实现 SSL/TLS 连接
-	//构建Token
-	token := handler.Token{
-		Uid:   "1234",
-		Token: token(),
-	}
-	creds, _ := credentials.NewClientTLSFromFile("conf/server.crt", "")
+var (
+	configFile   = flag.String("config", "conf/config.yaml", "配置文件路径")
+	downloadPath = flag.String("download", "./server.cert", "下载文件保存路径")
+)
 
-	conn, err := grpc.Dial(addr,
-		grpc.WithTransportCredentials(creds),
-		grpc.WithPerRPCCredentials(&token),
+func main() {
+	flag.Parse()
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config %s: %v", *configFile, err)
+	}
+	// token 从令牌接口或文件获取，签名密钥只保存在服务端
+	tokens := auth.FromConfig(cfg.Client.Auth)
+	if tokens == nil {
+		log.Fatalf("client.auth 需要配置 tokenURL 或 tokenFile")
+	}
+	// sdk.New 会按配置解析地址、负载均衡，并加上默认超时、重试策略和对冲请求
+	client, err := sdk.New(
+		sdk.WithConfig(cfg.Client),
+		// 使用服务端证书校验 SSL/TLS 连接
+		sdk.WithTLS("conf/server.crt", cfg.Client.ServerName),
+		// token 缓存到快要过期时自动刷新
+		sdk.WithTokenSource("1234", tokens),
 		//普通拦截器
-		grpc.WithChainUnaryInterceptor(
+		sdk.WithUnaryInterceptors(
 		//handler.UnaryClientInterceptor(),
 		//handler.UnaryClientInterceptorTwo()
 		),
 		//流式拦截器
-		//grpc.WithStreamInterceptor(handler.StreamClientInterceptor()),
+		//sdk.WithStreamInterceptors(handler.StreamClientInterceptor()),
 	)
 	if err != nil {
-		log.Fatalf(fmt.Sprintf("grpc connect addr [%s] 连接失败 %s", addr, err))
+		log.Fatalf(fmt.Sprintf("grpc connect addr [%s] 连接失败 %s", cfg.Client.Target, err))
 	}
-	defer conn.Close()
-	// 初始化客户端
-	client := hello.NewHelloServiceClient(conn)
-	gatewayServiceClient := hello.NewGatewayServiceClient(conn)
-	fileServiceClient := hello.NewFileServiceClient(conn)
+	defer client.Close()
 	helloRequest := hello.HelloRequest{
 		Name:    "鲁迪",
 		Message: "ok",
 	}
-	downloadFile(fileServiceClient)
-	uploadFile(fileServiceClient)
-	sayMessage(gatewayServiceClient)
+	// 单个调用失败只记录日志，继续执行后面的示例
+	if err := downloadFile(client, *downloadPath); err != nil {
+		log.Printf("downloadFile failed: %v", err)
+	}
+	if err := uploadFile(client); err != nil {
+		log.Printf("uploadFile failed: %v", err)
+	}
+	if err := sayMessage(client); err != nil {
+		log.Printf("sayMessage failed: %v", err)
+	}
 	result, err := client.SayHello(context.Background(), &helloRequest)
+	if err != nil {
+		log.Printf("SayHello failed: %v", err)
+	}
 	fmt.Println(result)
 	//接收服务端流
-	runLotsOfReplies(client, &helloRequest)
+	if err := runLotsOfReplies(client, &helloRequest); err != nil {
+		log.Printf("runLotsOfReplies failed: %v", err)
+	}
 	//向服务端发送流
-	runLotsOfGreeting(client)
+	if err := runLotsOfGreeting(client); err != nil {
+		log.Printf("runLotsOfGreeting failed: %v", err)
+	}
 	// 双向流数据
-	runBidiHello(client)
-}
-
-func token() string {
-	j := util.NewJWT()
-	claims := j.CreateClaims(util.BaseClaims{
-		ID:       1,
-		Username: "hello",
-	})
-	createToken, _ := j.CreateToken(claims)
-	fmt.Println(createToken)
-	return createToken
+	if err := runBidiHello(client); err != nil {
+		log.Printf("runBidiHello failed: %v", err)
+	}
 }
 
 // 接收服务端流
-func runLotsOfReplies(c hello.HelloServiceClient, request *hello.HelloRequest) {
+func runLotsOfReplies(c *sdk.Client, request *hello.HelloRequest) error {
 	// server端流式RPC
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	stream, err := c.LotsOfReplies(ctx, request)
+	it, err := c.LotsOfReplies(ctx, request)
 	if err != nil {
-		log.Fatalf("c.LotsOfReplies failed, err: %v", err)
+		return err
 	}
-	for {
-		// 接收服务端返回的流式数据，当收到io.EOF或错误时退出
-		res, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatalf("c.LotsOfReplies failed, err: %v", err)
-		}
-		log.Printf("接收服务端流 reply: %q\n", res.GetName())
+	// 接收服务端返回的流式数据，流结束或出错时退出
+	for it.Next() {
+		log.Printf("接收服务端流 reply: %q\n", it.Reply().GetName())
 	}
+	return it.Err()
 }
 
 // 向服务端发送流
-func runLotsOfGreeting(c hello.HelloServiceClient) {
+func runLotsOfGreeting(c *sdk.Client) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	// 客户端流式RPC
-	stream, err := c.LotsOfGreetings(ctx)
-	if err != nil {
-		log.Fatalf("c.LotsOfGreetings failed, err: %v", err)
-	}
-	names := []string{"孙悟空", "齐天大圣", "弼马温"}
-	for _, name := range names {
-		// 发送流式数据
-		err := stream.Send(&hello.HelloRequest{
-			Name: name,
-		})
-		if err != nil {
-			log.Fatalf("c.LotsOfGreetings stream.Send(%v) failed, err: %v", name, err)
-		}
+	var reqs []*hello.HelloRequest
+	for _, name := range []string{"孙悟空", "齐天大圣", "弼马温"} {
+		reqs = append(reqs, &hello.HelloRequest{Name: name})
 	}
-	res, err := stream.CloseAndRecv()
+	// 客户端流式RPC
+	res, err := c.LotsOfGreetings(ctx, reqs)
 	if err != nil {
-		log.Fatalf("c.LotsOfGreetings failed: %v", err)
+		return err
 	}
 	log.Printf("向服务端发送流 reply: %v", res.GetName())
+	return nil
 }
 
 // 双向流数据
-func runBidiHello(c hello.HelloServiceClient) {
+func runBidiHello(c *sdk.Client) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 	// 双向流模式
 	stream, err := c.BidiHello(ctx)
 	if err != nil {
-		log.Fatalf("c.BidiHello failed, err: %v", err)
+		return err
 	}
-	waitc := make(chan struct{})
 	go func() {
-		for {
-			// 接收服务端返回的响应
-			in, err := stream.Recv()
-			if err == io.EOF {
-				// read done.
-				close(waitc)
-				return
+		names := []string{"孙悟空", "齐天大圣", "弼马温"}
+		for _, name := range names {
+			// 发送流式数据
+			if err := stream.Send(&hello.HelloRequest{Name: name}); err != nil {
+				log.Printf("双向流数据-客户端 stream.Send(%v) failed, err: %v", name, err)
+				break
 			}
-			if err != nil {
-				log.Fatalf("c.BidiHello stream.Recv() failed, err: %v", err)
-			}
-			fmt.Printf("双向流数据-接收服务端返回的响应 ：%s\n", in.GetName())
 		}
+		stream.CloseSend()
 	}()
-
-	names := []string{"孙悟空", "齐天大圣", "弼马温"}
-	for _, name := range names {
-		// 发送流式数据
-		err := stream.Send(&hello.HelloRequest{
-			Name: name,
-		})
-		if err != nil {
-			log.Fatalf("双向流数据-客户端 stream.Send(%v) failed, err: %v", name, err)
-		}
+	// 接收服务端返回的响应
+	for in := range stream.Replies() {
+		fmt.Printf("双向流数据-接收服务端返回的响应 ：%s\n", in.GetName())
 	}
-	stream.CloseSend()
-	<-waitc
+	return stream.Err()
 }
 
 // gateway
-func sayMessage(c hello.GatewayServiceClient) {
-
+func sayMessage(c *sdk.Client) error {
 	message, err := c.SayMessage(context.Background(), &hello.HelloRequest{Name: "test", Message: "收到请求"})
 	if err != nil {
-
-		log.Fatalf("sayMessage error %s", err.Error())
-		return
+		return err
 	}
 	fmt.Printf("get sayMessage rely name%s message %s\n", message.GetName(), message.GetMessage())
+	return nil
 }
 
 // DownloadFile
-func downloadFile(client hello.FileServiceClient) {
-	stream, err := client.DownLoadFile(context.Background(), &hello.HelloRequest{
+func downloadFile(c *sdk.Client, path string) error {
+	reader, err := c.Download(context.Background(), &hello.HelloRequest{
 		Name: "1213",
 	})
 	if err != nil {
-		return
+		return err
 	}
-	file, err := os.OpenFile("./server.cert", os.O_CREATE|os.O_WRONLY, 0600)
+	defer reader.Close()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	var index int
-	for {
-		index++
-		response, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		fmt.Printf("第%d 次， 写入 %d 数据\n", index, len(response.Content))
-		writer.Write(response.Content)
+	n, err := io.Copy(writer, reader)
+	if err != nil {
+		return err
 	}
-	writer.Flush()
-
+	fmt.Printf("写入 %d 数据\n", n)
+	return writer.Flush()
 }
 
-func uploadFile(client hello.FileServiceClient) {
-	stream, err := client.UploadFile(context.Background())
+func uploadFile(c *sdk.Client) error {
+	uploader, err := c.Upload(context.Background(), "upload.txt")
+	if err != nil {
+		return err
+	}
 	for i := 0; i < 10; i++ {
-		stream.Send(&hello.FileRequest{FileName: fmt.Sprintf("第%d次", i)})
+		if _, err := fmt.Fprintf(uploader, "第%d次\n", i); err != nil {
+			return err
+		}
+	}
+	if err := uploader.Close(); err != nil {
+		return err
 	}
-	response, err := stream.CloseAndRecv()
-	fmt.Println(response, err)
+	fmt.Println(uploader.Response())
+	return nil
 }
@@ -0,0 +1,229 @@
+// Package sdk 封装 HelloService、GatewayService 和 FileService 的客户端，
+// 其他服务可以直接引入使用，不需要复制 client/main.go 中的示例代码
+package sdk
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	"github.com/keepon-online/go-grpc-example/client/conn"
+	"github.com/keepon-online/go-grpc-example/client/handler"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"time"
+)
+
+// tokenRefreshBefore token距离过期不足该时间时提前刷新
+const tokenRefreshBefore = time.Minute
+
+// Client 服务客户端，可以被多个协程同时使用
+type Client struct {
+	cc      *grpc.ClientConn
+	hello   hello.HelloServiceClient
+	gateway hello.GatewayServiceClient
+	file    hello.FileServiceClient
+}
+
+type options struct {
+	cfg         config.Client
+	creds       credentials.TransportCredentials
+	insecure    bool
+	uid         string
+	tokenSource auth.TokenSource
+	perRPC      credentials.PerRPCCredentials
+	unary       []grpc.UnaryClientInterceptor
+	stream      []grpc.StreamClientInterceptor
+	dialOpts    []grpc.DialOption
+}
+
+// Option 客户端选项
+type Option func(*options) error
+
+// WithConfig 使用完整的客户端配置，包括地址、负载均衡、超时和重试策略
+func WithConfig(cfg config.Client) Option {
+	return func(o *options) error {
+		o.cfg = cfg
+		return nil
+	}
+}
+
+// WithAddress 服务端地址，支持 conn.Dial 的所有 target 格式
+func WithAddress(target string) Option {
+	return func(o *options) error {
+		o.cfg.Target = target
+		return nil
+	}
+}
+
+// WithTLS 使用证书文件校验服务端，serverName 为空时使用地址中的域名
+func WithTLS(certFile, serverName string) Option {
+	return func(o *options) error {
+		creds, err := credentials.NewClientTLSFromFile(certFile, serverName)
+		if err != nil {
+			return err
+		}
+		o.creds = creds
+		return nil
+	}
+}
+
+// WithTransportCredentials 自定义传输层凭证
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) error {
+		o.creds = creds
+		return nil
+	}
+}
+
+// WithInsecure 不使用 TLS，只用于本地测试
+func WithInsecure() Option {
+	return func(o *options) error {
+		o.creds = insecure.NewCredentials()
+		o.insecure = true
+		return nil
+	}
+}
+
+// WithToken 每次调用携带固定的token
+func WithToken(uid, token string) Option {
+	return WithTokenSource(uid, auth.StaticTokenSource(token))
+}
+
+// WithTokenSource 每次调用从 src 获取token，token会被缓存到快要过期时再刷新，
+// 服务端返回 Unauthenticated 时丢弃缓存并重试一次
+func WithTokenSource(uid string, src auth.TokenSource) Option {
+	return func(o *options) error {
+		if _, ok := src.(*auth.CachedTokenSource); !ok {
+			src = auth.NewCachedTokenSource(src, tokenRefreshBefore)
+		}
+		o.uid, o.tokenSource = uid, src
+		return nil
+	}
+}
+
+// WithPerRPCCredentials 自定义每次调用的认证信息
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(o *options) error {
+		o.perRPC = creds
+		return nil
+	}
+}
+
+// WithUnaryInterceptors 追加一元拦截器，在内置的超时、重试拦截器之后执行
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(o *options) error {
+		o.unary = append(o.unary, interceptors...)
+		return nil
+	}
+}
+
+// WithStreamInterceptors 追加流式拦截器
+func WithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) Option {
+	return func(o *options) error {
+		o.stream = append(o.stream, interceptors...)
+		return nil
+	}
+}
+
+// WithTimeout 调用方没有设置截止时间时使用的默认超时
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		o.cfg.Timeout.Default = d
+		return nil
+	}
+}
+
+// WithMethodTimeout 单个方法的默认超时，method 为完整方法名
+func WithMethodTimeout(method string, d time.Duration) Option {
+	return func(o *options) error {
+		methods := make(map[string]time.Duration, len(o.cfg.Timeout.Methods)+1)
+		for k, v := range o.cfg.Timeout.Methods {
+			methods[k] = v
+		}
+		methods[method] = d
+		o.cfg.Timeout.Methods = methods
+		return nil
+	}
+}
+
+// WithDialOptions 追加其他 grpc.DialOption
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) error {
+		o.dialOpts = append(o.dialOpts, opts...)
+		return nil
+	}
+}
+
+// New 创建客户端，默认使用 config.Default() 中的客户端配置和系统根证书的 TLS
+func New(opts ...Option) (*Client, error) {
+	o := &options{cfg: config.Default().Client}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.creds == nil {
+		o.creds = credentials.NewTLS(&tls.Config{ServerName: o.cfg.ServerName})
+	}
+	dopts := []grpc.DialOption{
+		grpc.WithTransportCredentials(o.creds),
+		grpc.WithChainUnaryInterceptor(o.unary...),
+		grpc.WithChainStreamInterceptor(o.stream...),
+	}
+	if o.tokenSource != nil {
+		dopts = append(dopts,
+			grpc.WithPerRPCCredentials(&handler.Token{Uid: o.uid, Source: o.tokenSource, Insecure: o.insecure}),
+			grpc.WithChainUnaryInterceptor(handler.UnaryClientInterceptorReauth(o.tokenSource)),
+			grpc.WithChainStreamInterceptor(handler.StreamClientInterceptorReauth(o.tokenSource)),
+		)
+	}
+	if o.perRPC != nil {
+		dopts = append(dopts, grpc.WithPerRPCCredentials(o.perRPC))
+	}
+	cc, err := conn.Dial(o.cfg, append(dopts, o.dialOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConn(cc), nil
+}
+
+// NewFromConn 使用已有的连接创建客户端，Close 会关闭该连接
+func NewFromConn(cc *grpc.ClientConn) *Client {
+	return &Client{
+		cc:      cc,
+		hello:   hello.NewHelloServiceClient(cc),
+		gateway: hello.NewGatewayServiceClient(cc),
+		file:    hello.NewFileServiceClient(cc),
+	}
+}
+
+// Conn 返回底层连接
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.cc
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
+
+// SayHello 调用 HelloService.SayHello
+func (c *Client) SayHello(ctx context.Context, req *hello.HelloRequest, opts ...grpc.CallOption) (*hello.HelloResponse, error) {
+	resp, err := c.hello.SayHello(ctx, req, opts...)
+	if err != nil {
+		return nil, wrapError("SayHello", err)
+	}
+	return resp, nil
+}
+
+// SayMessage 调用 GatewayService.SayMessage
+func (c *Client) SayMessage(ctx context.Context, req *hello.HelloRequest, opts ...grpc.CallOption) (*hello.HelloResponse, error) {
+	resp, err := c.gateway.SayMessage(ctx, req, opts...)
+	if err != nil {
+		return nil, wrapError("SayMessage", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,152 @@
+package sdk_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/keepon-online/go-grpc-example/client/sdk"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"github.com/keepon-online/go-grpc-example/util"
+	"google.golang.org/grpc"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newClient 通过 sdk.New 连接测试服务端，携带有效的token
+func newClient(t *testing.T, s *servertest.Server, opts ...sdk.Option) *sdk.Client {
+	t.Helper()
+	opts = append([]sdk.Option{
+		sdk.WithAddress("passthrough:///bufnet"),
+		sdk.WithInsecure(),
+		sdk.WithToken(servertest.UID, servertest.NewToken(t, util.BaseClaims{ID: 1, Username: "sdk"})),
+		sdk.WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.Listener.DialContext(ctx)
+		})),
+	}, opts...)
+	c, err := sdk.New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth())
+	c := newClient(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("SayHello", func(t *testing.T) {
+		resp, err := c.SayHello(ctx, &hello.HelloRequest{Name: "a", Message: "m"})
+		if err != nil || resp.GetName() != "a" || resp.GetMessage() != "m" {
+			t.Errorf("SayHello = %v, %v", resp, err)
+		}
+	})
+
+	t.Run("SayMessage", func(t *testing.T) {
+		if _, err := c.SayMessage(ctx, &hello.HelloRequest{Name: "a"}); err != nil {
+			t.Errorf("SayMessage: %v", err)
+		}
+	})
+
+	t.Run("LotsOfReplies", func(t *testing.T) {
+		it, err := c.LotsOfReplies(ctx, &hello.HelloRequest{Name: "a"})
+		if err != nil {
+			t.Fatalf("LotsOfReplies: %v", err)
+		}
+		n := 0
+		for it.Next() {
+			if !strings.HasPrefix(it.Reply().GetName(), "a") {
+				t.Errorf("reply = %v", it.Reply())
+			}
+			n++
+		}
+		if err := it.Err(); err != nil || n != 4 {
+			t.Errorf("got %d replies, err %v", n, err)
+		}
+		if it.Next() {
+			t.Error("Next after end")
+		}
+	})
+
+	t.Run("LotsOfGreetings", func(t *testing.T) {
+		resp, err := c.LotsOfGreetings(ctx, []*hello.HelloRequest{{Name: "a"}, {Name: "b"}})
+		if err != nil || !strings.HasSuffix(resp.GetName(), "ab") {
+			t.Errorf("LotsOfGreetings = %v, %v", resp, err)
+		}
+	})
+
+	t.Run("BidiHello", func(t *testing.T) {
+		stream, err := c.BidiHello(ctx)
+		if err != nil {
+			t.Fatalf("BidiHello: %v", err)
+		}
+		defer stream.Close()
+		for _, name := range []string{"a", "b"} {
+			if err := stream.Send(&hello.HelloRequest{Name: name}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if reply := <-stream.Replies(); reply.GetName() != name {
+				t.Errorf("reply = %v, want %s", reply, name)
+			}
+		}
+		stream.CloseSend()
+		for range stream.Replies() {
+		}
+		if err := stream.Err(); err != nil {
+			t.Errorf("Err = %v", err)
+		}
+	})
+}
+
+func TestUploadDownload(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth(), servertest.WithFileServer(service.FileServer{Root: t.TempDir()}))
+	c := newClient(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 超过一条消息的大小，分成多块发送
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	up, err := c.Upload(ctx, "data.txt")
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if n, err := up.Write(content); err != nil || n != len(content) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if err := up.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if up.Response() == nil {
+		t.Error("no upload response")
+	}
+	if _, err := up.Write([]byte("x")); err == nil {
+		t.Error("write after close succeeded")
+	}
+
+	r, err := c.Download(ctx, &hello.HelloRequest{Name: "data.txt"})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, err %v, want %d bytes", len(got), err, len(content))
+	}
+
+	// 服务端的错误在读取时返回
+	r, err = c.Download(ctx, &hello.HelloRequest{Name: "missing.txt"})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); sdk.Reason(err) != errs.ReasonFileNotFound {
+		t.Errorf("read missing file = %v", err)
+	}
+}
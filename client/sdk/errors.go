@@ -0,0 +1,112 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Error 调用失败时返回的错误，保留了 gRPC 状态码和错误详情
+type Error struct {
+	// Op 调用的方法，例如 SayHello
+	Op      string
+	Code    codes.Code
+	Message string
+	// Reason 服务端返回的错误原因，见 errs 包中的 Reason 常量，服务端没有返回原因时为空
+	Reason string
+	// Metadata 错误原因附带的信息
+	Metadata map[string]string
+	// Details 状态中携带的 errdetails 等详情
+	Details []interface{}
+	status  *status.Status
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sdk: %s: %s: %s", e.Op, e.Code, e.Message)
+}
+
+// GRPCStatus 实现后 status.FromError、status.Code 可以直接处理 *Error
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// RetryAfter 服务端建议的重试间隔，例如触发限流时
+func (e *Error) RetryAfter() (time.Duration, bool) {
+	for _, d := range e.Details {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// wrapError 把 gRPC 错误转换为 *Error
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.FromContextError(err)
+	}
+	e := errs.FromStatus(st)
+	return &Error{
+		Op:       op,
+		Code:     st.Code(),
+		Message:  st.Message(),
+		Reason:   e.Reason,
+		Metadata: e.Metadata,
+		Details:  st.Details(),
+		status:   st,
+	}
+}
+
+// Code 返回错误的 gRPC 状态码，nil 返回 codes.OK
+func Code(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return status.Code(err)
+}
+
+// Reason 返回服务端的错误原因
+func Reason(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return errs.Reason(err)
+}
+
+// IsTokenExpired token 已过期，重新获取token后可以重试
+func IsTokenExpired(err error) bool {
+	return Reason(err) == errs.ReasonTokenExpired
+}
+
+// IsTokenMalformed token 格式错误，通常是配置的token不对，重试没有意义
+func IsTokenMalformed(err error) bool {
+	return Reason(err) == errs.ReasonTokenMalformed
+}
+
+// IsUnauthenticated token 缺失或失效
+func IsUnauthenticated(err error) bool {
+	return Code(err) == codes.Unauthenticated
+}
+
+// IsResourceExhausted 触发服务端限流
+func IsResourceExhausted(err error) bool {
+	return Code(err) == codes.ResourceExhausted
+}
+
+// IsDeadlineExceeded 调用超时
+func IsDeadlineExceeded(err error) bool {
+	return Code(err) == codes.DeadlineExceeded
+}
@@ -0,0 +1,66 @@
+package sdk_test
+
+import (
+	"context"
+	"errors"
+	"github.com/keepon-online/go-grpc-example/client/sdk"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"testing"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string
+		is         func(error) bool
+		retryAfter time.Duration
+	}{
+		{name: "token expired", err: errs.ErrTokenExpired, wantCode: codes.Unauthenticated,
+			wantReason: errs.ReasonTokenExpired, is: sdk.IsTokenExpired},
+		{name: "token malformed", err: errs.ErrTokenMalformed, wantCode: codes.Unauthenticated,
+			wantReason: errs.ReasonTokenMalformed, is: sdk.IsTokenMalformed},
+		{name: "rate limited", err: errs.ErrRateLimited.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(3 * time.Second)}),
+			wantCode: codes.ResourceExhausted, wantReason: errs.ReasonRateLimited, is: sdk.IsResourceExhausted, retryAfter: 3 * time.Second},
+		{name: "plain status", err: status.Error(codes.DeadlineExceeded, "slow"), wantCode: codes.DeadlineExceeded, is: sdk.IsDeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fail := func(context.Context, interface{}, *grpc.UnaryServerInfo, grpc.UnaryHandler) (interface{}, error) {
+				return nil, tt.err
+			}
+			s := servertest.Start(t, servertest.WithUnaryInterceptors(fail))
+			_, err := s.SDK.SayHello(context.Background(), &hello.HelloRequest{Name: "a"})
+
+			var e *sdk.Error
+			if !errors.As(err, &e) || e.Op != "SayHello" {
+				t.Fatalf("err = %#v, want *sdk.Error", err)
+			}
+			if sdk.Code(err) != tt.wantCode || status.Code(err) != tt.wantCode {
+				t.Errorf("code = %v, status.Code = %v, want %v", sdk.Code(err), status.Code(err), tt.wantCode)
+			}
+			if sdk.Reason(err) != tt.wantReason {
+				t.Errorf("reason = %q, want %q", sdk.Reason(err), tt.wantReason)
+			}
+			if !tt.is(err) {
+				t.Errorf("predicate false for %v", err)
+			}
+			if d, ok := e.RetryAfter(); d != tt.retryAfter || ok != (tt.retryAfter > 0) {
+				t.Errorf("RetryAfter = %v, %v, want %v", d, ok, tt.retryAfter)
+			}
+		})
+	}
+
+	if sdk.Code(nil) != codes.OK {
+		t.Error("Code(nil) != OK")
+	}
+}
@@ -0,0 +1,127 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc"
+	"io"
+)
+
+// uploadChunkSize 上传时每条消息携带的最大字节数
+const uploadChunkSize = 32 * 1024
+
+// fileReader 把 DownLoadFile 的流转换为 io.Reader
+type fileReader struct {
+	stream hello.FileService_DownLoadFileClient
+	cancel context.CancelFunc
+	buf    []byte
+	err    error
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		resp, err := r.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				err = wrapError("DownLoadFile", err)
+			}
+			r.err = err
+			continue
+		}
+		r.buf = resp.GetContent()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *fileReader) Close() error {
+	r.cancel()
+	if r.err == nil {
+		r.err = errors.New("sdk: read from closed download")
+	}
+	return nil
+}
+
+// Download 调用 FileService.DownLoadFile，返回的 Reader 读完或不再需要时必须 Close
+func (c *Client) Download(ctx context.Context, req *hello.HelloRequest, opts ...grpc.CallOption) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.file.DownLoadFile(ctx, req, opts...)
+	if err != nil {
+		cancel()
+		return nil, wrapError("DownLoadFile", err)
+	}
+	return &fileReader{stream: stream, cancel: cancel}, nil
+}
+
+// Uploader 把写入的数据按块发送给 FileService.UploadFile，Close 后通过 Response 获取服务端结果
+type Uploader struct {
+	fileName string
+	stream   hello.FileService_UploadFileClient
+	resp     *hello.HelloResponse
+	err      error
+	closed   bool
+}
+
+// Upload 调用 FileService.UploadFile，必须调用 Close 才会完成上传
+func (c *Client) Upload(ctx context.Context, fileName string, opts ...grpc.CallOption) (*Uploader, error) {
+	stream, err := c.file.UploadFile(ctx, opts...)
+	if err != nil {
+		return nil, wrapError("UploadFile", err)
+	}
+	return &Uploader{fileName: fileName, stream: stream}, nil
+}
+
+func (u *Uploader) Write(p []byte) (int, error) {
+	if u.closed {
+		return 0, errors.New("sdk: write to closed upload")
+	}
+	if u.err != nil {
+		return 0, u.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > uploadChunkSize {
+			n = uploadChunkSize
+		}
+		if err := u.stream.Send(&hello.FileRequest{FileName: u.fileName, Content: p[:n]}); err != nil {
+			if err == io.EOF {
+				// 服务端提前结束，通过 CloseAndRecv 获取真正的错误
+				_, err = u.stream.CloseAndRecv()
+			}
+			u.err = wrapError("UploadFile", err)
+			return written, u.err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close 结束上传并等待服务端的响应
+func (u *Uploader) Close() error {
+	if u.closed {
+		return u.err
+	}
+	u.closed = true
+	if u.err != nil {
+		return u.err
+	}
+	resp, err := u.stream.CloseAndRecv()
+	if err != nil {
+		u.err = wrapError("UploadFile", err)
+		return u.err
+	}
+	u.resp = resp
+	return nil
+}
+
+// Response Close 成功后服务端返回的结果
+func (u *Uploader) Response() *hello.HelloResponse {
+	return u.resp
+}
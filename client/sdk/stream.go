@@ -0,0 +1,171 @@
+package sdk
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc"
+	"io"
+	"sync"
+)
+
+// ReplyIterator 逐条读取服务端流：
+//
+//	it, err := c.LotsOfReplies(ctx, req)
+//	for it.Next() {
+//		fmt.Println(it.Reply().GetName())
+//	}
+//	if err := it.Err(); err != nil { ... }
+type ReplyIterator struct {
+	op     string
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	reply  *hello.HelloResponse
+	err    error
+	done   bool
+}
+
+// Next 读取下一条响应，流结束或出错时返回 false
+func (it *ReplyIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	reply := new(hello.HelloResponse)
+	if err := it.stream.RecvMsg(reply); err != nil {
+		if err != io.EOF {
+			it.err = wrapError(it.op, err)
+		}
+		it.Close()
+		return false
+	}
+	it.reply = reply
+	return true
+}
+
+// Reply 当前的响应
+func (it *ReplyIterator) Reply() *hello.HelloResponse {
+	return it.reply
+}
+
+// Err 流正常结束时返回 nil
+func (it *ReplyIterator) Err() error {
+	return it.err
+}
+
+// Close 提前结束读取，取消服务端的发送
+func (it *ReplyIterator) Close() {
+	it.done = true
+	it.cancel()
+}
+
+// LotsOfReplies 调用 HelloService.LotsOfReplies，返回的迭代器需要读到结束或调用 Close
+func (c *Client) LotsOfReplies(ctx context.Context, req *hello.HelloRequest, opts ...grpc.CallOption) (*ReplyIterator, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.hello.LotsOfReplies(ctx, req, opts...)
+	if err != nil {
+		cancel()
+		return nil, wrapError("LotsOfReplies", err)
+	}
+	return &ReplyIterator{op: "LotsOfReplies", stream: stream, cancel: cancel}, nil
+}
+
+// LotsOfGreetings 调用 HelloService.LotsOfGreetings，依次发送所有请求后返回服务端的汇总结果
+func (c *Client) LotsOfGreetings(ctx context.Context, reqs []*hello.HelloRequest, opts ...grpc.CallOption) (*hello.HelloResponse, error) {
+	stream, err := c.hello.LotsOfGreetings(ctx, opts...)
+	if err != nil {
+		return nil, wrapError("LotsOfGreetings", err)
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			// 发送失败时真正的错误需要通过 CloseAndRecv 获取
+			if err == io.EOF {
+				break
+			}
+			return nil, wrapError("LotsOfGreetings", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, wrapError("LotsOfGreetings", err)
+	}
+	return resp, nil
+}
+
+// BidiStream 双向流，Send 和 Replies 可以在不同协程中使用
+type BidiStream struct {
+	stream  hello.HelloService_BidiHelloClient
+	cancel  context.CancelFunc
+	replies chan *hello.HelloResponse
+	mu      sync.Mutex
+	err     error
+}
+
+// BidiHello 调用 HelloService.BidiHello，响应通过 Replies 返回的通道读取
+func (c *Client) BidiHello(ctx context.Context, opts ...grpc.CallOption) (*BidiStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.hello.BidiHello(ctx, opts...)
+	if err != nil {
+		cancel()
+		return nil, wrapError("BidiHello", err)
+	}
+	b := &BidiStream{
+		stream:  stream,
+		cancel:  cancel,
+		replies: make(chan *hello.HelloResponse),
+	}
+	go b.recvLoop(ctx)
+	return b, nil
+}
+
+func (b *BidiStream) recvLoop(ctx context.Context) {
+	defer close(b.replies)
+	for {
+		reply, err := b.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				b.mu.Lock()
+				b.err = wrapError("BidiHello", err)
+				b.mu.Unlock()
+			}
+			return
+		}
+		select {
+		case b.replies <- reply:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Send 发送一条消息
+func (b *BidiStream) Send(req *hello.HelloRequest) error {
+	if err := b.stream.Send(req); err != nil {
+		if err == io.EOF {
+			// 服务端已经结束流，错误在 Err 中
+			return io.EOF
+		}
+		return wrapError("BidiHello", err)
+	}
+	return nil
+}
+
+// CloseSend 通知服务端不再发送，之后继续读取 Replies 直到通道关闭
+func (b *BidiStream) CloseSend() error {
+	return b.stream.CloseSend()
+}
+
+// Replies 服务端的响应，流结束时通道关闭，之后通过 Err 获取错误
+func (b *BidiStream) Replies() <-chan *hello.HelloResponse {
+	return b.replies
+}
+
+// Err Replies 通道关闭后返回流的错误，正常结束时为 nil
+func (b *BidiStream) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Close 立即结束流
+func (b *BidiStream) Close() {
+	b.cancel()
+}
@@ -0,0 +1,626 @@
+package config
+
+import (
+	"errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// Config 服务配置，对应 conf/config.yaml
+type Config struct {
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Deadline  Deadline  `yaml:"deadline"`
+	Server    Server    `yaml:"server"`
+	Client    Client    `yaml:"client"`
+	Gateway   Gateway   `yaml:"gateway"`
+	Auth      Auth      `yaml:"auth"`
+	Chat      Chat      `yaml:"chat"`
+	Events    Events    `yaml:"events"`
+	Batch     Batch     `yaml:"batch"`
+	Admin     Admin     `yaml:"admin"`
+	Tenants   Tenants   `yaml:"tenants"`
+	Files     Files     `yaml:"files"`
+}
+
+// RateLimit 限流配置
+type RateLimit struct {
+	Enabled bool            `yaml:"enabled"`
+	Rules   []RateLimitRule `yaml:"rules"`
+	// TrustedProxies 网关等可信代理的 IP 或 CIDR，来自这些地址的调用按元数据 x-client-ip 中的客户端IP限流，
+	// 默认为空，不信任任何地址
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// RateLimitRule 单条限流规则，一个请求可以同时命中多条规则
+type RateLimitRule struct {
+	// Method 完整方法名，例如 /hello.v1.HelloService/SayHello，为空或 * 表示所有方法
+	Method string `yaml:"method"`
+	// KeyBy 限流维度：method 按方法、principal 按认证用户、ip 按客户端IP、tenant 按租户
+	KeyBy string `yaml:"keyBy"`
+	// Rate 令牌桶每秒生成的令牌数，0 表示不限速
+	Rate float64 `yaml:"rate"`
+	// Burst 令牌桶容量
+	Burst int `yaml:"burst"`
+	// MaxConcurrent 最大并发请求（流）数，0 表示不限制
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// Tenant 只对该租户生效，为空表示所有租户
+	Tenant string `yaml:"tenant"`
+}
+
+// Deadline 服务端截止时间配置
+type Deadline struct {
+	// Default 客户端没有设置截止时间时使用的超时，0 表示不设置
+	Default time.Duration `yaml:"default"`
+	// Max 允许的最长超时，客户端设置的截止时间超过该值时会被缩短，0 表示不限制
+	Max time.Duration `yaml:"max"`
+	// Methods 按完整方法名覆盖，未设置的字段沿用全局值
+	Methods map[string]MethodDeadline `yaml:"methods"`
+}
+
+// MethodDeadline 单个方法的截止时间配置
+type MethodDeadline struct {
+	Default time.Duration `yaml:"default"`
+	Max     time.Duration `yaml:"max"`
+}
+
+// ForMethod 返回方法实际生效的默认超时和最长超时
+func (d Deadline) ForMethod(method string) (def, max time.Duration) {
+	def, max = d.Default, d.Max
+	if m, ok := d.Methods[method]; ok {
+		if m.Default > 0 {
+			def = m.Default
+		}
+		if m.Max > 0 {
+			max = m.Max
+		}
+	}
+	return def, max
+}
+
+// Server gRPC 服务端的连接配置
+type Server struct {
+	Keepalive      ServerKeepalive `yaml:"keepalive"`
+	MaxMessageSize MessageSize     `yaml:"maxMessageSize"`
+}
+
+// ServerKeepalive 服务端保活和连接管理，为 0 的字段使用默认值，
+// MaxConnectionIdle、MaxConnectionAge 小于 0 表示不限制
+type ServerKeepalive struct {
+	// Time 连接上没有数据超过该时间后服务端发送 ping，避免代理和负载均衡断开空闲的长连接
+	Time time.Duration `yaml:"time"`
+	// Timeout 发送 ping 后等待响应的时间，超时后关闭连接
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxConnectionIdle 连接上没有调用超过该时间后发送 GOAWAY 关闭连接
+	MaxConnectionIdle time.Duration `yaml:"maxConnectionIdle"`
+	// MaxConnectionAge 连接的最长存活时间，到期后发送 GOAWAY，客户端重新解析地址、建立新连接，负载重新均衡
+	MaxConnectionAge time.Duration `yaml:"maxConnectionAge"`
+	// MaxConnectionAgeGrace 发送 GOAWAY 后等待进行中的调用结束的时间，超时后强制关闭连接
+	MaxConnectionAgeGrace time.Duration `yaml:"maxConnectionAgeGrace"`
+	// MinTime 允许客户端发送 ping 的最短间隔，客户端 ping 过于频繁时服务端关闭连接
+	MinTime time.Duration `yaml:"minTime"`
+	// PermitWithoutStream 允许客户端在没有调用时发送 ping，不允许时这样的 ping 也按过于频繁处理
+	PermitWithoutStream bool `yaml:"permitWithoutStream"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (k ServerKeepalive) WithDefaults() ServerKeepalive {
+	if k.Time == 0 {
+		k.Time = time.Minute
+	}
+	if k.Timeout == 0 {
+		k.Timeout = 20 * time.Second
+	}
+	if k.MaxConnectionIdle == 0 {
+		k.MaxConnectionIdle = 15 * time.Minute
+	}
+	if k.MaxConnectionAge == 0 {
+		k.MaxConnectionAge = 30 * time.Minute
+	}
+	if k.MaxConnectionAgeGrace == 0 {
+		k.MaxConnectionAgeGrace = 10 * time.Minute
+	}
+	if k.MinTime == 0 {
+		k.MinTime = 10 * time.Second
+	}
+	return k
+}
+
+// MessageSize 单条消息的最大字节数，为 0 的字段使用默认值 16MB
+type MessageSize struct {
+	// Recv 接收的消息，超过时调用返回 ResourceExhausted
+	Recv int `yaml:"recv"`
+	// Send 发送的消息
+	Send int `yaml:"send"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (m MessageSize) WithDefaults() MessageSize {
+	if m.Recv <= 0 {
+		m.Recv = 16 << 20
+	}
+	if m.Send <= 0 {
+		m.Send = 16 << 20
+	}
+	return m
+}
+
+// Client 客户端配置
+type Client struct {
+	// Target 服务端地址，支持 host:port、dns:///host:port、static:///a:port,b:port=2、file:///path
+	Target string `yaml:"target"`
+	// ServerName TLS 校验的证书域名，多个后端时需要设置
+	ServerName     string          `yaml:"serverName"`
+	Balancer       Balancer        `yaml:"balancer"`
+	Timeout        Timeout         `yaml:"timeout"`
+	Retry          RetryPolicy     `yaml:"retry"`
+	Hedging        HedgingPolicy   `yaml:"hedging"`
+	Auth           ClientAuth      `yaml:"auth"`
+	Keepalive      ClientKeepalive `yaml:"keepalive"`
+	MaxMessageSize MessageSize     `yaml:"maxMessageSize"`
+}
+
+// ClientKeepalive 客户端保活，为 0 的字段使用默认值
+type ClientKeepalive struct {
+	// Time 连接上没有数据超过该时间后发送 ping，不能小于服务端的 minTime，gRPC 要求至少 10s
+	Time time.Duration `yaml:"time"`
+	// Timeout 发送 ping 后等待响应的时间，超时后认为连接已断开，重新建立连接
+	Timeout time.Duration `yaml:"timeout"`
+	// PermitWithoutStream 没有调用时也发送 ping，需要服务端同时开启 permitWithoutStream
+	PermitWithoutStream bool `yaml:"permitWithoutStream"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (k ClientKeepalive) WithDefaults() ClientKeepalive {
+	if k.Time <= 0 {
+		k.Time = 30 * time.Second
+	}
+	if k.Timeout <= 0 {
+		k.Timeout = 10 * time.Second
+	}
+	return k
+}
+
+// ClientAuth 客户端获取token的方式，TokenURL 和 TokenFile 都为空时不携带token
+type ClientAuth struct {
+	// TokenURL 令牌接口地址，例如 http://host:8081/v1/auth/token
+	TokenURL     string `yaml:"tokenURL"`
+	ClientID     string `yaml:"clientID"`
+	ClientSecret string `yaml:"clientSecret" secret:"true"`
+	// TokenFile 从文件读取token，TokenURL 不为空时忽略
+	TokenFile string `yaml:"tokenFile"`
+	// RefreshBefore token距离过期不足该时间时提前刷新
+	RefreshBefore time.Duration `yaml:"refreshBefore"`
+}
+
+// Balancer 负载均衡配置
+type Balancer struct {
+	// Policy 负载均衡策略：pick_first、round_robin、weighted
+	Policy string `yaml:"policy"`
+	// HealthCheck 使用标准健康检查协议（grpc.health.v1）剔除不健康的后端
+	HealthCheck bool `yaml:"healthCheck"`
+	// FileResolverInterval file:/// 解析器检查文件变化的间隔
+	FileResolverInterval time.Duration `yaml:"fileResolverInterval"`
+}
+
+// Gateway 网关配置
+type Gateway struct {
+	Addr string `yaml:"addr"`
+	// Backend 网关连接 gRPC 服务端使用的客户端配置
+	Backend Client `yaml:"backend"`
+	// SwaggerUI 在 /swagger/ 提供 Swagger UI 页面，/openapi.json 始终可用
+	SwaggerUI bool `yaml:"swaggerUI"`
+	// SwaggerUIDir Swagger UI 的静态资源目录，放 swagger-ui-dist 发布包中的文件，开启 SwaggerUI 时必须设置，
+	// 页面不从 CDN 加载脚本
+	SwaggerUIDir string `yaml:"swaggerUIDir"`
+	// WebSocket BidiHello 的 WebSocket 接口
+	WebSocket WebSocket `yaml:"webSocket"`
+	// GRPCWeb 网关端口上的 gRPC-Web，默认关闭
+	GRPCWeb GRPCWeb `yaml:"grpcWeb"`
+	// Middleware 网关的 HTTP 中间件，gRPC-Web 不使用其中的 CORS 和 Timeout
+	Middleware Middleware `yaml:"middleware"`
+	// Headers HTTP 头与 gRPC 元数据的转换规则
+	Headers Headers `yaml:"headers"`
+	// JSON 网关 JSON 的编码选项，YAML 由 JSON 转换，使用相同的选项
+	JSON JSON `yaml:"json"`
+}
+
+// JSON 对应 protojson 的编码选项
+type JSON struct {
+	// UseProtoNames 字段名使用 proto 中的名称（snake_case），默认使用 lowerCamelCase
+	UseProtoNames bool `yaml:"useProtoNames"`
+	// EmitUnpopulated 输出零值字段
+	EmitUnpopulated bool `yaml:"emitUnpopulated"`
+	// UseEnumNumbers 枚举输出为数字，默认输出名称
+	UseEnumNumbers bool `yaml:"useEnumNumbers"`
+	// Indent 缩进，为空时输出紧凑的 JSON
+	Indent string `yaml:"indent"`
+	// RejectUnknownFields 请求中有未知字段时返回错误，默认忽略
+	RejectUnknownFields bool `yaml:"rejectUnknownFields"`
+}
+
+// Headers 网关转发请求头和返回响应元数据的规则，Grpc-Metadata-* 默认不转发
+type Headers struct {
+	Incoming IncomingHeaders `yaml:"incoming"`
+	Outgoing OutgoingHeaders `yaml:"outgoing"`
+}
+
+// IncomingHeaders 请求头转发为元数据的规则，Authorization（转为 token）、X-Request-Id、Last-Event-ID 和 Idempotency-Key 始终转发
+type IncomingHeaders struct {
+	// Allow 允许转发的请求头，key 为请求头名称，value 为元数据名称，为空时使用请求头的小写
+	Allow map[string]string `yaml:"allow"`
+	// GrpcMetadata 按 grpc-gateway 的默认规则转发 Grpc-Metadata-* 和标准请求头
+	GrpcMetadata bool `yaml:"grpcMetadata"`
+}
+
+// OutgoingHeaders 服务端返回的元数据转换为响应头的规则
+type OutgoingHeaders struct {
+	// Headers 服务端 header 元数据，key 为元数据名称，value 为响应头名称
+	Headers map[string]string `yaml:"headers"`
+	// Trailers 服务端 trailer 元数据，作为普通响应头返回，只支持非流式接口
+	Trailers map[string]string `yaml:"trailers"`
+	// GrpcMetadata 其他元数据以 Grpc-Metadata-*、Grpc-Trailer-* 返回
+	GrpcMetadata bool `yaml:"grpcMetadata"`
+}
+
+// Middleware 网关的 HTTP 中间件配置，请求ID和 panic 恢复始终开启，其他为 0 时不启用
+type Middleware struct {
+	// AccessLog 每个请求结束后打印一行访问日志
+	AccessLog bool            `yaml:"accessLog"`
+	CORS      CORS            `yaml:"cors"`
+	Security  SecurityHeaders `yaml:"security"`
+	// MaxBodySize 请求体的最大字节数
+	MaxBodySize int64 `yaml:"maxBodySize"`
+	// Timeout 非流式请求的超时，作为截止时间传给 gRPC 服务端
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// CORS 跨域配置，AllowedOrigins 为空时不处理跨域请求
+type CORS struct {
+	// AllowedOrigins 允许跨域的 Origin，* 允许所有来源
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	// ExposedHeaders 浏览器脚本可以读取的响应头
+	ExposedHeaders   []string `yaml:"exposedHeaders"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+	// MaxAge 预检结果的缓存时间
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// SecurityHeaders 响应的安全头
+type SecurityHeaders struct {
+	// HSTSMaxAge Strict-Transport-Security 的 max-age，只在 HTTPS 请求上设置
+	HSTSMaxAge            time.Duration `yaml:"hstsMaxAge"`
+	HSTSIncludeSubdomains bool          `yaml:"hstsIncludeSubdomains"`
+	// NoSniff 设置 X-Content-Type-Options: nosniff
+	NoSniff bool `yaml:"noSniff"`
+	// FrameOptions X-Frame-Options，例如 DENY
+	FrameOptions string `yaml:"frameOptions"`
+}
+
+// GRPCWeb 浏览器通过 gRPC-Web 直接调用 gRPC 服务，支持一元和服务端流
+type GRPCWeb struct {
+	Enabled bool `yaml:"enabled"`
+	// Origins 允许跨域调用的 Origin，* 允许所有来源，为空时只允许同源
+	Origins []string `yaml:"origins"`
+	// AllowedHeaders 跨域请求允许携带的请求头（元数据），为空时允许所有
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+}
+
+// WebSocket 网关的 WebSocket 接口配置，为 0 的字段使用默认值
+type WebSocket struct {
+	// Origins 允许的 Origin，为空时只允许同源，* 允许所有来源
+	Origins []string `yaml:"origins"`
+	// PingInterval 服务端发送 ping 的间隔
+	PingInterval time.Duration `yaml:"pingInterval"`
+	// PongTimeout 超过该时间没有收到客户端的任何帧（包括 pong）时断开
+	PongTimeout time.Duration `yaml:"pongTimeout"`
+	// WriteTimeout 单个帧的写超时，客户端长时间不读取时断开
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// MaxMessageSize 客户端单个消息的最大字节数
+	MaxMessageSize int64 `yaml:"maxMessageSize"`
+	// SendBuffer 等待发送给客户端的消息数，满了之后暂停从服务端读取
+	SendBuffer int `yaml:"sendBuffer"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (w WebSocket) WithDefaults() WebSocket {
+	if w.PingInterval <= 0 {
+		w.PingInterval = 30 * time.Second
+	}
+	if w.PongTimeout <= 0 {
+		w.PongTimeout = 2 * w.PingInterval
+	}
+	if w.WriteTimeout <= 0 {
+		w.WriteTimeout = 10 * time.Second
+	}
+	if w.MaxMessageSize <= 0 {
+		w.MaxMessageSize = 64 << 10
+	}
+	if w.SendBuffer <= 0 {
+		w.SendBuffer = 16
+	}
+	return w
+}
+
+// Chat BidiHello 聊天室配置，为 0 的字段使用默认值
+type Chat struct {
+	// SendQueue 每个成员等待发送的消息数，满了之后断开该成员，不影响聊天室中的其他成员
+	SendQueue int `yaml:"sendQueue"`
+	// History 每个聊天室保留的最近消息数，新成员加入时重放
+	History int `yaml:"history"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (c Chat) WithDefaults() Chat {
+	if c.SendQueue <= 0 {
+		c.SendQueue = 64
+	}
+	if c.History <= 0 {
+		c.History = 20
+	}
+	return c
+}
+
+// Events EventService 的配置，为 0 的字段使用默认值
+type Events struct {
+	// Heartbeat 订阅的流没有事件时发送心跳的间隔，应小于代理和负载均衡的空闲超时
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// MaxEvents 内存存储中每个主题保留的事件数，超过后清理最早的事件
+	MaxEvents int `yaml:"maxEvents"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (e Events) WithDefaults() Events {
+	if e.Heartbeat <= 0 {
+		e.Heartbeat = 15 * time.Second
+	}
+	if e.MaxEvents <= 0 {
+		e.MaxEvents = 1000
+	}
+	return e
+}
+
+// Batch LotsOfGreetings、BatchGreetings 的批量限制，为 0 的字段使用默认值
+type Batch struct {
+	// MaxItems 一个流最多接收的消息数
+	MaxItems int `yaml:"maxItems"`
+	// MaxBytes 一个流中所有消息的总字节数（protobuf 编码后）
+	MaxBytes int `yaml:"maxBytes"`
+	// AckEvery BatchGreetings 每收到多少条消息返回一次确认
+	AckEvery int `yaml:"ackEvery"`
+	// IdempotencyTTL BatchGreetings 的结果按幂等键保留的时间，期间相同幂等键的流直接返回该结果
+	IdempotencyTTL time.Duration `yaml:"idempotencyTTL"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (b Batch) WithDefaults() Batch {
+	if b.MaxItems <= 0 {
+		b.MaxItems = 1000
+	}
+	if b.MaxBytes <= 0 {
+		b.MaxBytes = 1 << 20
+	}
+	if b.AckEvery <= 0 {
+		b.AckEvery = 100
+	}
+	if b.IdempotencyTTL <= 0 {
+		b.IdempotencyTTL = 10 * time.Minute
+	}
+	return b
+}
+
+// Admin 管理端口，提供 channelz、pprof、版本信息、生效的配置和活跃的流，和网关分开监听
+// 配置中带 secret 标签的字段在管理端口中显示为 ******
+type Admin struct {
+	Enabled bool `yaml:"enabled"`
+	// Addr 监听地址，默认只监听本机
+	Addr string `yaml:"addr"`
+	// Username、Password HTTP Basic 认证，和业务接口的token无关，Password 为空时不启动
+	Username string `yaml:"username"`
+	Password string `yaml:"password" secret:"true"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (a Admin) WithDefaults() Admin {
+	if a.Addr == "" {
+		a.Addr = "127.0.0.1:8082"
+	}
+	if a.Username == "" {
+		a.Username = "admin"
+	}
+	return a
+}
+
+// Tenants 多租户配置。租户优先使用 token 中的 Tenant，没有时使用 default 租户
+type Tenants struct {
+	// TrustMetadata token 中没有租户时使用元数据 tenant 中的租户，只应在调用方可信时开启
+	TrustMetadata bool `yaml:"trustMetadata"`
+	// Quotas 按租户名称的配额，* 表示其他租户
+	Quotas Quotas `yaml:"quotas"`
+}
+
+// Quotas 租户名称 -> 配额
+type Quotas map[string]Quota
+
+// For 返回租户的配额，没有单独配置时使用 *
+func (q Quotas) For(tenant string) Quota {
+	if quota, ok := q[tenant]; ok {
+		return quota
+	}
+	return q["*"]
+}
+
+// Quota 租户的配额，0 表示不限制
+type Quota struct {
+	// StorageBytes FileService 中保存的文件总字节数
+	StorageBytes int64 `yaml:"storageBytes"`
+	// Files FileService 中保存的文件数
+	Files int `yaml:"files"`
+}
+
+// Files FileService 的存储配置
+type Files struct {
+	// Root 上传文件按租户保存在 Root/<租户>/ 下，DownLoadFile 按名称下载当前租户的文件；为空时不保存上传的文件
+	Root string `yaml:"root"`
+}
+
+// Auth 服务端token的签名和令牌接口配置
+type Auth struct {
+	// SigningKey HS256 签名密钥，至少 32 字节，为空时服务端不能启动
+	SigningKey string `yaml:"signingKey" secret:"true"`
+	// TokenTTL 签发的token有效期
+	TokenTTL time.Duration `yaml:"tokenTTL"`
+	// AllowInsecure 允许通过明文 HTTP 调用令牌接口，只用于本地测试，
+	// 否则只接受 TLS 或者来自 TrustedProxies 且 X-Forwarded-Proto 为 https 的请求
+	AllowInsecure bool `yaml:"allowInsecure"`
+	// TrustedProxies 终止 TLS 的代理的 IP 或 CIDR，只有来自这些地址的请求才按 X-Forwarded-Proto 判断是否为 HTTPS
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// Clients 允许获取token的客户端
+	Clients []AuthClient `yaml:"clients"`
+}
+
+// AuthClient 通过 client_id、client_secret 获取token的客户端，签发的token中携带对应的用户
+type AuthClient struct {
+	ID       string `yaml:"id"`
+	Secret   string `yaml:"secret" secret:"true"`
+	UserID   uint   `yaml:"userID"`
+	Username string `yaml:"username"`
+	// Tenant 签发的token中携带的租户
+	Tenant string `yaml:"tenant"`
+}
+
+// RetryPolicy 重试策略，对应 gRPC service config 中的 retryPolicy
+type RetryPolicy struct {
+	// Methods 默认重试的完整方法名，只应该包含幂等方法；其他方法只在单次调用通过 conn.WithRetryPolicy 开启时重试
+	Methods []string `yaml:"methods"`
+	// MaxAttempts 最大尝试次数（包含第一次调用），gRPC 最多支持 5 次。
+	// Methods 不为空时至少为 2，InitialBackoff、MaxBackoff、BackoffMultiplier 必须大于 0，RetryableStatusCodes 不能为空
+	MaxAttempts       int           `yaml:"maxAttempts"`
+	InitialBackoff    time.Duration `yaml:"initialBackoff"`
+	MaxBackoff        time.Duration `yaml:"maxBackoff"`
+	BackoffMultiplier float64       `yaml:"backoffMultiplier"`
+	// RetryableStatusCodes 可重试的状态码名称，例如 UNAVAILABLE
+	RetryableStatusCodes []string `yaml:"retryableStatusCodes"`
+}
+
+// HedgingPolicy 对冲策略：请求在 HedgingDelay 内没有返回时并行发起新的请求，使用最先成功的结果
+// 只应该用于幂等方法
+type HedgingPolicy struct {
+	// Methods 启用对冲的完整方法名
+	Methods      []string      `yaml:"methods"`
+	MaxAttempts  int           `yaml:"maxAttempts"`
+	HedgingDelay time.Duration `yaml:"hedgingDelay"`
+	// NonFatalStatusCodes 返回这些状态码时立即发起下一次请求，其他错误直接返回
+	NonFatalStatusCodes []string `yaml:"nonFatalStatusCodes"`
+}
+
+// Timeout 客户端调用超时配置
+type Timeout struct {
+	// Default 调用方没有设置截止时间时使用的超时
+	Default time.Duration `yaml:"default"`
+	// Methods 按完整方法名覆盖
+	Methods map[string]time.Duration `yaml:"methods"`
+}
+
+// ForMethod 返回方法实际生效的超时
+func (t Timeout) ForMethod(method string) time.Duration {
+	if d, ok := t.Methods[method]; ok && d > 0 {
+		return d
+	}
+	return t.Default
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Deadline: Deadline{
+			Default: time.Minute,
+			Max:     10 * time.Minute,
+		},
+		Client: defaultClient(),
+		Gateway: Gateway{
+			Addr:       ":8081",
+			Backend:    defaultClient(),
+			Middleware: defaultMiddleware(),
+			JSON:       JSON{EmitUnpopulated: true},
+			Headers: Headers{
+				Incoming: IncomingHeaders{
+					Allow: map[string]string{
+						"Accept-Language": "",
+						"Client-Os":       "",
+					},
+				},
+			},
+		},
+		Auth: Auth{
+			TokenTTL: time.Hour,
+		},
+	}
+}
+
+func defaultMiddleware() Middleware {
+	return Middleware{
+		AccessLog: true,
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Accept-Language", "X-Request-Id"},
+			ExposedHeaders: []string{"X-Request-Id", "Retry-After", "WWW-Authenticate"},
+			MaxAge:         10 * time.Minute,
+		},
+		Security: SecurityHeaders{
+			HSTSMaxAge:   365 * 24 * time.Hour,
+			NoSniff:      true,
+			FrameOptions: "DENY",
+		},
+		MaxBodySize: 4 << 20,
+		Timeout:     30 * time.Second,
+	}
+}
+
+func defaultClient() Client {
+	return Client{
+		Target: "192.168.2.166:8080",
+		Balancer: Balancer{
+			Policy:               "round_robin",
+			HealthCheck:          true,
+			FileResolverInterval: time.Second,
+		},
+		Timeout: Timeout{
+			Default: 10 * time.Second,
+		},
+		Retry: RetryPolicy{
+			Methods: []string{
+				"/hello.v1.HelloService/SayHello",
+				"/hello.v1.HelloService/LotsOfReplies",
+				"/hello.v1.FileService/DownLoadFile",
+				"/hello.v1.EventService/Subscribe",
+			},
+			MaxAttempts:          3,
+			InitialBackoff:       100 * time.Millisecond,
+			MaxBackoff:           time.Second,
+			BackoffMultiplier:    2,
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		},
+		Auth: ClientAuth{
+			RefreshBefore: time.Minute,
+		},
+	}
+}
+
+// Load 从yaml文件加载配置，文件不存在时返回默认配置
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,202 @@
+// Package errs 定义服务端返回的业务错误。
+// 每个错误带有 gRPC 状态码和原因（reason），转换为 status 时原因和元数据放在 errdetails.ErrorInfo 中，
+// 客户端收到错误后可以用 FromError 还原，按原因区分同一个状态码下的不同错误，例如 token 过期和 token 格式错误。
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// Domain ErrorInfo 中的 domain，区分其他服务返回的原因
+const Domain = "go-grpc-example.keepon-online.github.com"
+
+// 错误原因，客户端按原因判断错误，值不能修改
+const (
+	ReasonTokenMissing     = "TOKEN_MISSING"
+	ReasonTokenExpired     = "TOKEN_EXPIRED"
+	ReasonTokenMalformed   = "TOKEN_MALFORMED"
+	ReasonTokenNotValidYet = "TOKEN_NOT_VALID_YET"
+	ReasonTokenInvalid     = "TOKEN_INVALID"
+	ReasonRateLimited      = "RATE_LIMITED"
+	ReasonFileNotFound     = "FILE_NOT_FOUND"
+	ReasonFileUnreadable   = "FILE_UNREADABLE"
+	ReasonSlowConsumer     = "SLOW_CONSUMER"
+	ReasonCursorExpired    = "CURSOR_EXPIRED"
+	ReasonBatchTooLarge    = "BATCH_TOO_LARGE"
+	ReasonBatchInProgress  = "BATCH_IN_PROGRESS"
+	ReasonTenantInvalid    = "TENANT_INVALID"
+	ReasonTenantDenied     = "TENANT_DENIED"
+	ReasonQuotaExceeded    = "QUOTA_EXCEEDED"
+)
+
+var (
+	ErrTokenMissing     = New(codes.Unauthenticated, ReasonTokenMissing, "token不存在")
+	ErrTokenExpired     = New(codes.Unauthenticated, ReasonTokenExpired, "token已过期，请使用新的token")
+	ErrTokenMalformed   = New(codes.Unauthenticated, ReasonTokenMalformed, "token格式错误")
+	ErrTokenNotValidYet = New(codes.Unauthenticated, ReasonTokenNotValidYet, "token尚未生效")
+	ErrTokenInvalid     = New(codes.Unauthenticated, ReasonTokenInvalid, "token校验失败")
+	ErrRateLimited      = New(codes.ResourceExhausted, ReasonRateLimited, "请求过于频繁，请稍后重试")
+	ErrFileNotFound     = New(codes.NotFound, ReasonFileNotFound, "文件不存在")
+	ErrFileUnreadable   = New(codes.Internal, ReasonFileUnreadable, "读取文件失败")
+	ErrSlowConsumer     = New(codes.ResourceExhausted, ReasonSlowConsumer, "接收消息太慢，已断开连接")
+	ErrCursorExpired    = New(codes.OutOfRange, ReasonCursorExpired, "订阅位置之后的事件已经被清理")
+	ErrBatchTooLarge    = New(codes.ResourceExhausted, ReasonBatchTooLarge, "批量消息的数量或大小超过限制")
+	ErrBatchInProgress  = New(codes.Aborted, ReasonBatchInProgress, "相同幂等键的流正在处理")
+	ErrTenantInvalid    = New(codes.InvalidArgument, ReasonTenantInvalid, "租户名称不合法")
+	ErrTenantDenied     = New(codes.PermissionDenied, ReasonTenantDenied, "无权访问该租户")
+	ErrQuotaExceeded    = New(codes.ResourceExhausted, ReasonQuotaExceeded, "超过租户的配额")
+)
+
+// Error 业务错误，实现了 GRPCStatus，可以直接在服务方法中返回
+type Error struct {
+	Code    codes.Code
+	Reason  string
+	Message string
+	// Metadata 放在 ErrorInfo 中返回给客户端，不要放敏感信息
+	Metadata map[string]string
+	// Details ErrorInfo 以外的其他详情，例如 RetryInfo
+	Details []protoiface.MessageV1
+	cause   error
+}
+
+// New 创建一个业务错误
+func New(code codes.Code, reason, message string) *Error {
+	return &Error{Code: code, Reason: reason, Message: message}
+}
+
+// Newf 创建一个业务错误，message 按 fmt.Sprintf 格式化
+func Newf(code codes.Code, reason, format string, args ...interface{}) *Error {
+	return New(code, reason, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %s: %v", e.Code, e.Reason, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Code, e.Reason, e.Message)
+}
+
+// Unwrap 返回 WithCause 设置的原始错误
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is 状态码和原因相同时认为是同一个错误，errors.Is(err, errs.ErrTokenExpired) 不受元数据和原始错误影响
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code && e.Reason == t.Reason
+}
+
+// WithCause 返回带有原始错误的副本，原始错误只用于日志，不会返回给客户端
+func (e *Error) WithCause(err error) *Error {
+	c := e.clone()
+	c.cause = err
+	return c
+}
+
+// WithMetadata 返回添加了元数据的副本
+func (e *Error) WithMetadata(key, value string) *Error {
+	c := e.clone()
+	c.Metadata = make(map[string]string, len(e.Metadata)+1)
+	for k, v := range e.Metadata {
+		c.Metadata[k] = v
+	}
+	c.Metadata[key] = value
+	return c
+}
+
+// WithMessage 返回替换了错误信息的副本
+func (e *Error) WithMessage(message string) *Error {
+	c := e.clone()
+	c.Message = message
+	return c
+}
+
+// WithDetails 返回添加了详情的副本
+func (e *Error) WithDetails(details ...protoiface.MessageV1) *Error {
+	c := e.clone()
+	c.Details = append(append([]protoiface.MessageV1(nil), e.Details...), details...)
+	return c
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	return &c
+}
+
+// GRPCStatus 转换为 status，ErrorInfo 放在第一个详情
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+	details := make([]protoiface.MessageV1, 0, len(e.Details)+1)
+	details = append(details, &errdetails.ErrorInfo{
+		Reason:   e.Reason,
+		Domain:   Domain,
+		Metadata: e.Metadata,
+	})
+	details = append(details, e.Details...)
+	ds, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return ds
+}
+
+// FromStatus 从 status 还原业务错误，没有本服务的 ErrorInfo 时原因为空
+func FromStatus(st *status.Status) *Error {
+	e := &Error{Code: st.Code(), Message: st.Message()}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.GetDomain() == Domain && e.Reason == "" {
+				e.Reason = d.GetReason()
+				e.Metadata = d.GetMetadata()
+				continue
+			}
+			e.Details = append(e.Details, d)
+		case protoiface.MessageV1:
+			e.Details = append(e.Details, d)
+		}
+	}
+	return e
+}
+
+// FromError 把任意错误转换为 *Error：
+// 本身是 *Error 时直接返回，gRPC 错误按 FromStatus 还原，上下文错误转换为 Canceled 或 DeadlineExceeded，其他错误为 Unknown
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if st, ok := status.FromError(err); ok {
+		return FromStatus(st)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return FromStatus(status.FromContextError(err)).WithCause(err)
+	}
+	return New(codes.Unknown, "", err.Error()).WithCause(err)
+}
+
+// Reason 返回错误的原因，不是本服务的业务错误时返回空字符串
+func Reason(err error) string {
+	if e := FromError(err); e != nil {
+		return e.Reason
+	}
+	return ""
+}
+
+// IsReason 判断错误的原因
+func IsReason(err error, reason string) bool {
+	return err != nil && Reason(err) == reason
+}
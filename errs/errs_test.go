@@ -0,0 +1,100 @@
+package errs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"testing"
+	"time"
+)
+
+func TestStatusRoundTrip(t *testing.T) {
+	in := errs.ErrRateLimited.
+		WithMetadata("method", "/hello.HelloService/SayHello").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)}).
+		WithCause(errors.New("bucket empty"))
+
+	// 模拟经过网络传输：只保留 status 的 proto
+	err := status.ErrorProto(status.Convert(in).Proto())
+	out := errs.FromError(err)
+
+	if out.Code != codes.ResourceExhausted || out.Reason != errs.ReasonRateLimited || out.Message != in.Message {
+		t.Errorf("FromError = %+v", out)
+	}
+	if got := out.Metadata["method"]; got != "/hello.HelloService/SayHello" {
+		t.Errorf("metadata method = %q", got)
+	}
+	if len(out.Details) != 1 {
+		t.Fatalf("details = %v, want RetryInfo only", out.Details)
+	}
+	if _, ok := out.Details[0].(*errdetails.RetryInfo); !ok {
+		t.Errorf("details[0] = %T, want RetryInfo", out.Details[0])
+	}
+	if out.Unwrap() != nil {
+		t.Errorf("cause sent to client: %v", out.Unwrap())
+	}
+	if !errors.Is(out, errs.ErrRateLimited) {
+		t.Errorf("errors.Is(%v, ErrRateLimited) = false", out)
+	}
+}
+
+func TestFromError(t *testing.T) {
+	cause := errors.New("open /data/a.txt: no such file")
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{name: "domain error", err: errs.ErrTokenExpired, wantCode: codes.Unauthenticated, wantReason: errs.ReasonTokenExpired},
+		{name: "wrapped domain error", err: fmt.Errorf("call: %w", errs.ErrFileNotFound.WithCause(cause)), wantCode: codes.NotFound, wantReason: errs.ReasonFileNotFound},
+		{name: "plain status", err: status.Error(codes.NotFound, "x"), wantCode: codes.NotFound},
+		{name: "foreign domain", err: foreignError(t), wantCode: codes.Unauthenticated},
+		{name: "deadline", err: context.DeadlineExceeded, wantCode: codes.DeadlineExceeded},
+		{name: "plain error", err: cause, wantCode: codes.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := errs.FromError(tt.err)
+			if e.Code != tt.wantCode || e.Reason != tt.wantReason {
+				t.Errorf("FromError = %s/%q, want %s/%q", e.Code, e.Reason, tt.wantCode, tt.wantReason)
+			}
+			if got := status.Code(tt.err); tt.wantReason != "" && got != tt.wantCode {
+				t.Errorf("status.Code = %s, want %s", got, tt.wantCode)
+			}
+		})
+	}
+	if errs.FromError(nil) != nil || errs.IsReason(nil, "") {
+		t.Error("nil error converted to non-nil")
+	}
+}
+
+func TestIs(t *testing.T) {
+	err := errs.ErrTokenExpired.WithCause(errors.New("jwt")).WithMetadata("uid", "1")
+	if !errors.Is(err, errs.ErrTokenExpired) {
+		t.Error("errors.Is with metadata and cause = false")
+	}
+	if errors.Is(err, errs.ErrTokenMalformed) {
+		t.Error("expired matched malformed")
+	}
+	if errs.ErrTokenExpired.Metadata != nil {
+		t.Error("WithMetadata modified the shared error")
+	}
+}
+
+// foreignError 其他服务返回的 ErrorInfo 不作为原因
+func foreignError(t *testing.T) error {
+	st, err := status.New(codes.Unauthenticated, "x").WithDetails(&errdetails.ErrorInfo{
+		Reason: errs.ReasonTokenExpired,
+		Domain: "googleapis.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
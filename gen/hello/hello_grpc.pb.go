@@ -24,6 +24,7 @@ const (
 	HelloService_SayHello_FullMethodName        = "/hello.v1.HelloService/SayHello"
 	HelloService_LotsOfReplies_FullMethodName   = "/hello.v1.HelloService/LotsOfReplies"
 	HelloService_LotsOfGreetings_FullMethodName = "/hello.v1.HelloService/LotsOfGreetings"
+	HelloService_BatchGreetings_FullMethodName  = "/hello.v1.HelloService/BatchGreetings"
 	HelloService_BidiHello_FullMethodName       = "/hello.v1.HelloService/BidiHello"
 )
 
@@ -33,11 +34,15 @@ const (
 type HelloServiceClient interface {
 	// 定义函数
 	SayHello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloResponse, error)
-	// 服务端返回流式数据
+	// 服务端返回流式数据，网关按 Accept 返回 NDJSON 或 Server-Sent Events
 	LotsOfReplies(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (HelloService_LotsOfRepliesClient, error)
-	// 客户端发送流式数据
+	// 客户端发送流式数据，网关请求体中依次写入多个 JSON 对象，可以使用分块传输
 	LotsOfGreetings(ctx context.Context, opts ...grpc.CallOption) (HelloService_LotsOfGreetingsClient, error)
-	// 双向流式数据
+	// LotsOfGreetings 的批量版本：每条消息单独校验，不通过的消息不会中断流；
+	// 每收到一定数量的消息返回一次确认，客户端关闭发送后返回最终结果。相同幂等键的流只处理一次
+	BatchGreetings(ctx context.Context, opts ...grpc.CallOption) (HelloService_BatchGreetingsClient, error)
+	// 双向流式数据，HTTP/1.1 下网关读完请求体后才返回响应。
+	// 通过 room 元数据或第一条消息的 room 加入聊天室，消息广播给聊天室中的所有流；不指定聊天室时原样返回
 	BidiHello(ctx context.Context, opts ...grpc.CallOption) (HelloService_BidiHelloClient, error)
 }
 
@@ -124,8 +129,39 @@ func (x *helloServiceLotsOfGreetingsClient) CloseAndRecv() (*HelloResponse, erro
 	return m, nil
 }
 
+func (c *helloServiceClient) BatchGreetings(ctx context.Context, opts ...grpc.CallOption) (HelloService_BatchGreetingsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &HelloService_ServiceDesc.Streams[2], HelloService_BatchGreetings_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &helloServiceBatchGreetingsClient{stream}
+	return x, nil
+}
+
+type HelloService_BatchGreetingsClient interface {
+	Send(*BatchGreetingRequest) error
+	Recv() (*BatchGreetingResponse, error)
+	grpc.ClientStream
+}
+
+type helloServiceBatchGreetingsClient struct {
+	grpc.ClientStream
+}
+
+func (x *helloServiceBatchGreetingsClient) Send(m *BatchGreetingRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *helloServiceBatchGreetingsClient) Recv() (*BatchGreetingResponse, error) {
+	m := new(BatchGreetingResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *helloServiceClient) BidiHello(ctx context.Context, opts ...grpc.CallOption) (HelloService_BidiHelloClient, error) {
-	stream, err := c.cc.NewStream(ctx, &HelloService_ServiceDesc.Streams[2], HelloService_BidiHello_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &HelloService_ServiceDesc.Streams[3], HelloService_BidiHello_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -161,11 +197,15 @@ func (x *helloServiceBidiHelloClient) Recv() (*HelloResponse, error) {
 type HelloServiceServer interface {
 	// 定义函数
 	SayHello(context.Context, *HelloRequest) (*HelloResponse, error)
-	// 服务端返回流式数据
+	// 服务端返回流式数据，网关按 Accept 返回 NDJSON 或 Server-Sent Events
 	LotsOfReplies(*HelloRequest, HelloService_LotsOfRepliesServer) error
-	// 客户端发送流式数据
+	// 客户端发送流式数据，网关请求体中依次写入多个 JSON 对象，可以使用分块传输
 	LotsOfGreetings(HelloService_LotsOfGreetingsServer) error
-	// 双向流式数据
+	// LotsOfGreetings 的批量版本：每条消息单独校验，不通过的消息不会中断流；
+	// 每收到一定数量的消息返回一次确认，客户端关闭发送后返回最终结果。相同幂等键的流只处理一次
+	BatchGreetings(HelloService_BatchGreetingsServer) error
+	// 双向流式数据，HTTP/1.1 下网关读完请求体后才返回响应。
+	// 通过 room 元数据或第一条消息的 room 加入聊天室，消息广播给聊天室中的所有流；不指定聊天室时原样返回
 	BidiHello(HelloService_BidiHelloServer) error
 	mustEmbedUnimplementedHelloServiceServer()
 }
@@ -183,6 +223,9 @@ func (UnimplementedHelloServiceServer) LotsOfReplies(*HelloRequest, HelloService
 func (UnimplementedHelloServiceServer) LotsOfGreetings(HelloService_LotsOfGreetingsServer) error {
 	return status.Errorf(codes.Unimplemented, "method LotsOfGreetings not implemented")
 }
+func (UnimplementedHelloServiceServer) BatchGreetings(HelloService_BatchGreetingsServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchGreetings not implemented")
+}
 func (UnimplementedHelloServiceServer) BidiHello(HelloService_BidiHelloServer) error {
 	return status.Errorf(codes.Unimplemented, "method BidiHello not implemented")
 }
@@ -264,6 +307,32 @@ func (x *helloServiceLotsOfGreetingsServer) Recv() (*HelloRequest, error) {
 	return m, nil
 }
 
+func _HelloService_BatchGreetings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HelloServiceServer).BatchGreetings(&helloServiceBatchGreetingsServer{stream})
+}
+
+type HelloService_BatchGreetingsServer interface {
+	Send(*BatchGreetingResponse) error
+	Recv() (*BatchGreetingRequest, error)
+	grpc.ServerStream
+}
+
+type helloServiceBatchGreetingsServer struct {
+	grpc.ServerStream
+}
+
+func (x *helloServiceBatchGreetingsServer) Send(m *BatchGreetingResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *helloServiceBatchGreetingsServer) Recv() (*BatchGreetingRequest, error) {
+	m := new(BatchGreetingRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _HelloService_BidiHello_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(HelloServiceServer).BidiHello(&helloServiceBidiHelloServer{stream})
 }
@@ -313,6 +382,12 @@ var HelloService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _HelloService_LotsOfGreetings_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "BatchGreetings",
+			Handler:       _HelloService_BatchGreetings_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "BidiHello",
 			Handler:       _HelloService_BidiHello_Handler,
@@ -415,6 +490,167 @@ var GatewayService_ServiceDesc = grpc.ServiceDesc{
 	Metadata: "hello.proto",
 }
 
+const (
+	EventService_Publish_FullMethodName   = "/hello.v1.EventService/Publish"
+	EventService_Subscribe_FullMethodName = "/hello.v1.EventService/Subscribe"
+)
+
+// EventServiceClient is the client API for EventService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EventServiceClient interface {
+	// Publish 发布事件，订阅了该主题的流立即收到
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Event, error)
+	// Subscribe 订阅主题，先推送 after 之后已经发布的事件，再推送新发布的事件；
+	// 没有事件时按固定间隔发送心跳。断开后用收到的最后一个 seq 作为 after 重新订阅，不会丢失事件
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (EventService_SubscribeClient, error)
+}
+
+type eventServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventServiceClient(cc grpc.ClientConnInterface) EventServiceClient {
+	return &eventServiceClient{cc}
+}
+
+func (c *eventServiceClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Event, error) {
+	out := new(Event)
+	err := c.cc.Invoke(ctx, EventService_Publish_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventServiceClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (EventService_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EventService_ServiceDesc.Streams[0], EventService_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eventServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EventService_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type eventServiceSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *eventServiceSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EventServiceServer is the server API for EventService service.
+// All implementations must embed UnimplementedEventServiceServer
+// for forward compatibility
+type EventServiceServer interface {
+	// Publish 发布事件，订阅了该主题的流立即收到
+	Publish(context.Context, *PublishRequest) (*Event, error)
+	// Subscribe 订阅主题，先推送 after 之后已经发布的事件，再推送新发布的事件；
+	// 没有事件时按固定间隔发送心跳。断开后用收到的最后一个 seq 作为 after 重新订阅，不会丢失事件
+	Subscribe(*SubscribeRequest, EventService_SubscribeServer) error
+	mustEmbedUnimplementedEventServiceServer()
+}
+
+// UnimplementedEventServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedEventServiceServer struct {
+}
+
+func (UnimplementedEventServiceServer) Publish(context.Context, *PublishRequest) (*Event, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedEventServiceServer) Subscribe(*SubscribeRequest, EventService_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedEventServiceServer) mustEmbedUnimplementedEventServiceServer() {}
+
+// UnsafeEventServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventServiceServer will
+// result in compilation errors.
+type UnsafeEventServiceServer interface {
+	mustEmbedUnimplementedEventServiceServer()
+}
+
+func RegisterEventServiceServer(s grpc.ServiceRegistrar, srv EventServiceServer) {
+	s.RegisterService(&EventService_ServiceDesc, srv)
+}
+
+func _EventService_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventServiceServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EventService_Publish_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventServiceServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventService_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EventServiceServer).Subscribe(m, &eventServiceSubscribeServer{stream})
+}
+
+type EventService_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type eventServiceSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *eventServiceSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// EventService_ServiceDesc is the grpc.ServiceDesc for EventService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hello.v1.EventService",
+	HandlerType: (*EventServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    _EventService_Publish_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _EventService_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "hello.proto",
+}
+
 const (
 	FileService_DownLoadFile_FullMethodName = "/hello.v1.FileService/DownLoadFile"
 	FileService_UploadFile_FullMethodName   = "/hello.v1.FileService/UploadFile"
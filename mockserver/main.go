@@ -0,0 +1,51 @@
+// mockserver 根据 fixture 文件模拟 HelloService、GatewayService 和 FileService，
+// 不校验token，也不读取真实文件，供调用方在本地做契约测试
+//
+//	go run ./mockserver -fixtures conf/mock.yaml -addr :8080
+package main
+
+import (
+	"flag"
+	"github.com/keepon-online/go-grpc-example/server/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+	"log"
+	"net"
+)
+
+var (
+	addr     = flag.String("addr", ":8080", "监听地址")
+	fixtures = flag.String("fixtures", "conf/mock.yaml", "fixture 文件路径")
+	certFile = flag.String("cert", "", "TLS 证书，为空时不使用 TLS")
+	keyFile  = flag.String("key", "", "TLS 私钥")
+)
+
+func main() {
+	flag.Parse()
+	f, err := mock.Load(*fixtures)
+	if err != nil {
+		log.Fatalf("Failed to load fixtures %s: %v", *fixtures, err)
+	}
+	var opts []grpc.ServerOption
+	if *certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS credentials: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s := grpc.NewServer(opts...)
+	mock.Register(s, f)
+	// 方便使用 grpcurl 等工具直接调用
+	reflection.Register(s)
+
+	listen, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Printf("mock server with %d rules listening on %s", len(f.Rules), *addr)
+	if err := s.Serve(listen); err != nil {
+		log.Fatalf("Serve: %v", err)
+	}
+}
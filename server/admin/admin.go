@@ -0,0 +1,191 @@
+// Package admin 管理端口的 HTTP 处理器：channelz、pprof、expvar、版本信息、生效的配置和进行中的流，
+// 用于排查问题，和网关分开监听，默认只监听本机
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"github.com/keepon-online/go-grpc-example/config"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// started 进程的启动时间
+var started = time.Now()
+
+// redacted 敏感字段的显示值
+const redacted = "******"
+
+const index = `/debug/version        版本和构建信息
+/debug/config         生效的配置，敏感字段已隐藏
+/debug/streams        进行中的流
+/debug/vars           expvar 指标，包括按租户的调用次数
+/debug/channelz/      channelz：channels、servers、channel、subchannel、server、serversockets、socket
+/debug/pprof/         pprof
+`
+
+// NewHandler 创建管理端口的处理器，所有路径都需要 admin 配置中的 HTTP Basic 认证
+func NewHandler(cfg *config.Config, streams *Streams) (http.Handler, error) {
+	admin := cfg.Admin.WithDefaults()
+	if admin.Password == "" {
+		return nil, errors.New("admin.password 不能为空")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(index))
+	})
+	mux.HandleFunc("/debug/version", versionHandler)
+	mux.Handle("/debug/config", configHandler(cfg))
+	mux.Handle("/debug/streams", streamsHandler(streams))
+	mux.Handle("/debug/channelz/", channelzHandler())
+	mux.Handle("/debug/vars", expvar.Handler())
+	// 不使用 http.DefaultServeMux，pprof 只在管理端口上提供
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return basicAuth(admin, mux), nil
+}
+
+// basicAuth 比较用户名和密码时使用固定时间的比较
+func basicAuth(cfg config.Admin, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(cfg.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type versionResponse struct {
+	// Path、Version 主模块的路径和版本，go build 构建时版本为 (devel)
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// Revision、Time、Modified 构建时的 git 提交、提交时间和是否有未提交的修改
+	Revision   string    `json:"revision,omitempty"`
+	Time       string    `json:"time,omitempty"`
+	Modified   bool      `json:"modified"`
+	GoVersion  string    `json:"goVersion"`
+	Started    time.Time `json:"started"`
+	Uptime     string    `json:"uptime"`
+	Goroutines int       `json:"goroutines"`
+	// Deps 依赖的模块，例如 google.golang.org/grpc@v1.55.0
+	Deps []string `json:"deps"`
+}
+
+func versionHandler(w http.ResponseWriter, _ *http.Request) {
+	resp := versionResponse{
+		GoVersion:  runtime.Version(),
+		Started:    started,
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Deps:       []string{},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		resp.Path, resp.Version = info.Main.Path, info.Main.Version
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				resp.Revision = s.Value
+			case "vcs.time":
+				resp.Time = s.Value
+			case "vcs.modified":
+				resp.Modified = s.Value == "true"
+			}
+		}
+		for _, dep := range info.Deps {
+			resp.Deps = append(resp.Deps, dep.Path+"@"+dep.Version)
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// configHandler 以 YAML 返回生效的配置：填充了各部分的默认值，带 secret 标签的字段显示为 ******
+func configHandler(cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		data, err := yaml.Marshal(Effective(cfg))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+	})
+}
+
+// Effective 返回配置的副本：有 WithDefaults 方法的部分使用填充默认值后的结果，带 secret 标签的非空字段替换为 ******
+func Effective(cfg *config.Config) *config.Config {
+	out := effective(reflect.ValueOf(*cfg)).Interface().(config.Config)
+	return &out
+}
+
+func effective(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		if m := out.MethodByName("WithDefaults"); m.IsValid() && m.Type().NumIn() == 0 &&
+			m.Type().NumOut() == 1 && m.Type().Out(0) == v.Type() {
+			out.Set(m.Call(nil)[0])
+		}
+		for i := 0; i < out.NumField(); i++ {
+			f := out.Field(i)
+			if !out.Type().Field(i).IsExported() {
+				continue
+			}
+			if _, ok := out.Type().Field(i).Tag.Lookup("secret"); ok && f.Kind() == reflect.String {
+				if f.String() != "" {
+					f.SetString(redacted)
+				}
+				continue
+			}
+			f.Set(effective(f))
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(effective(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), effective(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,171 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/admin"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHandler(t *testing.T, cfg *config.Config, streams *admin.Streams) http.Handler {
+	t.Helper()
+	cfg.Admin.Password = "pw"
+	h, err := admin.NewHandler(cfg, streams)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	return h
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.SetBasicAuth("admin", "pw")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNewHandlerRequiresPassword(t *testing.T) {
+	if _, err := admin.NewHandler(config.Default(), admin.NewStreams()); err == nil {
+		t.Error("NewHandler without password succeeded")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := newHandler(t, config.Default(), admin.NewStreams())
+	tests := []struct {
+		name       string
+		user, pass string
+		want       int
+	}{
+		{name: "no credentials", want: http.StatusUnauthorized},
+		{name: "wrong password", user: "admin", pass: "wrong", want: http.StatusUnauthorized},
+		{name: "wrong user", user: "root", pass: "pw", want: http.StatusUnauthorized},
+		{name: "ok", user: "admin", pass: "pw", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/version", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.Clients = []config.AuthClient{{ID: "demo", Secret: "client-secret"}}
+	cfg.Client.Auth.ClientSecret = "sdk-secret"
+	h := newHandler(t, cfg, admin.NewStreams())
+
+	rec := get(h, "/debug/config")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	for _, secret := range []string{"client-secret", "sdk-secret", "password: pw"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("config contains %q", secret)
+		}
+	}
+	// 没有配置的部分显示默认值
+	for _, want := range []string{"secret: '******'", "password: '******'", "maxConnectionAge: 30m0s", "addr: 127.0.0.1:8082"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("config does not contain %q:\n%s", want, body)
+		}
+	}
+	// 不修改原来的配置
+	if cfg.Auth.Clients[0].Secret != "client-secret" || cfg.Admin.Addr != "" {
+		t.Errorf("original config modified: %+v", cfg)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	streams := admin.NewStreams()
+	s := servertest.Start(t, servertest.WithAuth(), servertest.WithStreamInterceptors(streams.StreamServerInterceptor()))
+	h := newHandler(t, config.Default(), streams)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := s.Hello.BidiHello(ctx)
+	if err != nil {
+		t.Fatalf("BidiHello: %v", err)
+	}
+	// 收到回复说明服务端已经开始处理
+	if err := stream.Send(&hello.HelloRequest{Name: "a"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+
+	var resp struct {
+		Total   int `json:"total"`
+		Methods []struct {
+			Method  string `json:"method"`
+			Count   int    `json:"count"`
+			Streams []struct {
+				User string `json:"user"`
+				Age  string `json:"age"`
+			} `json:"streams"`
+		} `json:"methods"`
+	}
+	if err := json.Unmarshal(get(h, "/debug/streams").Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 || len(resp.Methods) != 1 || resp.Methods[0].Method != "/hello.v1.HelloService/BidiHello" ||
+		resp.Methods[0].Streams[0].User != "servertest" {
+		t.Errorf("streams = %+v", resp)
+	}
+
+	stream.CloseSend()
+	stream.Recv()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(streams.Active()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stream still active after close: %v", streams.Active())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestChannelz(t *testing.T) {
+	servertest.Start(t)
+	h := newHandler(t, config.Default(), admin.NewStreams())
+	tests := []struct {
+		path     string
+		want     int
+		contains string
+	}{
+		{path: "/debug/channelz/servers", want: http.StatusOK, contains: `"server"`},
+		{path: "/debug/channelz/channels", want: http.StatusOK, contains: `"channel"`},
+		{path: "/debug/channelz/channel?id=999999999", want: http.StatusNotFound},
+		{path: "/debug/channelz/unknown", want: http.StatusNotFound},
+		{path: "/debug/pprof/", want: http.StatusOK, contains: "goroutine"},
+		{path: "/debug/version", want: http.StatusOK, contains: `"goVersion"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := get(h, tt.path)
+			if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("GET %s = %d %s", tt.path, rec.Code, rec.Body)
+			}
+		})
+	}
+}
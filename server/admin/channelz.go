@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// channelzRegistrar 取出 grpc 内置的 channelz 服务实现，在 HTTP 中直接调用，不需要在管理端口上再启动 gRPC 服务
+type channelzRegistrar struct {
+	srv channelzpb.ChannelzServer
+}
+
+func (r *channelzRegistrar) RegisterService(_ *grpc.ServiceDesc, impl interface{}) {
+	r.srv = impl.(channelzpb.ChannelzServer)
+}
+
+// channelzHandler 以 JSON 返回 channelz 数据：
+//
+//	/debug/channelz/channels?start_id=    客户端连接（网关连接服务端）
+//	/debug/channelz/servers?start_id=     gRPC 服务端
+//	/debug/channelz/channel?id=
+//	/debug/channelz/subchannel?id=
+//	/debug/channelz/server?id=
+//	/debug/channelz/serversockets?id=&start_id=
+//	/debug/channelz/socket?id=
+func channelzHandler() http.Handler {
+	r := &channelzRegistrar{}
+	channelzsvc.RegisterChannelzServiceToServer(r)
+	cz := r.srv
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		id, _ := strconv.ParseInt(q.Get("id"), 10, 64)
+		start, _ := strconv.ParseInt(q.Get("start_id"), 10, 64)
+		ctx := req.Context()
+		var resp proto.Message
+		var err error
+		switch strings.TrimPrefix(req.URL.Path, "/debug/channelz/") {
+		case "channels":
+			resp, err = cz.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{StartChannelId: start})
+		case "servers":
+			resp, err = cz.GetServers(ctx, &channelzpb.GetServersRequest{StartServerId: start})
+		case "channel":
+			resp, err = cz.GetChannel(ctx, &channelzpb.GetChannelRequest{ChannelId: id})
+		case "subchannel":
+			resp, err = cz.GetSubchannel(ctx, &channelzpb.GetSubchannelRequest{SubchannelId: id})
+		case "server":
+			resp, err = cz.GetServer(ctx, &channelzpb.GetServerRequest{ServerId: id})
+		case "serversockets":
+			resp, err = cz.GetServerSockets(ctx, &channelzpb.GetServerSocketsRequest{ServerId: id, StartSocketId: start})
+		case "socket":
+			resp, err = cz.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: id})
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			code := http.StatusInternalServerError
+			if status.Code(err) == codes.NotFound {
+				code = http.StatusNotFound
+			}
+			http.Error(w, status.Convert(err).Message(), code)
+			return
+		}
+		data, err := protojson.MarshalOptions{Multiline: true}.Marshal(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Streams 记录进行中的流，管理端口按方法列出
+type Streams struct {
+	mu     sync.Mutex
+	nextID uint64
+	active map[uint64]StreamInfo
+}
+
+// StreamInfo 进行中的流
+type StreamInfo struct {
+	Method string `json:"method"`
+	// Peer 客户端地址，经过网关的流为网关的地址
+	Peer string `json:"peer"`
+	// User 认证通过的用户名
+	User   string    `json:"user,omitempty"`
+	Tenant string    `json:"tenant"`
+	Start  time.Time `json:"start"`
+}
+
+// NewStreams 创建记录，通过 StreamServerInterceptor 加入服务端的拦截器链
+func NewStreams() *Streams {
+	return &Streams{active: make(map[uint64]StreamInfo)}
+}
+
+// StreamServerInterceptor 在流开始时记录、结束时删除，放在认证和租户拦截器之后才能记录用户和租户
+func (s *Streams) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+		si := StreamInfo{Method: info.FullMethod, Tenant: handler.TenantFromContext(ss.Context()), Start: time.Now()}
+		if p, ok := peer.FromContext(ss.Context()); ok {
+			si.Peer = p.Addr.String()
+		}
+		if claims, ok := handler.ClaimsFromContext(ss.Context()); ok {
+			si.User = claims.Username
+		}
+		id := s.add(si)
+		defer s.remove(id)
+		return h(srv, ss)
+	}
+}
+
+func (s *Streams) add(si StreamInfo) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.active[s.nextID] = si
+	return s.nextID
+}
+
+func (s *Streams) remove(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, id)
+}
+
+// Active 按方法分组返回进行中的流，每组按开始时间排序
+func (s *Streams) Active() map[string][]StreamInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	methods := make(map[string][]StreamInfo)
+	for _, si := range s.active {
+		methods[si.Method] = append(methods[si.Method], si)
+	}
+	for _, streams := range methods {
+		sort.Slice(streams, func(i, j int) bool { return streams[i].Start.Before(streams[j].Start) })
+	}
+	return methods
+}
+
+type streamsResponse struct {
+	Total   int             `json:"total"`
+	Methods []methodStreams `json:"methods"`
+}
+
+type methodStreams struct {
+	Method  string       `json:"method"`
+	Count   int          `json:"count"`
+	Streams []streamJSON `json:"streams"`
+}
+
+type streamJSON struct {
+	StreamInfo
+	// Age 已经持续的时间，例如 1m30s
+	Age string `json:"age"`
+}
+
+// streamsHandler 以 JSON 返回进行中的流，方法按名称排序
+func streamsHandler(s *Streams) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		resp := streamsResponse{Methods: []methodStreams{}}
+		for method, streams := range s.Active() {
+			m := methodStreams{Method: method, Count: len(streams)}
+			for _, si := range streams {
+				m.Streams = append(m.Streams, streamJSON{StreamInfo: si, Age: now.Sub(si.Start).Round(time.Second).String()})
+			}
+			resp.Methods = append(resp.Methods, m)
+			resp.Total += m.Count
+		}
+		sort.Slice(resp.Methods, func(i, j int) bool { return resp.Methods[i].Method < resp.Methods[j].Method })
+		writeJSON(w, resp)
+	})
+}
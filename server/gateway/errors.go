@@ -0,0 +1,272 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RequestIDHeader 请求ID，客户端没有传时由网关生成，同时转发给 gRPC 服务端并在响应中返回
+const RequestIDHeader = "X-Request-Id"
+
+// ErrorBody 网关返回的错误，所有错误使用相同的结构
+type ErrorBody struct {
+	// Code gRPC 状态码名称，例如 UNAUTHENTICATED
+	Code string `json:"code"`
+	// Message 按 Accept-Language 本地化后的错误信息
+	Message string `json:"message"`
+	// Details 状态中的 errdetails，格式与 protojson 相同，带有 @type
+	Details   []json.RawMessage `json:"details"`
+	RequestID string            `json:"requestId"`
+}
+
+// ErrorHandler 使用默认 HeaderRules 的错误处理，见 NewErrorHandler
+var ErrorHandler = NewErrorHandler(NewHeaderRules(config.Headers{}))
+
+// NewErrorHandler 替换 grpc-gateway 默认的错误处理：
+// 按状态码返回对应的 HTTP 状态，限流时设置 Retry-After，认证失败时设置 WWW-Authenticate，
+// 服务端返回的元数据按 rules 转换为响应头
+func NewErrorHandler(rules *HeaderRules) runtime.ErrorHandlerFunc {
+	return func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler,
+		w http.ResponseWriter, r *http.Request, err error) {
+		handleError(ctx, rules, marshaler, w, r, err)
+	}
+}
+
+func handleError(ctx context.Context, rules *HeaderRules, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+	body := newErrorBody(st, localize(st, r.Header.Get("Accept-Language")), r.Header.Get(RequestIDHeader))
+
+	h := w.Header()
+	h.Del("Trailer")
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	if body.RequestID != "" {
+		h.Set(RequestIDHeader, body.RequestID)
+	}
+	rules.writeServerMetadata(ctx, w)
+	switch st.Code() {
+	case codes.Unauthenticated:
+		h.Set("WWW-Authenticate", "Bearer")
+	case codes.ResourceExhausted, codes.Unavailable:
+		if d, ok := retryDelay(st); ok {
+			h.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(d)), 10))
+		}
+	}
+
+	code := runtime.HTTPStatusFromCode(st.Code())
+	switch marshaler.(type) {
+	case *protoMarshaler, *yamlMarshaler:
+		// 客户端要求 protobuf 或 YAML 时以 ErrorResponse 返回，字段与 ErrorBody 相同
+		data, err := marshaler.Marshal(errorResponse(st, body.Message, body.RequestID))
+		if err == nil {
+			h.Set("Content-Type", marshaler.ContentType(nil))
+			w.WriteHeader(code)
+			if _, err := w.Write(data); err != nil {
+				log.Printf("gateway: write error body: %v", err)
+			}
+			return
+		}
+		log.Printf("gateway: marshal error response: %v", err)
+	}
+	writeError(w, code, body)
+}
+
+// writeError 以 JSON 返回错误，Content-Type 等响应头由调用方设置
+func writeError(w http.ResponseWriter, code int, body ErrorBody) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("gateway: marshal error body: %v", err)
+		http.Error(w, `{"code":"INTERNAL","message":"failed to marshal error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("gateway: write error body: %v", err)
+	}
+}
+
+// errorResponse ErrorBody 对应的 proto 消息，用于 protobuf 和 YAML 响应
+func errorResponse(st *status.Status, message, requestID string) *hello.ErrorResponse {
+	return &hello.ErrorResponse{
+		Code:      codeName(st.Code()),
+		Message:   message,
+		Details:   st.Proto().GetDetails(),
+		RequestId: requestID,
+	}
+}
+
+func newErrorBody(st *status.Status, message, requestID string) ErrorBody {
+	body := ErrorBody{
+		Code:      codeName(st.Code()),
+		Message:   message,
+		Details:   []json.RawMessage{},
+		RequestID: requestID,
+	}
+	for _, d := range st.Proto().GetDetails() {
+		data, err := protojson.Marshal(d)
+		if err != nil {
+			// 详情的类型没有注册时跳过，不影响返回错误
+			log.Printf("gateway: marshal error detail %s: %v", d.GetTypeUrl(), err)
+			continue
+		}
+		body.Details = append(body.Details, data)
+	}
+	return body
+}
+
+// codeName 返回 UNAUTHENTICATED 这样的状态码名称，与 gRPC service config 中的写法一致
+func codeName(c codes.Code) string {
+	if name, ok := code.Code_name[int32(c)]; ok {
+		return name
+	}
+	return c.String()
+}
+
+// retryDelay 返回 RetryInfo 中建议的重试间隔（秒）
+func retryDelay(st *status.Status) (float64, bool) {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration().Seconds(), true
+		}
+	}
+	return 0, false
+}
+
+// withRequestID 保证每个请求都有请求ID，转发给服务端时通过 CustomHeaderMatcher 带上
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// messages 服务端的错误信息是中文，客户端需要其他语言且没有 LocalizedMessage 详情时，按状态码返回通用的错误信息
+var messages = map[string]map[codes.Code]string{
+	"en": {
+		codes.Canceled:           "The request was cancelled.",
+		codes.Unknown:            "An unknown error occurred.",
+		codes.InvalidArgument:    "The request contains invalid parameters.",
+		codes.DeadlineExceeded:   "The request timed out.",
+		codes.NotFound:           "The requested resource was not found.",
+		codes.AlreadyExists:      "The resource already exists.",
+		codes.PermissionDenied:   "You do not have permission to perform this action.",
+		codes.ResourceExhausted:  "Too many requests, please try again later.",
+		codes.FailedPrecondition: "The request cannot be performed in the current state.",
+		codes.Aborted:            "The request was aborted, please try again.",
+		codes.OutOfRange:         "The request is out of range.",
+		codes.Unimplemented:      "This operation is not supported.",
+		codes.Internal:           "An internal error occurred.",
+		codes.Unavailable:        "The service is temporarily unavailable, please try again later.",
+		codes.DataLoss:           "Data was lost or corrupted.",
+		codes.Unauthenticated:    "Authentication is required or the token is invalid.",
+	},
+}
+
+// defaultLanguage 服务端错误信息使用的语言
+const defaultLanguage = "zh"
+
+// localize 按 Accept-Language 选择错误信息：
+// 优先使用服务端返回的 LocalizedMessage，其次是服务端的原始信息（中文），最后是按状态码的通用信息
+func localize(st *status.Status, acceptLanguage string) string {
+	if acceptLanguage == "" {
+		return st.Message()
+	}
+	var localized []*errdetails.LocalizedMessage
+	for _, d := range st.Details() {
+		if m, ok := d.(*errdetails.LocalizedMessage); ok {
+			localized = append(localized, m)
+		}
+	}
+	for _, tag := range parseQualityList(acceptLanguage) {
+		for _, m := range localized {
+			if matchLanguage(m.GetLocale(), tag) {
+				return m.GetMessage()
+			}
+		}
+		if matchLanguage(defaultLanguage, tag) {
+			return st.Message()
+		}
+		if generic, ok := messages[baseLanguage(tag)][st.Code()]; ok {
+			return generic
+		}
+	}
+	return st.Message()
+}
+
+// parseQualityList 解析 Accept、Accept-Language 等带 q 值的请求头，按 q 值从高到低返回，忽略 q=0 的值
+func parseQualityList(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// matchLanguage zh 匹配 zh-CN，* 匹配所有语言
+func matchLanguage(locale, tag string) bool {
+	if tag == "*" {
+		return true
+	}
+	return strings.EqualFold(baseLanguage(locale), baseLanguage(tag))
+}
+
+func baseLanguage(tag string) string {
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return strings.ToLower(tag)
+}
@@ -0,0 +1,157 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// localizedError 总是返回带英文 LocalizedMessage 的 NotFound
+func localizedError(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+	st, _ := status.New(codes.NotFound, "用户不存在").WithDetails(&errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: "user not found",
+	})
+	return nil, st.Err()
+}
+
+func TestErrorHandler(t *testing.T) {
+	auth := servertest.Start(t, servertest.WithAuth())
+	limited := servertest.Start(t, servertest.WithUnaryInterceptors(handler.ServerInterceptorRateLimit(config.RateLimit{
+		Enabled: true,
+		Rules:   []config.RateLimitRule{{Method: "*", KeyBy: handler.KeyByMethod, Rate: 0.1, Burst: 1}},
+	}, handler.NewMemoryLimiter())))
+	// 第一次调用用掉令牌桶中唯一的令牌
+	post(t, limited.HTTP.URL, nil)
+	localized := servertest.Start(t, servertest.WithUnaryInterceptors(localizedError))
+
+	tests := []struct {
+		name        string
+		url         string
+		header      map[string]string
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+		wantHeader  map[string]string
+	}{
+		{
+			name:        "unauthenticated",
+			url:         auth.HTTP.URL,
+			wantStatus:  http.StatusUnauthorized,
+			wantCode:    "UNAUTHENTICATED",
+			wantMessage: "token不存在",
+			wantHeader:  map[string]string{"WWW-Authenticate": "Bearer"},
+		},
+		{
+			name:        "unauthenticated in english",
+			url:         auth.HTTP.URL,
+			header:      map[string]string{"Accept-Language": "en-US,zh;q=0.5"},
+			wantStatus:  http.StatusUnauthorized,
+			wantCode:    "UNAUTHENTICATED",
+			wantMessage: "Authentication is required or the token is invalid.",
+		},
+		{
+			name:        "chinese preferred",
+			url:         auth.HTTP.URL,
+			header:      map[string]string{"Accept-Language": "en;q=0.3, zh-CN"},
+			wantStatus:  http.StatusUnauthorized,
+			wantCode:    "UNAUTHENTICATED",
+			wantMessage: "token不存在",
+		},
+		{
+			name:       "rate limited",
+			url:        limited.HTTP.URL,
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   "RESOURCE_EXHAUSTED",
+			wantHeader: map[string]string{"Retry-After": "10"},
+		},
+		{
+			name:        "localized message",
+			url:         localized.HTTP.URL,
+			header:      map[string]string{"Accept-Language": "en"},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    "NOT_FOUND",
+			wantMessage: "user not found",
+		},
+		{
+			name:        "request id",
+			url:         localized.HTTP.URL,
+			header:      map[string]string{gateway.RequestIDHeader: "abc"},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    "NOT_FOUND",
+			wantMessage: "用户不存在",
+			wantHeader:  map[string]string{gateway.RequestIDHeader: "abc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := post(t, tt.url, tt.header)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", body.Code, tt.wantCode)
+			}
+			if tt.wantMessage != "" && body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+			if body.RequestID == "" || body.RequestID != resp.Header.Get(gateway.RequestIDHeader) {
+				t.Errorf("requestId = %q, header = %q", body.RequestID, resp.Header.Get(gateway.RequestIDHeader))
+			}
+			for k, v := range tt.wantHeader {
+				if got := resp.Header.Get(k); got != v {
+					t.Errorf("header %s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorHandlerNotFoundRoute(t *testing.T) {
+	s := servertest.Start(t)
+	resp, err := http.Get(s.HTTP.URL + "/v1/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body gateway.ErrorBody
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || body.Code != "NOT_FOUND" {
+		t.Errorf("status = %d, body = %+v", resp.StatusCode, body)
+	}
+}
+
+func post(t *testing.T, url string, header map[string]string) (*http.Response, gateway.ErrorBody) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/greeter/sayMessage", strings.NewReader(`{"name":"a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body gateway.ErrorBody
+	if resp.StatusCode != http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, body
+}
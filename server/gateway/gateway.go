@@ -0,0 +1,51 @@
+// Package gateway 网关的 HTTP 处理器，main 和测试使用同一套路由
+package gateway
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc"
+	"net/http"
+)
+
+// NewHandler 创建网关的 HTTP 处理器，通过 conn 把请求转发给 gRPC 服务端
+func NewHandler(ctx context.Context, conn *grpc.ClientConn, cfg config.Gateway, auth config.Auth) (http.Handler, error) {
+	rules := NewHeaderRules(cfg.Headers)
+	jsonpb := newJSONPb(cfg.JSON)
+	gwmux := runtime.NewServeMux(append([]runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(rules.Incoming),
+		runtime.WithOutgoingHeaderMatcher(rules.Outgoing),
+		runtime.WithForwardResponseOption(rules.forwardTrailers),
+		runtime.WithErrorHandler(NewErrorHandler(rules)),
+		runtime.WithMetadata(clientIPMetadata),
+	}, marshalerOptions(jsonpb)...)...)
+	// Register Greeter
+	if err := hello.RegisterGatewayServiceHandler(ctx, gwmux, conn); err != nil {
+		return nil, err
+	}
+	if err := hello.RegisterHelloServiceHandler(ctx, gwmux, conn); err != nil {
+		return nil, err
+	}
+	if err := hello.RegisterEventServiceHandler(ctx, gwmux, conn); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	// 令牌接口，客户端通过它获取token，不需要知道签名密钥
+	mux.Handle("/v1/auth/token", handler.TokenHandler(auth))
+	mux.Handle("/openapi.json", openAPIHandler())
+	// grpc-gateway 不支持 HTTP/1.1 下的双向流，浏览器通过 WebSocket 调用 BidiHello
+	mux.Handle(WebSocketPath, newWebSocketBridge(hello.NewHelloServiceClient(conn), cfg.WebSocket, rules, compact(jsonpb)))
+	if cfg.SwaggerUI {
+		h, err := swaggerUIHandler(cfg.SwaggerUIDir)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle("/swagger/", h)
+	}
+	mux.Handle("/", negotiate(withStreamHeaders(gwmux)))
+	// Authorization 可以带 Bearer 前缀，转发给服务端之前去掉
+	return Chain(stripBearer(mux), Middlewares(cfg.Middleware)...), nil
+}
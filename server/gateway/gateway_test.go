@@ -0,0 +1,167 @@
+package gateway_test
+
+import (
+	"encoding/json"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/util"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSayMessage(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth())
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{name: "valid token", token: s.Token(), wantCode: http.StatusOK},
+		{name: "no token", wantCode: http.StatusUnauthorized},
+		{name: "malformed token", token: "not a jwt", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/greeter/sayMessage",
+				strings.NewReader(`{"name":"test","message":"收到请求"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var body struct {
+				Name    string `json:"name"`
+				Message string `json:"message"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Name != "test" || body.Message != "收到请求" {
+				t.Errorf("body = %+v", body)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	auth := config.Auth{
+		Clients:        []config.AuthClient{{ID: "demo", Secret: "demo-secret", UserID: 7, Username: "hello", Tenant: "team-a"}},
+		TrustedProxies: []string{"127.0.0.1", "::1"},
+	}
+	s := servertest.Start(t, servertest.WithAuthConfig(auth))
+	// 没有可信代理时 X-Forwarded-Proto 不可信
+	auth.TrustedProxies = nil
+	untrusted := servertest.Start(t, servertest.WithAuthConfig(auth))
+
+	tests := []struct {
+		name   string
+		method string
+		grant  string
+		id     string
+		secret string
+		// plaintext 不带 X-Forwarded-Proto: https
+		plaintext bool
+		// untrusted 从不可信的地址发送 X-Forwarded-Proto: https
+		untrusted bool
+		wantCode  int
+	}{
+		{name: "valid", method: http.MethodPost, grant: "client_credentials", id: "demo", secret: "demo-secret", wantCode: http.StatusOK},
+		{name: "plaintext", method: http.MethodPost, grant: "client_credentials", id: "demo", secret: "demo-secret", plaintext: true, wantCode: http.StatusBadRequest},
+		{name: "spoofed proto", method: http.MethodPost, grant: "client_credentials", id: "demo", secret: "demo-secret", untrusted: true, wantCode: http.StatusBadRequest},
+		{name: "wrong secret", method: http.MethodPost, grant: "client_credentials", id: "demo", secret: "bad", wantCode: http.StatusUnauthorized},
+		{name: "unknown client", method: http.MethodPost, grant: "client_credentials", id: "nobody", secret: "demo-secret", wantCode: http.StatusUnauthorized},
+		{name: "unsupported grant", method: http.MethodPost, grant: "password", id: "demo", secret: "demo-secret", wantCode: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodGet, wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {tt.grant}}
+			base := s.HTTP.URL
+			if tt.untrusted {
+				base = untrusted.HTTP.URL
+			}
+			req, err := http.NewRequest(tt.method, base+"/v1/auth/token", strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if !tt.plaintext {
+				// 测试网关是明文 HTTP，模拟终止 TLS 的代理
+				req.Header.Set("X-Forwarded-Proto", "https")
+			}
+			req.SetBasicAuth(tt.id, tt.secret)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var body struct {
+				AccessToken string `json:"access_token"`
+				TokenType   string `json:"token_type"`
+				ExpiresIn   int64  `json:"expires_in"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			claims, err := util.NewJWT([]byte(servertest.SigningKey)).ParseToken(body.AccessToken)
+			if err != nil {
+				t.Fatalf("ParseToken: %v", err)
+			}
+			if claims.BaseClaims.ID != 7 || claims.Username != "hello" || claims.Tenant != "team-a" || body.TokenType != "Bearer" || body.ExpiresIn <= 0 {
+				t.Errorf("token = %+v, claims = %+v", body, claims.BaseClaims)
+			}
+		})
+	}
+}
+
+func TestSayMessageInvalid(t *testing.T) {
+	s := servertest.Start(t, servertest.WithUnaryInterceptors(handler.ServerInterceptorValidate()))
+
+	resp, err := http.Post(s.HTTP.URL+"/v1/greeter/sayMessage", "application/json",
+		strings.NewReader(`{"message":"没有名字"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	var body struct {
+		Code    string `json:"code"`
+		Details []struct {
+			Type            string `json:"@type"`
+			FieldViolations []struct {
+				Field string `json:"field"`
+			} `json:"fieldViolations"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "INVALID_ARGUMENT" || len(body.Details) != 1 || body.Details[0].Type != "type.googleapis.com/google.rpc.BadRequest" ||
+		len(body.Details[0].FieldViolations) != 1 || body.Details[0].FieldViolations[0].Field != "name" {
+		t.Errorf("body = %+v", body)
+	}
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"net/http"
+	"strings"
+)
+
+// WithGRPCWeb 在网关端口上提供 gRPC-Web，请求直接交给 grpcServer 处理，和 gRPC 端口经过相同的拦截器，
+// 并经过网关的 HTTP 中间件，见 grpcWebMiddlewares。没有开启时直接返回 next
+func WithGRPCWeb(grpcServer *grpc.Server, cfg config.Gateway, next http.Handler) http.Handler {
+	if !cfg.GRPCWeb.Enabled {
+		return next
+	}
+	opts := []grpcweb.Option{
+		grpcweb.WithOriginFunc(originFunc(cfg.GRPCWeb.Origins)),
+	}
+	if len(cfg.GRPCWeb.AllowedHeaders) > 0 {
+		opts = append(opts, grpcweb.WithAllowedRequestHeaders(cfg.GRPCWeb.AllowedHeaders))
+	}
+	wrapped := grpcweb.WrapServer(grpcServer, opts...)
+	grpcWeb := Chain(wrapped, grpcWebMiddlewares(cfg.Middleware)...)
+	// gRPC-Web 请求（包括跨域预检）交给 grpcWeb 处理，其他请求交给 next
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wrapped.IsGrpcWebRequest(r) || wrapped.IsAcceptableGrpcCorsRequest(r) {
+			grpcWeb.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcWebMiddlewares gRPC-Web 使用的中间件：跨域由 GRPCWeb.Origins 处理，超时由 gRPC 服务端的截止时间处理
+func grpcWebMiddlewares(cfg config.Middleware) []Middleware {
+	cfg.CORS = config.CORS{}
+	cfg.Timeout = 0
+	return Middlewares(cfg)
+}
+
+// originFunc 跨域请求允许的 Origin，* 允许所有来源
+func originFunc(origins []string) func(string) bool {
+	return func(origin string) bool {
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,234 @@
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// grpcWebResponse 解析后的 gRPC-Web 响应
+type grpcWebResponse struct {
+	messages []*hello.HelloResponse
+	status   string
+}
+
+// grpcWebFrame 一个 gRPC-Web 帧：1 字节标志 + 4 字节大端长度 + 数据
+func grpcWebFrame(flag byte, data []byte) []byte {
+	frame := make([]byte, 5, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+// decodeGRPCWebText grpc-web-text 的响应可能由多段带填充的 base64 组成
+func decodeGRPCWebText(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var out []byte
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '=')
+		if n < 0 {
+			n = len(data)
+		} else {
+			for n < len(data) && data[n] == '=' {
+				n++
+			}
+		}
+		chunk, err := base64.StdEncoding.DecodeString(string(data[:n]))
+		if err != nil {
+			t.Fatalf("base64: %v", err)
+		}
+		out = append(out, chunk...)
+		data = data[n:]
+	}
+	return out
+}
+
+func callGRPCWeb(t *testing.T, s *servertest.Server, method string, req proto.Message, text bool, header http.Header) grpcWebResponse {
+	t.Helper()
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := grpcWebFrame(0, data)
+	contentType := "application/grpc-web+proto"
+	if text {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+		contentType = "application/grpc-web-text"
+	}
+	r, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+method, bytes.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("X-Grpc-Web", "1")
+	resp := do(t, r, http.StatusOK, contentType)
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text {
+		raw = decodeGRPCWebText(t, raw)
+	}
+
+	// 只有 trailer 的响应把 grpc-status 放在响应头中
+	got := grpcWebResponse{status: resp.Header.Get("Grpc-Status")}
+	for len(raw) > 0 {
+		if len(raw) < 5 {
+			t.Fatalf("short frame %q", raw)
+		}
+		flag, n := raw[0], binary.BigEndian.Uint32(raw[1:5])
+		payload := raw[5 : 5+n]
+		raw = raw[5+n:]
+		if flag&0x80 != 0 {
+			// trailer 帧的内容为 HTTP/1 格式的头部
+			for _, line := range strings.Split(string(payload), "\r\n") {
+				if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(k, "grpc-status") {
+					got.status = strings.TrimSpace(v)
+				}
+			}
+			continue
+		}
+		msg := &hello.HelloResponse{}
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			t.Fatal(err)
+		}
+		got.messages = append(got.messages, msg)
+	}
+	return got
+}
+
+func TestGRPCWeb(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth(), servertest.WithGatewayConfig(config.Gateway{
+		GRPCWeb: config.GRPCWeb{Enabled: true},
+	}))
+	token := http.Header{"Token": {s.Token()}}
+	tests := []struct {
+		name       string
+		method     string
+		text       bool
+		header     http.Header
+		wantNames  []string
+		wantStatus string
+	}{
+		{name: "unary", method: "/hello.v1.HelloService/SayHello", header: token, wantNames: []string{"a"}, wantStatus: "0"},
+		{name: "text", method: "/hello.v1.HelloService/SayHello", text: true, header: token, wantNames: []string{"a"}, wantStatus: "0"},
+		{
+			name:       "server streaming",
+			method:     "/hello.v1.HelloService/LotsOfReplies",
+			header:     token,
+			wantNames:  []string{"a你好", "ahello", "aこんにちは", "a안녕하세요"},
+			wantStatus: "0",
+		},
+		{name: "no token", method: "/hello.v1.HelloService/SayHello", wantStatus: "16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := callGRPCWeb(t, s, tt.method, &hello.HelloRequest{Name: "a"}, tt.text, tt.header)
+			if got.status != tt.wantStatus {
+				t.Errorf("grpc-status = %q, want %q", got.status, tt.wantStatus)
+			}
+			var names []string
+			for _, m := range got.messages {
+				names = append(names, m.GetName())
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("responses = %q, want %q", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		wantOK  bool
+	}{
+		{name: "allowed", origins: []string{"http://app.example"}, origin: "http://app.example", wantOK: true},
+		{name: "wildcard", origins: []string{"*"}, origin: "http://evil.example", wantOK: true},
+		{name: "not allowed", origins: []string{"http://app.example"}, origin: "http://evil.example"},
+		{name: "default", origin: "http://evil.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{
+				GRPCWeb: config.GRPCWeb{Enabled: true, Origins: tt.origins},
+			}))
+			req, _ := http.NewRequest(http.MethodOptions, s.HTTP.URL+"/hello.v1.HelloService/SayHello", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "x-grpc-web,content-type,token")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			got := resp.Header.Get("Access-Control-Allow-Origin")
+			if ok := got == tt.origin; ok != tt.wantOK {
+				t.Errorf("Access-Control-Allow-Origin = %q, want allowed %v", got, tt.wantOK)
+			}
+		})
+	}
+}
+
+// 没有开启时 gRPC-Web 请求交给网关，按路由不存在返回 404
+func TestGRPCWebDisabled(t *testing.T) {
+	s := servertest.Start(t)
+	data, _ := proto.Marshal(&hello.HelloRequest{Name: "a"})
+	req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/hello.v1.HelloService/SayHello", bytes.NewReader(grpcWebFrame(0, data)))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	do(t, req, http.StatusNotFound, "application/json; charset=utf-8")
+}
+
+// gRPC-Web 请求经过网关的中间件：请求ID转发给 gRPC 服务端，设置安全头，限制请求体大小
+func TestGRPCWebMiddleware(t *testing.T) {
+	ids := make(chan string, 1)
+	record := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ids <- strings.Join(md.Get(gateway.RequestIDHeader), ",")
+		return handler(ctx, req)
+	}
+	s := servertest.Start(t, servertest.WithUnaryInterceptors(record), servertest.WithGatewayConfig(config.Gateway{
+		GRPCWeb: config.GRPCWeb{Enabled: true},
+		Middleware: config.Middleware{
+			Security:    config.SecurityHeaders{NoSniff: true},
+			MaxBodySize: 64,
+		},
+	}))
+
+	data, _ := proto.Marshal(&hello.HelloRequest{Name: "a"})
+	req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/hello.v1.HelloService/SayHello", bytes.NewReader(grpcWebFrame(0, data)))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	resp := do(t, req, http.StatusOK, "application/grpc-web+proto")
+	id := resp.Header.Get(gateway.RequestIDHeader)
+	if id == "" {
+		t.Error("no request id in response")
+	}
+	if got := <-ids; got != id {
+		t.Errorf("request id in metadata = %q, want %q", got, id)
+	}
+	if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q", got)
+	}
+
+	data, _ = proto.Marshal(&hello.HelloRequest{Name: strings.Repeat("a", 100)})
+	req, _ = http.NewRequest(http.MethodPost, s.HTTP.URL+"/hello.v1.HelloService/SayHello", bytes.NewReader(grpcWebFrame(0, data)))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	do(t, req, http.StatusRequestEntityTooLarge, "application/json; charset=utf-8")
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderRules HTTP 头与 gRPC 元数据之间的转换规则，见 config.Headers。
+// 没有配置的请求头不会转发，服务端返回的元数据也不会出现在响应中，除非开启 GrpcMetadata
+type HeaderRules struct {
+	// incoming 规范化的请求头名称 -> 元数据名称
+	incoming         map[string]string
+	incomingMetadata bool
+	// outgoing、trailers 元数据名称（小写） -> 响应头名称
+	outgoing         map[string]string
+	trailers         map[string]string
+	outgoingMetadata bool
+}
+
+// NewHeaderRules 按配置创建转换规则
+func NewHeaderRules(cfg config.Headers) *HeaderRules {
+	h := &HeaderRules{
+		incoming:         make(map[string]string, len(cfg.Incoming.Allow)),
+		incomingMetadata: cfg.Incoming.GrpcMetadata,
+		outgoing:         make(map[string]string, len(cfg.Outgoing.Headers)),
+		trailers:         make(map[string]string, len(cfg.Outgoing.Trailers)),
+		outgoingMetadata: cfg.Outgoing.GrpcMetadata,
+	}
+	for header, name := range cfg.Incoming.Allow {
+		if name == "" {
+			name = header
+		}
+		h.incoming[textproto.CanonicalMIMEHeaderKey(header)] = strings.ToLower(name)
+	}
+	for name, header := range cfg.Outgoing.Headers {
+		h.outgoing[strings.ToLower(name)] = header
+	}
+	for name, header := range cfg.Outgoing.Trailers {
+		h.trailers[strings.ToLower(name)] = header
+	}
+	return h
+}
+
+// Incoming 用于 runtime.WithIncomingHeaderMatcher，先按 handler.CustomHeaderMatcher，再按配置的允许列表。
+// 客户端IP只由网关设置，任何请求头都不会转发为 x-client-ip
+func (h *HeaderRules) Incoming(key string) (string, bool) {
+	name, ok := h.incomingName(key)
+	if !ok || strings.EqualFold(name, handler.MetadataClientIP) {
+		return "", false
+	}
+	return name, true
+}
+
+func (h *HeaderRules) incomingName(key string) (string, bool) {
+	if name, ok := handler.CustomHeaderMatcher(key); ok {
+		return name, true
+	}
+	if name, ok := h.incoming[textproto.CanonicalMIMEHeaderKey(key)]; ok {
+		return name, true
+	}
+	if h.incomingMetadata {
+		return runtime.DefaultHeaderMatcher(key)
+	}
+	return "", false
+}
+
+// Outgoing 用于 runtime.WithOutgoingHeaderMatcher，把服务端的 header 元数据转换为响应头
+func (h *HeaderRules) Outgoing(key string) (string, bool) {
+	if header, ok := h.outgoing[key]; ok {
+		return header, true
+	}
+	if h.outgoingMetadata {
+		return runtime.MetadataHeaderPrefix + key, true
+	}
+	return "", false
+}
+
+// OutgoingTrailer 把服务端的 trailer 元数据转换为响应头
+func (h *HeaderRules) OutgoingTrailer(key string) (string, bool) {
+	if header, ok := h.trailers[key]; ok {
+		return header, true
+	}
+	if h.outgoingMetadata {
+		return runtime.MetadataTrailerPrefix + key, true
+	}
+	return "", false
+}
+
+// Metadata 按规则从请求头生成元数据，用于不经过 grpc-gateway 的接口（WebSocket）
+func (h *HeaderRules) Metadata(r *http.Request) metadata.MD {
+	md := clientIPMetadata(r.Context(), r)
+	for key, vals := range r.Header {
+		if name, ok := h.Incoming(key); ok {
+			md.Append(name, vals...)
+		}
+	}
+	return md
+}
+
+// clientIPMetadata 用于 runtime.WithMetadata，把连接网关的客户端IP放入 x-client-ip，
+// 服务端只在调用来自可信代理时按它限流。网关前面还有反向代理时，这里是反向代理的地址
+func clientIPMetadata(_ context.Context, r *http.Request) metadata.MD {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return metadata.Pairs(handler.MetadataClientIP, ip)
+}
+
+// forwardTrailers 用于 runtime.WithForwardResponseOption。
+// grpc-gateway 的 trailer 总是以 Grpc-Trailer-* 声明，没有 matcher，这里在写响应之前去掉声明，
+// 未声明的 trailer 不会被 net/http 发送；配置了的 trailer 元数据作为普通响应头返回
+func (h *HeaderRules) forwardTrailers(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	if !h.outgoingMetadata {
+		w.Header().Del("Trailer")
+	}
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	for k, vs := range md.TrailerMD {
+		if header, ok := h.trailers[k]; ok {
+			for _, v := range vs {
+				w.Header().Add(header, v)
+			}
+		}
+	}
+	return nil
+}
+
+// writeServerMetadata 错误响应中按规则返回服务端的 header 和 trailer 元数据
+func (h *HeaderRules) writeServerMetadata(ctx context.Context, w http.ResponseWriter) {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return
+	}
+	for k, vs := range md.HeaderMD {
+		if header, ok := h.Outgoing(k); ok && !strings.HasPrefix(k, ":") {
+			for _, v := range vs {
+				w.Header().Add(header, v)
+			}
+		}
+	}
+	for k, vs := range md.TrailerMD {
+		if header, ok := h.OutgoingTrailer(k); ok {
+			for _, v := range vs {
+				w.Header().Add(header, v)
+			}
+		}
+	}
+}
+
+// stripBearer 去掉 Authorization 的 Bearer 前缀，checkToken 只接受 JWT 本身，
+// 不带前缀的 token 保持不变，Basic 等其他方案也不受影响
+func stripBearer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			r.Header.Set("Authorization", strings.TrimSpace(auth[len("Bearer "):]))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
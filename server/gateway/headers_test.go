@@ -0,0 +1,196 @@
+package gateway_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIncomingHeaders(t *testing.T) {
+	var got metadata.MD
+	capture := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		got, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}
+	incoming := config.IncomingHeaders{Allow: map[string]string{"X-Tenant": "tenant", "client-os": ""}}
+	tests := []struct {
+		name    string
+		cfg     config.IncomingHeaders
+		header  map[string]string
+		want    map[string]string
+		wantNot []string
+	}{
+		{
+			name:    "allowlist",
+			cfg:     incoming,
+			header:  map[string]string{"X-Tenant": "acme", "Client-Os": "ios", "X-Other": "x", "Grpc-Metadata-Uid": "1"},
+			want:    map[string]string{"tenant": "acme", "client-os": "ios"},
+			wantNot: []string{"x-tenant", "x-other", "uid"},
+		},
+		{
+			name:   "grpc metadata opt-in",
+			cfg:    config.IncomingHeaders{GrpcMetadata: true},
+			header: map[string]string{"Grpc-Metadata-Uid": "1"},
+			want:   map[string]string{"uid": "1"},
+		},
+		{
+			name:   "bearer prefix",
+			header: map[string]string{"Authorization": "Bearer abc.def"},
+			want:   map[string]string{"token": "abc.def"},
+		},
+		{
+			name:   "lower case bearer",
+			header: map[string]string{"Authorization": "bearer abc.def"},
+			want:   map[string]string{"token": "abc.def"},
+		},
+		{
+			// 客户端IP只由网关设置，即使配置了也不能通过请求头伪造
+			name:   "client ip",
+			cfg:    config.IncomingHeaders{Allow: map[string]string{"X-Client-Ip": ""}, GrpcMetadata: true},
+			header: map[string]string{"X-Client-Ip": "1.2.3.4", "Grpc-Metadata-X-Client-Ip": "5.6.7.8"},
+			want:   map[string]string{"x-client-ip": "127.0.0.1"},
+		},
+		{
+			name:   "raw token",
+			header: map[string]string{"Authorization": "abc.def"},
+			want:   map[string]string{"token": "abc.def"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 服务端只在信任元数据时接受 tenant
+			s := servertest.Start(t, servertest.WithUnaryInterceptors(capture),
+				servertest.WithTenantsConfig(config.Tenants{TrustMetadata: true}),
+				servertest.WithGatewayConfig(config.Gateway{Headers: config.Headers{Incoming: tt.cfg}}))
+			req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/sayHello", strings.NewReader(`{"name":"a"}`))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			do(t, req, http.StatusOK, "application/json")
+			for k, want := range tt.want {
+				if v := got.Get(k); len(v) != 1 || v[0] != want {
+					t.Errorf("metadata %s = %q, want %q", k, v, want)
+				}
+			}
+			for _, k := range tt.wantNot {
+				if v := got.Get(k); len(v) != 0 {
+					t.Errorf("metadata %s = %q, want none", k, v)
+				}
+			}
+		})
+	}
+}
+
+// 带 Bearer 前缀的 token 可以通过服务端的认证
+func TestBearerToken(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth())
+	req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/sayHello", strings.NewReader(`{"name":"a"}`))
+	req.Header.Set("Authorization", "Bearer "+s.Token())
+	do(t, req, http.StatusOK, "application/json")
+}
+
+func TestOutgoingHeaders(t *testing.T) {
+	// 服务端设置 header 和 trailer 元数据，name 为 fail 时返回错误
+	setMetadata := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		grpc.SetHeader(ctx, metadata.Pairs("x-ratelimit-remaining", "5", "internal-node", "node-1"))
+		grpc.SetTrailer(ctx, metadata.Pairs("x-cost", "3", "internal-trace", "t1"))
+		if r, ok := req.(interface{ GetName() string }); ok && r.GetName() == "fail" {
+			return nil, status.Error(codes.FailedPrecondition, "fail")
+		}
+		return handler(ctx, req)
+	}
+	outgoing := config.OutgoingHeaders{
+		Headers:  map[string]string{"x-ratelimit-remaining": "X-RateLimit-Remaining"},
+		Trailers: map[string]string{"x-cost": "X-Cost"},
+	}
+	withMetadata := outgoing
+	withMetadata.GrpcMetadata = true
+	tests := []struct {
+		name       string
+		cfg        config.OutgoingHeaders
+		body       string
+		wantStatus int
+		want       map[string]string
+		wantNot    []string
+	}{
+		{
+			name:       "default",
+			body:       `{"name":"a"}`,
+			wantStatus: http.StatusOK,
+			wantNot:    []string{"Grpc-Metadata-X-Ratelimit-Remaining", "Grpc-Metadata-Internal-Node", "X-Cost", "Grpc-Trailer-X-Cost"},
+		},
+		{
+			name:       "mapped",
+			cfg:        outgoing,
+			body:       `{"name":"a"}`,
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"X-RateLimit-Remaining": "5", "X-Cost": "3"},
+			wantNot:    []string{"Grpc-Metadata-Internal-Node", "Grpc-Trailer-Internal-Trace"},
+		},
+		{
+			name:       "mapped error",
+			cfg:        outgoing,
+			body:       `{"name":"fail"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]string{"X-RateLimit-Remaining": "5", "X-Cost": "3"},
+			wantNot:    []string{"Grpc-Metadata-Internal-Node", "Grpc-Trailer-Internal-Trace"},
+		},
+		{
+			name:       "grpc metadata opt-in",
+			cfg:        withMetadata,
+			body:       `{"name":"a"}`,
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"X-RateLimit-Remaining": "5", "Grpc-Metadata-Internal-Node": "node-1", "Grpc-Trailer-Internal-Trace": "t1"},
+		},
+		{
+			name:       "grpc metadata opt-in error",
+			cfg:        withMetadata,
+			body:       `{"name":"fail"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]string{"Grpc-Metadata-Internal-Node": "node-1", "X-Cost": "3", "Grpc-Trailer-Internal-Trace": "t1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithUnaryInterceptors(setMetadata),
+				servertest.WithGatewayConfig(config.Gateway{Headers: config.Headers{Outgoing: tt.cfg}}))
+			req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/sayHello", strings.NewReader(tt.body))
+			// 客户端接受 trailer 时 grpc-gateway 以 Grpc-Trailer-* 返回 trailer 元数据
+			req.Header.Set("TE", "trailers")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			// trailer 读完响应体之后才能拿到
+			get := func(k string) string {
+				if v := resp.Header.Get(k); v != "" {
+					return v
+				}
+				return resp.Trailer.Get(k)
+			}
+			for k, want := range tt.want {
+				if got := get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+			for _, k := range tt.wantNot {
+				if got := get(k); got != "" {
+					t.Errorf("%s = %q, want none", k, got)
+				}
+			}
+		})
+	}
+}
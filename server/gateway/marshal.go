@@ -0,0 +1,253 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/keepon-online/go-grpc-example/config"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+)
+
+const (
+	// MIMEJSON 默认的请求和响应格式
+	MIMEJSON = "application/json"
+	// MIMEProtobuf 二进制 protobuf，只支持非流式响应
+	MIMEProtobuf = "application/x-protobuf"
+	// MIMEYAML YAML，由 JSON 转换，字段名等选项与 JSON 相同
+	MIMEYAML = "application/yaml"
+)
+
+// newJSONPb 按配置创建 JSON 编码器
+func newJSONPb(cfg config.JSON) *runtime.JSONPb {
+	return &runtime.JSONPb{
+		MarshalOptions: protojson.MarshalOptions{
+			UseProtoNames:   cfg.UseProtoNames,
+			EmitUnpopulated: cfg.EmitUnpopulated,
+			UseEnumNumbers:  cfg.UseEnumNumbers,
+			Indent:          cfg.Indent,
+		},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: !cfg.RejectUnknownFields},
+	}
+}
+
+// compact 去掉缩进，每条消息一行的格式（NDJSON、SSE、WebSocket）使用
+func compact(jsonpb *runtime.JSONPb) *runtime.JSONPb {
+	c := *jsonpb
+	c.Indent = ""
+	return &c
+}
+
+// marshalerOptions 网关支持的所有格式，请求按 Content-Type、响应按 Accept 选择，
+// 都没有匹配时使用 JSON
+func marshalerOptions(jsonpb *runtime.JSONPb) []runtime.ServeMuxOption {
+	jsonMarshaler := &runtime.HTTPBodyMarshaler{Marshaler: jsonpb}
+	pb := &protoMarshaler{}
+	yml := &yamlMarshaler{json: jsonpb}
+	return []runtime.ServeMuxOption{
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, jsonMarshaler),
+		runtime.WithMarshalerOption(MIMEJSON, jsonMarshaler),
+		runtime.WithMarshalerOption(MIMENDJSON, newStreamMarshaler(compact(jsonpb), false)),
+		runtime.WithMarshalerOption(MIMEEventStream, newStreamMarshaler(compact(jsonpb), true)),
+		runtime.WithMarshalerOption(MIMEProtobuf, pb),
+		runtime.WithMarshalerOption("application/protobuf", pb),
+		runtime.WithMarshalerOption(MIMEYAML, yml),
+		runtime.WithMarshalerOption("application/x-yaml", yml),
+	}
+}
+
+// contentTypes 可以在 Accept 中选择的格式，q 值相同时靠前的优先
+var contentTypes = []string{
+	MIMEJSON, MIMENDJSON, MIMEEventStream,
+	MIMEProtobuf, "application/protobuf",
+	MIMEYAML, "application/x-yaml",
+}
+
+// negotiate grpc-gateway 只能匹配完整的 Accept 值，这里按 q 值选出支持的格式后替换 Accept；
+// 没有支持的格式（例如 */*）时删除 Accept，响应使用与请求相同的格式
+func negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accepts := r.Header.Values("Accept"); len(accepts) > 0 {
+			if mime, ok := selectContentType(accepts); ok {
+				r.Header.Set("Accept", mime)
+			} else {
+				r.Header.Del("Accept")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func selectContentType(accepts []string) (string, bool) {
+	for _, accept := range accepts {
+		for _, mime := range parseQualityList(accept) {
+			for _, ct := range contentTypes {
+				if ct == mime {
+					return ct, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// protoMarshaler 二进制 protobuf。流式响应没有消息的分隔方式，返回 Unimplemented；
+// 请求体只能包含一个消息，客户端流只会收到一条
+type protoMarshaler struct {
+	runtime.ProtoMarshaller
+}
+
+func (*protoMarshaler) ContentType(_ interface{}) string {
+	return MIMEProtobuf
+}
+
+func (m *protoMarshaler) Marshal(v interface{}) ([]byte, error) {
+	switch chunk := v.(type) {
+	case map[string]interface{}:
+		// 流中的消息 {"result": ...}
+		return nil, status.Error(codes.Unimplemented, "流式接口不支持 "+MIMEProtobuf+"，请使用 "+MIMENDJSON)
+	case map[string]proto.Message:
+		// 流中的错误 {"error": ...}
+		if s, ok := chunk["error"].(*spb.Status); ok {
+			st := status.FromProto(s)
+			return proto.Marshal(errorResponse(st, st.Message(), ""))
+		}
+	}
+	return m.ProtoMarshaller.Marshal(v)
+}
+
+// Delimiter 流中只会写一个错误，不需要分隔符
+func (*protoMarshaler) Delimiter() []byte {
+	return nil
+}
+
+// NewDecoder 第一次读取整个请求体，之后返回 io.EOF。
+// runtime.ProtoMarshaller 的 Decoder 每次都读到空请求体并成功解析，客户端流会一直收到空消息
+func (m *protoMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	done := false
+	return runtime.DecoderFunc(func(v interface{}) error {
+		if done {
+			return io.EOF
+		}
+		done = true
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return m.Unmarshal(data, v)
+	})
+}
+
+// yamlMarshaler YAML，先按 JSONPb 编码为 JSON 再转换为 YAML，解析时反过来。
+// 流中的每条消息是一个以 --- 开始的 YAML 文档
+type yamlMarshaler struct {
+	json *runtime.JSONPb
+}
+
+func (*yamlMarshaler) ContentType(_ interface{}) string {
+	return MIMEYAML
+}
+
+func (m *yamlMarshaler) Marshal(v interface{}) ([]byte, error) {
+	stream := false
+	switch chunk := v.(type) {
+	case map[string]interface{}:
+		stream = true
+	case map[string]proto.Message:
+		stream = true
+		// 流中的错误与 ErrorHandler 使用相同的结构
+		if s, ok := chunk["error"].(*spb.Status); ok {
+			st := status.FromProto(s)
+			v = map[string]proto.Message{"error": errorResponse(st, st.Message(), "")}
+		}
+	}
+	data, err := m.json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = jsonToYAML(data); err != nil {
+		return nil, err
+	}
+	if stream {
+		// 分隔符写在消息之后，最后会多出一个空文档，所以在每条消息之前写
+		data = append([]byte("---\n"), data...)
+	}
+	return data, nil
+}
+
+func (m *yamlMarshaler) Unmarshal(data []byte, v interface{}) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return m.unmarshalDocument(doc, v)
+}
+
+func (m *yamlMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	dec := yaml.NewDecoder(r)
+	return runtime.DecoderFunc(func(v interface{}) error {
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			// 没有更多文档时为 io.EOF
+			return err
+		}
+		return m.unmarshalDocument(doc, v)
+	})
+}
+
+func (m *yamlMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		data, err := m.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// Delimiter 文档的分隔符由 Marshal 写入
+func (*yamlMarshaler) Delimiter() []byte {
+	return nil
+}
+
+// unmarshalDocument 解析后的 YAML 文档转换为 JSON，再按 JSONPb 解析
+func (m *yamlMarshaler) unmarshalDocument(doc interface{}, v interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return m.json.Unmarshal(data, v)
+}
+
+// jsonToYAML JSON 也是合法的 YAML，解析为节点后去掉引号和花括号等样式，保留字段顺序
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	resetStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
@@ -0,0 +1,195 @@
+package gateway_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.JSON
+		body       string
+		wantStatus int
+		want       string
+		wantIndent bool
+	}{
+		{name: "default", body: `{"name":"a"}`, wantStatus: http.StatusOK, want: `{"name":"a"}`},
+		{name: "emit unpopulated", cfg: config.JSON{EmitUnpopulated: true}, body: `{"name":"a"}`, wantStatus: http.StatusOK, want: `{"name":"a","message":""}`},
+		{name: "indent", cfg: config.JSON{Indent: "  "}, body: `{"name":"a"}`, wantStatus: http.StatusOK, want: `{"name":"a"}`, wantIndent: true},
+		{name: "discard unknown", body: `{"name":"a","bogus":1}`, wantStatus: http.StatusOK, want: `{"name":"a"}`},
+		{name: "reject unknown", cfg: config.JSON{RejectUnknownFields: true}, body: `{"name":"a","bogus":1}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{JSON: tt.cfg}))
+			req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/sayHello", strings.NewReader(tt.body))
+			if tt.want == "" {
+				do(t, req, tt.wantStatus, "application/json; charset=utf-8")
+				return
+			}
+			data, _ := io.ReadAll(do(t, req, tt.wantStatus, "application/json").Body)
+			// protojson 会随机加入空格，压缩后再比较
+			var got bytes.Buffer
+			if err := json.Compact(&got, data); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("body = %s, want %s", data, tt.want)
+			}
+			if indented := bytes.Contains(data, []byte("\n  \"name\"")); indented != tt.wantIndent {
+				t.Errorf("body = %q, want indent %v", data, tt.wantIndent)
+			}
+		})
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	s := servertest.Start(t, servertest.WithUnaryInterceptors(handler.ServerInterceptorValidate()))
+	post := func(t *testing.T, path string, req *hello.HelloRequest, accept string, wantStatus int) []byte {
+		t.Helper()
+		data, _ := proto.Marshal(req)
+		r, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+path, bytes.NewReader(data))
+		r.Header.Set("Content-Type", gateway.MIMEProtobuf)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		resp := do(t, r, wantStatus, gateway.MIMEProtobuf)
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		var got hello.HelloResponse
+		if err := proto.Unmarshal(post(t, "/v1/hello/sayHello", &hello.HelloRequest{Name: "a", Message: "hi"}, "", http.StatusOK), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.GetName() != "a" || got.GetMessage() != "hi" {
+			t.Errorf("SayHello = %v", &got)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var got hello.ErrorResponse
+		if err := proto.Unmarshal(post(t, "/v1/hello/sayHello", &hello.HelloRequest{}, "*/*", http.StatusBadRequest), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.GetCode() != "INVALID_ARGUMENT" || got.GetRequestId() == "" || len(got.GetDetails()) == 0 {
+			t.Errorf("ErrorResponse = %v", &got)
+		}
+	})
+
+	t.Run("streaming not supported", func(t *testing.T) {
+		var got hello.ErrorResponse
+		if err := proto.Unmarshal(post(t, "/v1/hello/lotsOfReplies", &hello.HelloRequest{Name: "a"}, "", http.StatusNotImplemented), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.GetCode() != "UNIMPLEMENTED" {
+			t.Errorf("ErrorResponse = %v", &got)
+		}
+	})
+}
+
+func TestContentNegotiation(t *testing.T) {
+	s := servertest.Start(t)
+	tests := []struct {
+		name     string
+		accept   string
+		wantType string
+	}{
+		{name: "no accept", wantType: "application/json"},
+		{name: "wildcard", accept: "*/*", wantType: "application/json"},
+		{name: "protobuf", accept: "application/x-protobuf", wantType: gateway.MIMEProtobuf},
+		{name: "protobuf alias", accept: "application/protobuf", wantType: gateway.MIMEProtobuf},
+		{name: "quality", accept: "application/json;q=0.5, application/x-protobuf;q=0.9", wantType: gateway.MIMEProtobuf},
+		{name: "unsupported first", accept: "text/html, application/yaml", wantType: gateway.MIMEYAML},
+		{name: "yaml alias", accept: "application/x-yaml", wantType: gateway.MIMEYAML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/sayHello", strings.NewReader(`{"name":"a"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			do(t, req, http.StatusOK, tt.wantType)
+		})
+	}
+}
+
+func TestYAML(t *testing.T) {
+	s := servertest.Start(t,
+		servertest.WithUnaryInterceptors(handler.ServerInterceptorValidate()),
+		servertest.WithGatewayConfig(config.Gateway{JSON: config.JSON{UseProtoNames: true}}))
+	post := func(t *testing.T, path, body string, wantStatus int) *yaml.Decoder {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", gateway.MIMEYAML)
+		return yaml.NewDecoder(do(t, req, wantStatus, gateway.MIMEYAML).Body)
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		var got map[string]string
+		if err := post(t, "/v1/hello/sayHello", "name: a\nmessage: hi\n", http.StatusOK).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got["name"] != "a" || got["message"] != "hi" {
+			t.Errorf("SayHello = %v", got)
+		}
+	})
+
+	t.Run("error uses proto names", func(t *testing.T) {
+		var got map[string]interface{}
+		if err := post(t, "/v1/hello/sayHello", "message: hi\n", http.StatusBadRequest).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got["code"] != "INVALID_ARGUMENT" || got["request_id"] == nil {
+			t.Errorf("error = %v", got)
+		}
+	})
+
+	t.Run("server streaming", func(t *testing.T) {
+		dec := post(t, "/v1/hello/lotsOfReplies", "name: a\n", http.StatusOK)
+		var names []string
+		for {
+			var doc struct {
+				Result struct {
+					Name string `yaml:"name"`
+				} `yaml:"result"`
+			}
+			if err := dec.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, doc.Result.Name)
+		}
+		if strings.Join(names, ",") != "a你好,ahello,aこんにちは,a안녕하세요" {
+			t.Errorf("replies = %q", names)
+		}
+	})
+
+	t.Run("client streaming", func(t *testing.T) {
+		var got map[string]string
+		if err := post(t, "/v1/hello/lotsOfGreetings", "name: a\n---\nname: b\n", http.StatusOK).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got["name"] != "你好：ab" {
+			t.Errorf("LotsOfGreetings = %v", got)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Middleware HTTP 中间件
+type Middleware func(http.Handler) http.Handler
+
+// Chain 按顺序包装 h，第一个中间件在最外层
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Middlewares 按配置创建网关的中间件链，请求ID和 panic 恢复始终开启
+func Middlewares(cfg config.Middleware) []Middleware {
+	mws := []Middleware{RequestID()}
+	if cfg.AccessLog {
+		mws = append(mws, AccessLog(log.Default()))
+	}
+	mws = append(mws, Recover())
+	if cfg.Security != (config.SecurityHeaders{}) {
+		mws = append(mws, SecurityHeaders(cfg.Security))
+	}
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		mws = append(mws, CORS(cfg.CORS))
+	}
+	if cfg.MaxBodySize > 0 {
+		mws = append(mws, LimitBody(cfg.MaxBodySize))
+	}
+	if cfg.Timeout > 0 {
+		mws = append(mws, Timeout(cfg.Timeout))
+	}
+	return mws
+}
+
+// RequestID 保证每个请求都有请求ID，见 RequestIDHeader
+func RequestID() Middleware {
+	return withRequestID
+}
+
+// AccessLog 请求结束后打印方法、路径、状态码、响应字节数和耗时，查询参数中的 access_token 不打印
+func AccessLog(logger *log.Logger) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			defer func() {
+				// WebSocket 升级后 rec 记录不到状态码
+				code := rec.status
+				if rec.hijacked {
+					code = http.StatusSwitchingProtocols
+				} else if code == 0 {
+					code = http.StatusOK
+				}
+				logger.Printf("access: %s %s %d %dB %s remote=%s request_id=%s",
+					r.Method, logURI(r.URL), code, rec.bytes, time.Since(start).Round(time.Microsecond),
+					r.RemoteAddr, r.Header.Get(RequestIDHeader))
+			}()
+			h.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// logURI 去掉查询参数中的 access_token，token 不能出现在日志中
+func logURI(u *url.URL) string {
+	q := u.Query()
+	if _, ok := q[AccessTokenParam]; !ok {
+		return u.RequestURI()
+	}
+	q.Del(AccessTokenParam)
+	uri := *u
+	uri.RawQuery = q.Encode()
+	return uri.RequestURI()
+}
+
+// Recover 捕获处理器中的 panic，还没有写响应时返回 500
+func Recover() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &responseRecorder{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					// 由 net/http 中断连接，不是错误
+					panic(p)
+				}
+				log.Printf("gateway: panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+				if rec.status != 0 || rec.hijacked {
+					return
+				}
+				httpError(w, r, http.StatusInternalServerError, status.New(codes.Internal, "服务内部错误"))
+			}()
+			h.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// SecurityHeaders 设置安全相关的响应头，HSTS 只在 HTTPS 请求（包括代理转发的 X-Forwarded-Proto: https）上设置
+func SecurityHeaders(cfg config.SecurityHeaders) Middleware {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge/time.Second), 10)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			if hsts != "" && (r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			if cfg.NoSniff {
+				header.Set("X-Content-Type-Options", "nosniff")
+			}
+			if cfg.FrameOptions != "" {
+				header.Set("X-Frame-Options", cfg.FrameOptions)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORS 处理跨域请求：允许的来源原样返回 Origin，预检请求直接返回 204，不允许的预检请求返回 403。
+// AllowedMethods、AllowedHeaders 为空时允许预检请求中的所有方法和请求头
+func CORS(cfg config.CORS) Middleware {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := ""
+	if cfg.MaxAge > 0 {
+		maxAge = strconv.FormatInt(int64(cfg.MaxAge/time.Second), 10)
+	}
+	allowed := func(origin string) bool {
+		for _, o := range cfg.AllowedOrigins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !allowed(origin) {
+				if preflight {
+					httpError(w, r, http.StatusForbidden, status.Newf(codes.PermissionDenied, "不允许跨域来源 %s", origin))
+					return
+				}
+				// 普通请求照常处理，浏览器拿不到 Access-Control-Allow-Origin 会拒绝读取响应
+				h.ServeHTTP(w, r)
+				return
+			}
+			header.Set("Access-Control-Allow-Origin", origin)
+			if cfg.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if exposed != "" {
+					header.Set("Access-Control-Expose-Headers", exposed)
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			if methods != "" {
+				header.Set("Access-Control-Allow-Methods", methods)
+			} else {
+				header.Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+			}
+			if headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			} else if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				header.Set("Access-Control-Allow-Headers", req)
+			}
+			if maxAge != "" {
+				header.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// LimitBody 限制请求体大小，Content-Length 超过限制时直接返回 413；
+// 分块传输的请求体读到超过限制时出错，由 grpc-gateway 返回 400
+func LimitBody(n int64) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				httpError(w, r, http.StatusRequestEntityTooLarge,
+					status.Newf(codes.InvalidArgument, "请求体超过 %d 字节", n))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout 为请求的 context 设置截止时间，grpc-gateway 把它作为 gRPC 调用的截止时间，超时返回 504。
+// 流式请求（NDJSON、SSE、WebSocket）的时长由客户端决定，不设置超时
+func Timeout(d time.Duration) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isStreaming(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// isStreaming 请求或响应是流
+func isStreaming(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, MIMENDJSON) || strings.Contains(accept, MIMEEventStream) ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), MIMENDJSON)
+}
+
+// httpError 中间件直接返回的错误，结构与 ErrorHandler 相同
+func httpError(w http.ResponseWriter, r *http.Request, code int, st *status.Status) {
+	body := newErrorBody(st, localize(st, r.Header.Get("Accept-Language")), r.Header.Get(RequestIDHeader))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writeError(w, code, body)
+}
+
+// responseRecorder 记录状态码和响应字节数，保留流式响应需要的 Flush 和 WebSocket 需要的 Hijack
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gateway: response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap 供 http.ResponseController 使用
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,224 @@
+package gateway_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) gateway.Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := gateway.Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") }),
+		mw("a"), mw("b"), mw("c"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := strings.Join(order, ","); got != "a,b,c,handler" {
+		t.Errorf("order = %s", got)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := config.Default().Gateway.Middleware
+	cfg.CORS.AllowedOrigins = []string{"http://app.example"}
+	s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{Middleware: cfg}))
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		wantStatus int
+		wantOrigin string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "preflight",
+			method:     http.MethodOptions,
+			origin:     "http://app.example",
+			wantStatus: http.StatusNoContent,
+			wantOrigin: "http://app.example",
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{name: "preflight not allowed", method: http.MethodOptions, origin: "http://evil.example", wantStatus: http.StatusForbidden},
+		{
+			name:       "simple request",
+			method:     http.MethodPost,
+			origin:     "http://app.example",
+			wantStatus: http.StatusOK,
+			wantOrigin: "http://app.example",
+			wantHeader: map[string]string{"Access-Control-Expose-Headers": "X-Request-Id, Retry-After, WWW-Authenticate"},
+		},
+		// 服务端照常处理，由浏览器拒绝读取响应
+		{name: "simple request not allowed", method: http.MethodPost, origin: "http://evil.example", wantStatus: http.StatusOK},
+		{name: "same origin", method: http.MethodPost, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, s.HTTP.URL+"/v1/hello/sayHello", strings.NewReader(`{"name":"a"}`))
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			for k, want := range tt.wantHeader {
+				if got := resp.Header.Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{Middleware: config.Default().Gateway.Middleware}))
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantHSTS string
+	}{
+		{name: "http"},
+		{name: "behind https proxy", header: http.Header{"X-Forwarded-Proto": {"https"}}, wantHSTS: "max-age=31536000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, s.HTTP.URL+"/openapi.json", nil)
+			req.Header = tt.header
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("Strict-Transport-Security"); got != tt.wantHSTS {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.wantHSTS)
+			}
+			if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q", got)
+			}
+			if got := resp.Header.Get("X-Frame-Options"); got != "DENY" {
+				t.Errorf("X-Frame-Options = %q", got)
+			}
+		})
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{Middleware: config.Middleware{MaxBodySize: 64}}))
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "small", body: `{"name":"a"}`, wantStatus: http.StatusOK},
+		{name: "too large", body: `{"name":"` + strings.Repeat("a", 100) + `"}`, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(s.HTTP.URL+"/v1/hello/sayHello", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := gateway.Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }),
+		gateway.RequestID(), gateway.Recover())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var body gateway.ErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "INTERNAL" || body.RequestID == "" {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		accept       string
+		wantDeadline bool
+	}{
+		{name: "unary", wantDeadline: true},
+		{name: "ndjson stream", accept: gateway.MIMENDJSON},
+		{name: "sse stream", accept: gateway.MIMEEventStream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ok bool
+			h := gateway.Timeout(time.Second)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				_, ok = r.Context().Deadline()
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if ok != tt.wantDeadline {
+				t.Errorf("deadline set = %v, want %v", ok, tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := gateway.Chain(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}), gateway.RequestID(), gateway.AccessLog(log.New(&buf, "", 0)))
+	req := httptest.NewRequest(http.MethodPost, "/v1/hello?x=1", nil)
+	req.Header.Set(gateway.RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	line := buf.String()
+	for _, want := range []string{"access: POST /v1/hello?x=1 418 5B", "request_id=req-1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log %q does not contain %q", line, want)
+		}
+	}
+
+	// WebSocket 查询参数中的 token 不打印
+	buf.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, gateway.WebSocketPath+"?access_token=secret&x=1", nil))
+	if line := buf.String(); strings.Contains(line, "secret") || !strings.Contains(line, gateway.WebSocketPath+"?x=1 ") {
+		t.Errorf("log %q", line)
+	}
+}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// openAPISpec buf generate 生成的 OpenAPI v2 文档，随二进制一起发布
+//
+//go:embed openapi/hello.swagger.json
+var openAPISpec []byte
+
+// OpenAPISpec 返回网关的 OpenAPI v2 文档
+func OpenAPISpec() []byte {
+	return openAPISpec
+}
+
+// openAPIHandler 提供 /openapi.json，文档中的接口不需要认证即可查看
+func openAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		_, _ = w.Write(openAPISpec)
+	})
+}
+
+// swaggerUIAssets 页面使用的 swagger-ui-dist 文件，只提供这些文件，不列出目录
+var swaggerUIAssets = []string{"swagger-ui.css", "swagger-ui-bundle.js"}
+
+// swaggerUIPage 静态资源和页面同源，由网关从 config.Gateway.SwaggerUIDir 提供，不从 CDN 加载
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+  <meta charset="utf-8">
+  <title>go-grpc-example gateway</title>
+  <link rel="stylesheet" href="/swagger/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/swagger/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// swaggerUIHandler 提供 /swagger/ 页面和 dir 中的静态资源，通过 config.Gateway.SwaggerUI 开启，
+// 缺少资源文件时返回错误
+func swaggerUIHandler(dir string) (http.Handler, error) {
+	if dir == "" {
+		return nil, errors.New("swaggerUIDir is required when swaggerUI is enabled")
+	}
+	assets := make(map[string]string, len(swaggerUIAssets))
+	for _, name := range swaggerUIAssets {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("swagger UI asset: %w", err)
+		}
+		assets["/swagger/"+name] = path
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := assets[r.URL.Path]; ok {
+			http.ServeFile(w, r, path)
+			return
+		}
+		if r.URL.Path != "/swagger/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(swaggerUIPage))
+	}), nil
+}
@@ -0,0 +1,97 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	s := servertest.Start(t)
+	resp, err := http.Get(s.HTTP.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var spec struct {
+		Swagger             string                     `json:"swagger"`
+		Paths               map[string]json.RawMessage `json:"paths"`
+		SecurityDefinitions map[string]struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"securityDefinitions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Swagger != "2.0" {
+		t.Errorf("swagger = %q", spec.Swagger)
+	}
+	if _, ok := spec.Paths["/v1/greeter/sayMessage"]; !ok {
+		t.Errorf("paths = %v, missing /v1/greeter/sayMessage", spec.Paths)
+	}
+	bearer := spec.SecurityDefinitions["Bearer"]
+	if bearer.Type != "apiKey" || bearer.Name != "Authorization" || bearer.In != "header" {
+		t.Errorf("Bearer = %+v", bearer)
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"swagger-ui.css", "swagger-ui-bundle.js", "index.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("/* "+name+" */"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "disabled", path: "/swagger/", wantStatus: http.StatusNotFound},
+		{name: "page", enabled: true, path: "/swagger/", wantStatus: http.StatusOK, wantBody: `url: "/openapi.json"`},
+		{name: "asset", enabled: true, path: "/swagger/swagger-ui-bundle.js", wantStatus: http.StatusOK, wantBody: "swagger-ui-bundle.js"},
+		// 只提供页面使用的文件
+		{name: "other file", enabled: true, path: "/swagger/index.html", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{SwaggerUI: tt.enabled, SwaggerUIDir: dir}))
+			resp, err := http.Get(s.HTTP.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("body = %s, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// 开启时缺少静态资源不能启动
+	s := servertest.Start(t)
+	for _, assets := range []string{"", t.TempDir()} {
+		cfg := config.Gateway{SwaggerUI: true, SwaggerUIDir: assets}
+		if _, err := gateway.NewHandler(context.Background(), s.Conn, cfg, config.Auth{}); err == nil {
+			t.Errorf("NewHandler with swaggerUIDir %q succeeded", assets)
+		}
+	}
+}
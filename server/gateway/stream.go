@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MIMENDJSON 服务端流每条消息一行 JSON，请求体也可以使用该格式发送客户端流
+	MIMENDJSON = "application/x-ndjson"
+	// MIMEEventStream 服务端流以 Server-Sent Events 返回
+	MIMEEventStream = "text/event-stream"
+)
+
+// streamMarshaler 流式响应的输出格式，请求体的解析和默认的 JSON 相同。
+// grpc-gateway 把流中的每条消息包装为 {"result": ...}，错误包装为 {"error": google.rpc.Status}，
+// 这里把错误转换为与 ErrorHandler 相同的结构；SSE 中直接输出消息，错误使用 error 事件
+type streamMarshaler struct {
+	runtime.Marshaler
+	sse bool
+}
+
+func newStreamMarshaler(jsonpb *runtime.JSONPb, sse bool) *streamMarshaler {
+	return &streamMarshaler{Marshaler: jsonpb, sse: sse}
+}
+
+func (m *streamMarshaler) ContentType(_ interface{}) string {
+	if m.sse {
+		return MIMEEventStream
+	}
+	return MIMENDJSON
+}
+
+func (m *streamMarshaler) Delimiter() []byte {
+	if m.sse {
+		return []byte("\n\n")
+	}
+	return []byte("\n")
+}
+
+func (m *streamMarshaler) Marshal(v interface{}) ([]byte, error) {
+	if chunk, ok := v.(map[string]proto.Message); ok {
+		if s, ok := chunk["error"].(*spb.Status); ok {
+			// 流中的错误拿不到请求，不做本地化
+			st := status.FromProto(s)
+			body := newErrorBody(st, st.Message(), "")
+			if !m.sse {
+				return json.Marshal(map[string]ErrorBody{"error": body})
+			}
+			data, err := json.Marshal(body)
+			if err != nil {
+				return nil, err
+			}
+			return event("error", data), nil
+		}
+	}
+	if !m.sse {
+		return m.Marshaler.Marshal(v)
+	}
+	if chunk, ok := v.(map[string]interface{}); ok {
+		if result, ok := chunk["result"]; ok {
+			v = result
+		}
+	}
+	data, err := m.Marshaler.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// EventService 的事件以 seq 作为 id，EventSource 重连时通过 Last-Event-ID 从该事件之后恢复；
+	// 心跳使用 heartbeat 事件，不会触发 onmessage
+	if e, ok := v.(*hello.Event); ok {
+		if e.GetHeartbeat() {
+			return event("heartbeat", data), nil
+		}
+		return eventWithID(strconv.FormatUint(e.GetSeq(), 10), "", data), nil
+	}
+	return event("", data), nil
+}
+
+// event 一个 SSE 事件，JSON 中不会有换行，data 只需要一行
+func event(name string, data []byte) []byte {
+	return eventWithID("", name, data)
+}
+
+func eventWithID(id, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	if name != "" {
+		buf.WriteString("event: " + name + "\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// withStreamHeaders SSE 响应不能被缓存，也不能被反向代理缓冲
+func withStreamHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), MIMEEventStream) {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,254 @@
+package gateway_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSayHelloHTTP(t *testing.T) {
+	s := servertest.Start(t)
+	resp, err := http.Post(s.HTTP.URL+"/v1/hello/sayHello", "application/json", strings.NewReader(`{"name":"鲁迪","message":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got hello.HelloResponse
+	if err := decode(resp, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetName() != "鲁迪" || got.GetMessage() != "hi" {
+		t.Errorf("SayHello = %v", &got)
+	}
+}
+
+func TestLotsOfRepliesHTTP(t *testing.T) {
+	s := servertest.Start(t, servertest.WithStreamInterceptors(handler.StreamServerInterceptorValidate()))
+	want := []string{"a你好", "ahello", "aこんにちは", "a안녕하세요"}
+
+	t.Run("ndjson", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/lotsOfReplies", strings.NewReader(`{"name":"a"}`))
+		req.Header.Set("Accept", gateway.MIMENDJSON)
+		resp := do(t, req, http.StatusOK, gateway.MIMENDJSON)
+		var got []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line struct {
+				Result json.RawMessage `json:"result"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("line %q: %v", scanner.Text(), err)
+			}
+			var msg hello.HelloResponse
+			if err := protojson.Unmarshal(line.Result, &msg); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, msg.GetName())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("replies = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, s.HTTP.URL+"/v1/hello/lotsOfReplies?name=a", nil)
+		req.Header.Set("Accept", gateway.MIMEEventStream)
+		resp := do(t, req, http.StatusOK, gateway.MIMEEventStream)
+		if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("Cache-Control = %q", got)
+		}
+		var got []string
+		for _, ev := range readEvents(t, resp.Body) {
+			if ev.name != "" {
+				t.Fatalf("unexpected event %q: %s", ev.name, ev.data)
+			}
+			var msg hello.HelloResponse
+			if err := protojson.Unmarshal([]byte(ev.data), &msg); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, msg.GetName())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("replies = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("sse error", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, s.HTTP.URL+"/v1/hello/lotsOfReplies", nil)
+		req.Header.Set("Accept", gateway.MIMEEventStream)
+		resp := do(t, req, http.StatusBadRequest, gateway.MIMEEventStream)
+		events := readEvents(t, resp.Body)
+		if len(events) != 1 || events[0].name != "error" {
+			t.Fatalf("events = %+v, want one error", events)
+		}
+		var body gateway.ErrorBody
+		if err := json.Unmarshal([]byte(events[0].data), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Code != "INVALID_ARGUMENT" {
+			t.Errorf("code = %q", body.Code)
+		}
+	})
+}
+
+func TestLotsOfGreetingsHTTP(t *testing.T) {
+	s := servertest.Start(t)
+	// 请求体长度未知，按分块传输发送，每个 JSON 对象写入一次
+	pr, pw := io.Pipe()
+	go func() {
+		for _, name := range []string{"a", "b", "c"} {
+			fmt.Fprintf(pw, "{\"name\":%q}\n", name)
+		}
+		pw.Close()
+	}()
+	req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/lotsOfGreetings", pr)
+	req.Header.Set("Content-Type", gateway.MIMENDJSON)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got hello.HelloResponse
+	if err := decode(resp, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetName() != "你好：abc" {
+		t.Errorf("LotsOfGreetings = %q", got.GetName())
+	}
+}
+
+func TestBidiHelloHTTP(t *testing.T) {
+	s := servertest.Start(t)
+	req, _ := http.NewRequest(http.MethodPost, s.HTTP.URL+"/v1/hello/bidiHello", strings.NewReader(`{"name":"a"}{"name":"b"}{"name":"c"}`))
+	req.Header.Set("Accept", gateway.MIMENDJSON)
+	resp := do(t, req, http.StatusOK, gateway.MIMENDJSON)
+	var got []string
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line struct {
+			Result struct {
+				Name string `json:"name"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, line.Result.Name)
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("BidiHello = %q", got)
+	}
+}
+
+func TestSubscribeHTTP(t *testing.T) {
+	s := servertest.Start(t, servertest.WithEventsConfig(config.Events{Heartbeat: 50 * time.Millisecond}))
+	for _, data := range []string{"a", "b", "c"} {
+		resp, err := http.Post(s.HTTP.URL+"/v1/events/orders", "application/json", strings.NewReader(`{"data":"`+data+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// EventSource 重连时携带 Last-Event-ID，从该事件之后恢复；超时后流以 error 事件结束
+	req, _ := http.NewRequest(http.MethodGet, s.HTTP.URL+"/v1/events/orders", nil)
+	req.Header.Set("Accept", gateway.MIMEEventStream)
+	req.Header.Set("Last-Event-ID", "1")
+	req.Header.Set("Grpc-Timeout", "200m")
+	resp := do(t, req, http.StatusOK, gateway.MIMEEventStream)
+	var got []string
+	heartbeats := 0
+	for _, ev := range readEvents(t, resp.Body) {
+		switch ev.name {
+		case "heartbeat":
+			heartbeats++
+		case "error":
+		default:
+			var e hello.Event
+			if err := protojson.Unmarshal([]byte(ev.data), &e); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ev.id+":"+e.GetData())
+		}
+	}
+	if strings.Join(got, ",") != "2:b,3:c" {
+		t.Errorf("events = %q, want 2:b,3:c", got)
+	}
+	if heartbeats == 0 {
+		t.Error("no heartbeat")
+	}
+}
+
+func do(t *testing.T, req *http.Request, wantStatus int, wantType string) *http.Response {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != wantStatus {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, wantStatus, body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != wantType {
+		t.Errorf("Content-Type = %q, want %q", got, wantType)
+	}
+	return resp
+}
+
+func decode(resp *http.Response, m *hello.HelloResponse) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, data)
+	}
+	return protojson.Unmarshal(data, m)
+}
+
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, r io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var ev sseEvent
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if ev.data != "" {
+				events = append(events, ev)
+			}
+			ev = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
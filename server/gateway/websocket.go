@@ -0,0 +1,250 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocketPath BidiHello 的 WebSocket 接口
+const WebSocketPath = "/v1/hello/bidiHello/ws"
+
+// TokenProtocol 浏览器的 WebSocket 不能设置请求头，token 放在 Sec-WebSocket-Protocol 中：
+// 客户端提供 [TokenProtocol, token] 两个子协议，网关选择 TokenProtocol，不会把 token 写回响应
+const TokenProtocol = "access_token"
+
+// AccessTokenParam 旧客户端在查询参数中携带 token，查询参数会出现在代理的访问日志中，建议使用 TokenProtocol
+const AccessTokenParam = "access_token"
+
+// CloseGRPCBase gRPC 调用出错时的关闭码为 CloseGRPCBase + gRPC 状态码，例如 Unauthenticated 为 4016
+const CloseGRPCBase = 4000
+
+// websocketBridge 把 WebSocket 连接转换为 BidiHello 流：
+// 客户端的每个文本帧是一个 HelloRequest，服务端的每条响应以 {"result": ...} 发送，出错时发送 {"error": ...} 后关闭连接
+type websocketBridge struct {
+	client   hello.HelloServiceClient
+	cfg      config.WebSocket
+	rules    *HeaderRules
+	json     *runtime.JSONPb
+	upgrader websocket.Upgrader
+}
+
+func newWebSocketBridge(client hello.HelloServiceClient, cfg config.WebSocket, rules *HeaderRules, jsonpb *runtime.JSONPb) *websocketBridge {
+	cfg = cfg.WithDefaults()
+	b := &websocketBridge{client: client, cfg: cfg, rules: rules, json: jsonpb}
+	b.upgrader = websocket.Upgrader{CheckOrigin: b.checkOrigin, Subprotocols: []string{TokenProtocol}}
+	return b
+}
+
+// checkOrigin 没有配置 Origins 时只允许同源，* 允许所有来源
+func (b *websocketBridge) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(b.cfg.Origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range b.cfg.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeFrame 连接结束时发送给客户端的错误和关闭帧
+type closeFrame struct {
+	code   int
+	reason string
+	err    *ErrorBody
+}
+
+// wsConn 一个 WebSocket 连接的状态，只有 ServeHTTP 所在的 goroutine 写连接
+type wsConn struct {
+	ws     *websocket.Conn
+	cancel context.CancelFunc
+	once   sync.Once
+	close  closeFrame
+}
+
+// finish 记录第一个结束原因，之后的原因忽略
+func (c *wsConn) finish(f closeFrame) {
+	c.once.Do(func() { c.close = f })
+}
+
+func (b *websocketBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 已经返回了 HTTP 错误
+		log.Printf("gateway: websocket upgrade: %v", err)
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(b.cfg.MaxMessageSize)
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(r.Context(), b.outgoingMetadata(r)))
+	defer cancel()
+	c := &wsConn{ws: ws, cancel: cancel}
+
+	stream, err := b.client.BidiHello(ctx)
+	if err != nil {
+		c.finish(b.errorFrame(r, err))
+		b.writeClose(c)
+		return
+	}
+
+	// out 满了之后 recv 不再从服务端读取，gRPC 流控会让服务端的 Send 等待
+	out := make(chan []byte, b.cfg.SendBuffer)
+	go b.recv(ctx, r, c, stream, out)
+	go b.read(c, stream)
+
+	ping := time.NewTicker(b.cfg.PingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case data, ok := <-out:
+			if !ok {
+				b.writeClose(c)
+				return
+			}
+			ws.SetWriteDeadline(time.Now().Add(b.cfg.WriteTimeout))
+			if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				// 客户端太慢或者已经断开，取消调用
+				log.Printf("gateway: websocket write: %v", err)
+				return
+			}
+		case <-ping.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(b.cfg.WriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// recv 把服务端的响应转换为文本帧，结束时关闭 out
+func (b *websocketBridge) recv(ctx context.Context, r *http.Request, c *wsConn, stream hello.HelloService_BidiHelloClient, out chan<- []byte) {
+	defer close(out)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			c.finish(closeFrame{code: websocket.CloseNormalClosure})
+			return
+		}
+		if err != nil {
+			c.finish(b.errorFrame(r, err))
+			return
+		}
+		result, err := b.json.MarshalOptions.Marshal(resp)
+		if err != nil {
+			c.finish(b.errorFrame(r, status.Errorf(codes.Internal, "marshal response: %v", err)))
+			c.cancel()
+			return
+		}
+		data, _ := json.Marshal(map[string]json.RawMessage{"result": result})
+		select {
+		case out <- data:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// read 把客户端的文本帧转发给服务端，客户端正常关闭时结束发送，等待服务端返回剩余的响应
+func (b *websocketBridge) read(c *wsConn, stream hello.HelloService_BidiHelloClient) {
+	ws := c.ws
+	ws.SetReadDeadline(time.Now().Add(b.cfg.PongTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(b.cfg.PongTimeout))
+	})
+	// 默认的处理会立即回复关闭帧，这里等服务端的响应发送完之后再回复
+	ws.SetCloseHandler(func(int, string) error { return nil })
+	for {
+		typ, data, err := ws.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) && ce.Code == websocket.CloseNormalClosure {
+				stream.CloseSend()
+				return
+			}
+			// 客户端离开、连接断开、超时没有收到 pong 或消息过大（gorilla 已发送 1009）
+			c.finish(closeFrame{code: websocket.CloseAbnormalClosure})
+			c.cancel()
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(b.cfg.PongTimeout))
+		if typ != websocket.TextMessage {
+			c.finish(closeFrame{code: websocket.CloseUnsupportedData, reason: "text frames only"})
+			c.cancel()
+			return
+		}
+		req := &hello.HelloRequest{}
+		if err := b.json.UnmarshalOptions.Unmarshal(data, req); err != nil {
+			c.finish(closeFrame{code: websocket.CloseInvalidFramePayloadData, reason: "invalid HelloRequest"})
+			c.cancel()
+			return
+		}
+		if err := stream.Send(req); err != nil {
+			// 服务端已经结束，错误由 recv 返回
+			return
+		}
+	}
+}
+
+// errorFrame gRPC 错误转换为错误消息和 4000+ 的关闭码
+func (b *websocketBridge) errorFrame(r *http.Request, err error) closeFrame {
+	st := status.Convert(err)
+	if st.Code() == codes.Canceled {
+		return closeFrame{code: websocket.CloseGoingAway}
+	}
+	body := newErrorBody(st, localize(st, r.Header.Get("Accept-Language")), r.Header.Get(RequestIDHeader))
+	return closeFrame{code: CloseGRPCBase + int(st.Code()), reason: body.Code, err: &body}
+}
+
+// writeClose 发送错误消息和关闭帧，连接已经断开时忽略错误
+func (b *websocketBridge) writeClose(c *wsConn) {
+	f := c.close
+	if f.code == websocket.CloseAbnormalClosure {
+		// 1006 不能出现在关闭帧中
+		return
+	}
+	deadline := time.Now().Add(b.cfg.WriteTimeout)
+	if f.err != nil {
+		data, _ := json.Marshal(map[string]*ErrorBody{"error": f.err})
+		c.ws.SetWriteDeadline(deadline)
+		if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+	}
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(f.code, f.reason), deadline)
+}
+
+// outgoingMetadata 和 grpc-gateway 一样按 HeaderRules 转发请求头，Authorization 转为 token。
+// 没有 Authorization 时依次使用 Sec-WebSocket-Protocol 和查询参数中的 token
+func (b *websocketBridge) outgoingMetadata(r *http.Request) metadata.MD {
+	md := b.rules.Metadata(r)
+	if len(md.Get("token")) > 0 {
+		return md
+	}
+	if protocols := websocket.Subprotocols(r); len(protocols) == 2 && protocols[0] == TokenProtocol {
+		md.Set("token", protocols[1])
+	} else if token := r.URL.Query().Get(AccessTokenParam); token != "" {
+		md.Set("token", token)
+	}
+	return md
+}
@@ -0,0 +1,222 @@
+package gateway_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsFrame 网关发送的文本帧
+type wsFrame struct {
+	Result *struct {
+		Name string `json:"name"`
+	} `json:"result"`
+	Error *gateway.ErrorBody `json:"error"`
+}
+
+func dialWebSocket(t *testing.T, s *servertest.Server, query string, header http.Header) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(s.HTTP.URL, "http") + gateway.WebSocketPath + query
+	ws, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		if resp != nil {
+			t.Fatalf("dial: %v (status %d)", err, resp.StatusCode)
+		}
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return ws
+}
+
+// readUntilClose 读取所有文本帧，直到收到关闭帧
+func readUntilClose(t *testing.T, ws *websocket.Conn) ([]wsFrame, int) {
+	t.Helper()
+	var frames []wsFrame
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			if !errors.As(err, &ce) {
+				t.Fatalf("read: %v", err)
+			}
+			return frames, ce.Code
+		}
+		var f wsFrame
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatalf("frame %s: %v", data, err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth())
+	tests := []struct {
+		name   string
+		query  string
+		header http.Header
+	}{
+		{name: "authorization header", header: http.Header{"Authorization": {s.Token()}}},
+		// 浏览器不能设置请求头，new WebSocket(url, ["access_token", token])
+		{name: "subprotocol", header: http.Header{"Sec-WebSocket-Protocol": {gateway.TokenProtocol + ", " + s.Token()}}},
+		{name: "access_token", query: "?access_token=" + s.Token()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := dialWebSocket(t, s, tt.query, tt.header)
+			// 选择的子协议不包含 token
+			if tt.header.Get("Sec-WebSocket-Protocol") != "" && ws.Subprotocol() != gateway.TokenProtocol {
+				t.Errorf("subprotocol = %q, want %q", ws.Subprotocol(), gateway.TokenProtocol)
+			}
+			for _, name := range []string{"a", "b", "c"} {
+				if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"name":"`+name+`"}`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 正常关闭后网关仍然返回服务端剩余的响应
+			if err := ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+				t.Fatal(err)
+			}
+			frames, code := readUntilClose(t, ws)
+			var got []string
+			for _, f := range frames {
+				if f.Result == nil {
+					t.Fatalf("unexpected frame %+v", f)
+				}
+				got = append(got, f.Result.Name)
+			}
+			if strings.Join(got, ",") != "a,b,c" {
+				t.Errorf("responses = %q", got)
+			}
+			if code != websocket.CloseNormalClosure {
+				t.Errorf("close code = %d, want %d", code, websocket.CloseNormalClosure)
+			}
+		})
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth(), servertest.WithStreamInterceptors(handler.StreamServerInterceptorValidate()))
+	tests := []struct {
+		name      string
+		header    http.Header
+		send      []byte
+		typ       int
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:      "no token",
+			send:      []byte(`{"name":"a"}`),
+			typ:       websocket.TextMessage,
+			wantCode:  gateway.CloseGRPCBase + 16,
+			wantError: "UNAUTHENTICATED",
+		},
+		{
+			name:      "invalid request",
+			header:    http.Header{"Authorization": {s.Token()}},
+			send:      []byte(`{"message":"no name"}`),
+			typ:       websocket.TextMessage,
+			wantCode:  gateway.CloseGRPCBase + 3,
+			wantError: "INVALID_ARGUMENT",
+		},
+		{
+			name:     "invalid json",
+			header:   http.Header{"Authorization": {s.Token()}},
+			send:     []byte(`{`),
+			typ:      websocket.TextMessage,
+			wantCode: websocket.CloseInvalidFramePayloadData,
+		},
+		{
+			name:     "binary frame",
+			header:   http.Header{"Authorization": {s.Token()}},
+			send:     []byte(`{"name":"a"}`),
+			typ:      websocket.BinaryMessage,
+			wantCode: websocket.CloseUnsupportedData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := dialWebSocket(t, s, "", tt.header)
+			if err := ws.WriteMessage(tt.typ, tt.send); err != nil {
+				t.Fatal(err)
+			}
+			frames, code := readUntilClose(t, ws)
+			if code != tt.wantCode {
+				t.Errorf("close code = %d, want %d", code, tt.wantCode)
+			}
+			if tt.wantError == "" {
+				return
+			}
+			if len(frames) != 1 || frames[0].Error == nil || frames[0].Error.Code != tt.wantError {
+				t.Errorf("frames = %+v, want error %s", frames, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{
+		WebSocket: config.WebSocket{PingInterval: 20 * time.Millisecond},
+	}))
+	ws := dialWebSocket(t, s, "", nil)
+	pings := make(chan struct{}, 10)
+	ws.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	// 控制帧在 ReadMessage 中处理
+	go ws.ReadMessage()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d pings, want 3", i)
+		}
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		wantOK  bool
+	}{
+		{name: "same origin", origin: "SELF", wantOK: true},
+		{name: "cross origin", origin: "http://evil.example", wantOK: false},
+		{name: "allowed", origins: []string{"http://app.example"}, origin: "http://app.example", wantOK: true},
+		{name: "wildcard", origins: []string{"*"}, origin: "http://evil.example", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithGatewayConfig(config.Gateway{
+				WebSocket: config.WebSocket{Origins: tt.origins},
+			}))
+			origin := tt.origin
+			if origin == "SELF" {
+				origin = s.HTTP.URL
+			}
+			url := "ws" + strings.TrimPrefix(s.HTTP.URL, "http") + gateway.WebSocketPath
+			ws, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+			if ws != nil {
+				ws.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("dial err = %v, want ok %v", err, tt.wantOK)
+			}
+			if !tt.wantOK && resp != nil && resp.StatusCode != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+			}
+		})
+	}
+}
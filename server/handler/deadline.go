@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// ServerInterceptorDeadline 一元拦截器，为没有截止时间的请求设置默认超时，并限制最长超时
+func ServerInterceptorDeadline(cfg config.Deadline) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, cancel, _ := applyDeadline(ctx, cfg, info.FullMethod)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptorDeadline 流式拦截器，超时后结束流并返回 DeadlineExceeded，不作用于健康检查。
+// 截止时间由客户端决定时 gRPC 会让阻塞的 RecvMsg 返回，直接调用处理函数；
+// 由这里设置或缩短时，处理函数在单独的协程中执行，超时后先返回结束流，阻塞在 RecvMsg 上的处理函数随之退出
+func StreamServerInterceptorDeadline(cfg config.Deadline) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, cancel, shortened := applyDeadline(ss.Context(), cfg, info.FullMethod)
+		defer cancel()
+		ds := &deadlineStream{ServerStream: ss, ctx: ctx}
+		if !shortened {
+			return handler(srv, ds)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- handler(srv, ds)
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			ds.close()
+			// 处理函数可能同时结束，优先使用它的结果
+			select {
+			case err := <-done:
+				return err
+			default:
+				return status.FromContextError(ctx.Err()).Err()
+			}
+		}
+	}
+}
+
+// applyDeadline 计算方法实际的截止时间，shortened 表示截止时间由这里设置或者比客户端设置的更早
+func applyDeadline(ctx context.Context, cfg config.Deadline, method string) (context.Context, context.CancelFunc, bool) {
+	def, max := cfg.ForMethod(method)
+	if deadline, ok := ctx.Deadline(); ok {
+		if max > 0 && time.Until(deadline) > max {
+			ctx, cancel := context.WithTimeout(ctx, max)
+			return ctx, cancel, true
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, false
+	}
+	timeout := def
+	if timeout <= 0 {
+		timeout = max
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, true
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, false
+}
+
+// deadlineStream 使用带截止时间的上下文包装 ServerStream，拦截器返回后不再向原始流发送消息
+type deadlineStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *deadlineStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg 和 close 互斥，拦截器返回、gRPC 写入状态时处理函数不会同时发送
+func (s *deadlineStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return status.FromContextError(context.DeadlineExceeded).Err()
+	}
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *deadlineStream) RecvMsg(m interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return s.ServerStream.RecvMsg(m)
+}
+
+func (s *deadlineStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
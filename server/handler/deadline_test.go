@@ -0,0 +1,110 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestServerInterceptorDeadline(t *testing.T) {
+	cfg := config.Deadline{
+		Default: time.Minute,
+		Max:     10 * time.Minute,
+		Methods: map[string]config.MethodDeadline{"/slow": {Default: 10 * time.Minute, Max: 30 * time.Minute}},
+	}
+	tests := []struct {
+		name   string
+		cfg    config.Deadline
+		method string
+		client time.Duration
+		// want 0 表示没有截止时间
+		want time.Duration
+	}{
+		{name: "default", cfg: cfg, method: "/m", want: time.Minute},
+		{name: "client deadline kept", cfg: cfg, method: "/m", client: 5 * time.Second, want: 5 * time.Second},
+		{name: "client deadline capped", cfg: cfg, method: "/m", client: time.Hour, want: 10 * time.Minute},
+		{name: "method default", cfg: cfg, method: "/slow", want: 10 * time.Minute},
+		{name: "method max", cfg: cfg, method: "/slow", client: time.Hour, want: 30 * time.Minute},
+		{name: "only max", cfg: config.Deadline{Max: time.Minute}, method: "/m", want: time.Minute},
+		{name: "no limits", method: "/m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.client > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.client)
+				defer cancel()
+			}
+			var got time.Duration
+			var ok bool
+			interceptor := handler.ServerInterceptorDeadline(tt.cfg)
+			interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				var deadline time.Time
+				deadline, ok = ctx.Deadline()
+				got = time.Until(deadline)
+				return nil, nil
+			})
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("deadline set: %v", got)
+				}
+				return
+			}
+			if !ok || got > tt.want || got < tt.want-time.Second {
+				t.Errorf("deadline in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamServerInterceptorDeadline(t *testing.T) {
+	cfg := config.Deadline{Default: 100 * time.Millisecond}
+	s := servertest.Start(t, servertest.WithAuth(),
+		servertest.WithStreamInterceptors(handler.StreamServerInterceptorDeadline(cfg)))
+
+	t.Run("idle stream ends at server deadline", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stream, err := s.Hello.BidiHello(ctx)
+		if err != nil {
+			t.Fatalf("BidiHello: %v", err)
+		}
+		// 不发送消息，处理函数一直阻塞在 Recv 上
+		start := time.Now()
+		_, err = stream.Recv()
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("Recv = %v, want DeadlineExceeded", err)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("stream ended after %v", d)
+		}
+	})
+
+	t.Run("client deadline is used", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := s.Hello.BidiHello(ctx)
+		if err != nil {
+			t.Fatalf("BidiHello: %v", err)
+		}
+		// 超过服务端的默认超时后仍然可以收发消息
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(&hello.HelloRequest{Name: "a"}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if _, err := stream.Recv(); err != nil {
+				t.Fatalf("Recv %d: %v", i, err)
+			}
+			time.Sleep(60 * time.Millisecond)
+		}
+		stream.CloseSend()
+	})
+}
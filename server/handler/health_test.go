@@ -0,0 +1,74 @@
+package handler_test
+
+import (
+	"context"
+	clientconn "github.com/keepon-online/go-grpc-example/client/conn"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// 客户端的健康检查 Health/Watch 一直保持，不能被服务端的默认截止时间结束，也不占用限流的令牌
+func TestHealthCheckBypassesDeadlineAndRateLimit(t *testing.T) {
+	deadline := config.Deadline{Default: 100 * time.Millisecond}
+	rateLimit := config.RateLimit{Enabled: true, Rules: []config.RateLimitRule{
+		{Method: "*", KeyBy: handler.KeyByMethod, Rate: 0.001, Burst: 1},
+	}}
+	limiter := handler.NewMemoryLimiter()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			handler.ServerInterceptorRateLimit(rateLimit, limiter),
+			handler.ServerInterceptorDeadline(deadline),
+		),
+		grpc.ChainStreamInterceptor(
+			handler.StreamServerInterceptorRateLimit(rateLimit, limiter),
+			handler.StreamServerInterceptorDeadline(deadline),
+		),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	hello.RegisterHelloServiceServer(s, &service.HelloServer{Rooms: service.NewRooms(config.Chat{})})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	cc, err := clientconn.Dial(config.Client{
+		Target:   "passthrough:///bufnet",
+		Balancer: config.Balancer{Policy: "round_robin", HealthCheck: true},
+	},
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc.Connect()
+	for state := cc.GetState(); state != connectivity.Ready; state = cc.GetState() {
+		if !cc.WaitForStateChange(ctx, state) {
+			t.Fatalf("connection not ready: %v", state)
+		}
+	}
+	// 超过默认截止时间后连接仍然是 READY
+	wait, cancelWait := context.WithTimeout(ctx, 5*deadline.Default)
+	defer cancelWait()
+	if cc.WaitForStateChange(wait, connectivity.Ready) {
+		t.Fatalf("connection left READY: %v", cc.GetState())
+	}
+	// 健康检查没有用掉唯一的令牌
+	if _, err := hello.NewHelloServiceClient(cc).SayHello(ctx, &hello.HelloRequest{Name: "a"}); err != nil {
+		t.Errorf("SayHello: %v", err)
+	}
+}
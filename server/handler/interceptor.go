@@ -4,15 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
-	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
 	"github.com/keepon-online/go-grpc-example/util"
-	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/status"
 	"log"
 	"runtime/debug"
 	"strings"
@@ -44,7 +41,7 @@ func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 		end := time.Now()
 		// 记录请求参数 耗时 错误信息等数据
 		// 后处理(post-processing)
-		log.Printf("RPC: %s,client-OS: '%v' and IP: '%v' req:%v start time: %s, end time: %s, err: %v", info.FullMethod, os, ip, req, start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+		log.Printf("RPC: %s,tenant: %s,client-OS: '%v' and IP: '%v' req:%v start time: %s, end time: %s, err: %v", info.FullMethod, TenantFromContext(ctx), os, ip, req, start.Format(time.RFC3339), end.Format(time.RFC3339), err)
 		return m, err
 	}
 }
@@ -97,58 +94,106 @@ func (e *streamServer) SendMsg(m interface{}) error {
 	return nil
 }
 
-// ServerInterceptorCheckToken 用一元拦截器实现认证
-func ServerInterceptorCheckToken() grpc.UnaryServerInterceptor {
+// ServerInterceptorCheckToken 用一元拦截器实现认证，token使用 cfg.SigningKey 校验
+func ServerInterceptorCheckToken(cfg config.Auth) grpc.UnaryServerInterceptor {
+	j := util.NewJWT([]byte(cfg.SigningKey))
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
 		// 验证token
-		_, err = checkToken(ctx)
+		claims, err := checkToken(ctx, j)
 		if err != nil {
-			fmt.Println("Interceptor 拦截器内token认证失败")
 			return nil, err
 		}
-		fmt.Println("Interceptor 拦截器内token认证成功")
-		return handler(ctx, req)
+		return handler(NewContextWithClaims(ctx, claims), req)
 	}
 }
 
+// StreamServerInterceptorCheckToken 用流式拦截器实现认证
+func StreamServerInterceptorCheckToken(cfg config.Auth) grpc.StreamServerInterceptor {
+	j := util.NewJWT([]byte(cfg.SigningKey))
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		claims, err := checkToken(ss.Context(), j)
+		if err != nil {
+			return err
+		}
+		return handler(srv, newContextStream(ss, NewContextWithClaims(ss.Context(), claims)))
+	}
+}
+
+// isHealthCheck 健康检查由负载均衡器和探针调用，不需要认证，也不经过限流和截止时间：
+// 客户端的健康检查 Health/Watch 是一直保持的流，被截止时间结束后后端会被认为不健康
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/")
+}
+
+type claimsKey struct{}
+
+// NewContextWithClaims 将认证通过的token信息放入上下文
+func NewContextWithClaims(ctx context.Context, claims *util.CustomClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext 从上下文取出认证通过的token信息
+func ClaimsFromContext(ctx context.Context) (*util.CustomClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*util.CustomClaims)
+	return claims, ok && claims != nil
+}
+
+// contextStream 替换 ServerStream 的上下文，用于在流式拦截器中向下传递数据
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func newContextStream(s grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &contextStream{ServerStream: s, ctx: ctx}
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
 // 验证
-func checkToken(ctx context.Context) (*hello.HelloResponse, error) {
+func checkToken(ctx context.Context, j *util.JWT) (*util.CustomClaims, error) {
 	// 取出元数据
 	md, b := metadata.FromIncomingContext(ctx)
 	if !b {
-		return nil, status.Error(codes.InvalidArgument, "token信息不存在")
+		return nil, errs.ErrTokenMissing
 	}
 
-	var token, _ string
 	// 取出token
 	tokenInfo, ok := md["token"]
-	if !ok {
-		return nil, status.Error(codes.InvalidArgument, "token不存在")
+	if !ok || len(tokenInfo) == 0 {
+		return nil, errs.ErrTokenMissing
 	}
 
-	token = tokenInfo[0]
-
 	//验证
-	j := util.NewJWT()
-	parseToken, err := j.ParseToken(token)
+	parseToken, err := j.ParseToken(tokenInfo[0])
 	if err != nil {
-		st := status.New(codes.InvalidArgument, "token校验失败")
-		ds, err := st.WithDetails(
-			&errdetails.QuotaFailure{
-				Violations: []*errdetails.QuotaFailure_Violation{{
-					Subject:     fmt.Sprintf("token失效"),
-					Description: "请使用新的token",
-				}},
-			},
-		)
-		if err != nil {
-			return nil, st.Err()
-		}
-		return nil, ds.Err()
+		return nil, tokenError(err)
+	}
+	return parseToken, nil
+}
+
+// tokenError 把 util 中 token 解析的错误转换为带原因的业务错误，客户端可以区分过期和格式错误
+func tokenError(err error) *errs.Error {
+	switch {
+	case errors.Is(err, util.TokenExpired):
+		return errs.ErrTokenExpired.WithCause(err)
+	case errors.Is(err, util.TokenMalformed):
+		return errs.ErrTokenMalformed.WithCause(err)
+	case errors.Is(err, util.TokenNotValidYet):
+		return errs.ErrTokenNotValidYet.WithCause(err)
+	default:
+		return errs.ErrTokenInvalid.WithCause(err)
 	}
-	fmt.Println("parseToken: ", parseToken.Username)
-	return nil, nil
 }
 
 // AuthenticateInterceptor 定义一个认证拦截器，将token添加到gRPC元数据中进行身份验证
@@ -163,12 +208,23 @@ func AuthenticateInterceptor(ctx context.Context, req interface{}, info *grpc.Un
 	return handler(ctx, req)
 }
 
-// CustomHeaderMatcher 定义一个HTTP请求处理程序，将token从自定义头中提取出来，并将其添加到gRPC元数据中进行身份验证
+// CustomHeaderMatcher 定义一个HTTP请求处理程序，将token从自定义头中提取出来，并将其添加到gRPC元数据中进行身份验证。
+// 只处理网关始终转发的请求头，其他请求头由网关的 HeaderRules 按配置决定
 func CustomHeaderMatcher(key string) (string, bool) {
-	if strings.ToLower(key) == "authorization" {
+	switch strings.ToLower(key) {
+	case "authorization":
 		return "token", true
+	case "x-request-id":
+		// 网关生成的请求ID，服务端日志可以用它关联 HTTP 请求
+		return "x-request-id", true
+	case "last-event-id":
+		// EventSource 重连时携带的最后一个事件的 id，订阅从该事件之后恢复
+		return "last-event-id", true
+	case "idempotency-key":
+		// BatchGreetings 的幂等键，重试时返回第一次的结果
+		return "idempotency-key", true
 	}
-	return runtime.DefaultHeaderMatcher(key)
+	return "", false
 }
 
 // GrpcRecover recover防止单个请求中的panic, 导致整个进程挂掉, 同时将panic时的堆栈信息保存到日志文件, 以及返回error信息
@@ -176,8 +232,7 @@ func GrpcRecover() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		defer func() {
 			if e := recover(); e != nil {
-				log.Printf("server panic %v \n", e)
-				log.Printf("%s \n", debug.Stack())
+				log.Printf("server panic in %s, tenant %s: %v\n%s", info.FullMethod, TenantFromContext(ctx), e, debug.Stack())
 				err = errors.New(fmt.Sprintf("panic:%v", e))
 			}
 		}()
@@ -0,0 +1,96 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func expiredToken(t *testing.T) string {
+	j := util.NewJWT([]byte(servertest.SigningKey))
+	claims := j.CreateClaims(util.BaseClaims{ID: 1, Username: "expired"})
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	token, err := j.CreateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func futureToken(t *testing.T) string {
+	j := util.NewJWT([]byte(servertest.SigningKey))
+	claims := j.CreateClaims(util.BaseClaims{ID: 1, Username: "future"})
+	claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	token, err := j.CreateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func foreignToken(t *testing.T) string {
+	j := &util.JWT{SigningKey: []byte("another key")}
+	token, err := j.CreateToken(j.CreateClaims(util.BaseClaims{ID: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestCheckToken(t *testing.T) {
+	s := servertest.Start(t, servertest.WithAuth())
+	// 不携带默认token的连接，每个用例通过元数据设置自己的token
+	conn := s.Dial(t)
+	client := hello.NewHelloServiceClient(conn)
+
+	tests := []struct {
+		name       string
+		md         metadata.MD
+		wantC      codes.Code
+		wantReason string
+	}{
+		{name: "no metadata", wantC: codes.Unauthenticated, wantReason: errs.ReasonTokenMissing},
+		{name: "no token", md: metadata.Pairs("uid", servertest.UID), wantC: codes.Unauthenticated, wantReason: errs.ReasonTokenMissing},
+		{name: "malformed", md: metadata.Pairs("token", "not a jwt"), wantC: codes.Unauthenticated, wantReason: errs.ReasonTokenMalformed},
+		{name: "expired", md: metadata.Pairs("token", expiredToken(t)), wantC: codes.Unauthenticated, wantReason: errs.ReasonTokenExpired},
+		{name: "not valid yet", md: metadata.Pairs("token", futureToken(t)), wantC: codes.Unauthenticated, wantReason: errs.ReasonTokenNotValidYet},
+		{name: "wrong signature", md: metadata.Pairs("token", foreignToken(t)), wantC: codes.Unauthenticated, wantReason: errs.ReasonTokenInvalid},
+		{name: "valid", md: metadata.Pairs("token", s.Token()), wantC: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.md != nil {
+				ctx = metadata.NewOutgoingContext(ctx, tt.md)
+			}
+
+			_, err := client.SayHello(ctx, &hello.HelloRequest{Name: "a"})
+			if got := status.Code(err); got != tt.wantC {
+				t.Errorf("SayHello code = %s, want %s (err %v)", got, tt.wantC, err)
+			}
+			if got := errs.Reason(err); got != tt.wantReason {
+				t.Errorf("SayHello reason = %q, want %q", got, tt.wantReason)
+			}
+
+			stream, err := client.LotsOfReplies(ctx, &hello.HelloRequest{Name: "a"})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if got := status.Code(err); got != tt.wantC {
+				t.Errorf("LotsOfReplies code = %s, want %s (err %v)", got, tt.wantC, err)
+			}
+			if got := errs.Reason(err); got != tt.wantReason {
+				t.Errorf("LotsOfReplies reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
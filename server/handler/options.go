@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"github.com/keepon-online/go-grpc-example/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"time"
+)
+
+// ServerOptions 按配置设置服务端的保活、连接存活时间和消息大小
+func ServerOptions(cfg config.Server) []grpc.ServerOption {
+	k := cfg.Keepalive.WithDefaults()
+	size := cfg.MaxMessageSize.WithDefaults()
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  k.Time,
+			Timeout:               k.Timeout,
+			MaxConnectionIdle:     unlimited(k.MaxConnectionIdle),
+			MaxConnectionAge:      unlimited(k.MaxConnectionAge),
+			MaxConnectionAgeGrace: k.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             k.MinTime,
+			PermitWithoutStream: k.PermitWithoutStream,
+		}),
+		grpc.MaxRecvMsgSize(size.Recv),
+		grpc.MaxSendMsgSize(size.Send),
+	}
+}
+
+// unlimited 配置中小于 0 表示不限制，gRPC 中对应 0
+func unlimited(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,62 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerOptionsMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    config.MessageSize
+		message string
+		wantC   codes.Code
+	}{
+		{name: "within limits", size: config.MessageSize{Recv: 1024, Send: 1024}, message: strings.Repeat("x", 512)},
+		{name: "request too large", size: config.MessageSize{Recv: 1024}, message: strings.Repeat("x", 2048), wantC: codes.ResourceExhausted},
+		{name: "response too large", size: config.MessageSize{Send: 1024}, message: strings.Repeat("x", 2048), wantC: codes.ResourceExhausted},
+		{name: "default allows more than 4MB", message: strings.Repeat("x", 5<<20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithServerConfig(config.Server{MaxMessageSize: tt.size}))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// 客户端接收的限制放开，只测试服务端的限制
+			_, err := s.Hello.SayHello(ctx, &hello.HelloRequest{Name: "a", Message: tt.message}, grpc.MaxCallRecvMsgSize(16<<20))
+			if status.Code(err) != tt.wantC {
+				t.Errorf("SayHello = %v, want %v", err, tt.wantC)
+			}
+		})
+	}
+}
+
+func TestServerOptionsMaxConnectionAge(t *testing.T) {
+	s := servertest.Start(t, servertest.WithServerConfig(config.Server{Keepalive: config.ServerKeepalive{
+		MaxConnectionAge:      50 * time.Millisecond,
+		MaxConnectionAgeGrace: 100 * time.Millisecond,
+	}}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 超过存活时间和等待时间的流被关闭
+	stream, err := s.Hello.BidiHello(ctx)
+	if err != nil {
+		t.Fatalf("BidiHello: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Recv = %v, want Unavailable", err)
+	}
+	// 同一个 ClientConn 重新建立连接，之后的调用不受影响
+	if _, err := s.Hello.SayHello(ctx, &hello.HelloRequest{Name: "a"}, grpc.WaitForReady(true)); err != nil {
+		t.Errorf("SayHello after GOAWAY: %v", err)
+	}
+}
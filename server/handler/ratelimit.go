@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataClientIP 网关转发的客户端IP，只在调用来自 RateLimit.TrustedProxies 时使用
+const MetadataClientIP = "x-client-ip"
+
+const (
+	KeyByMethod    = "method"
+	KeyByPrincipal = "principal"
+	KeyByIP        = "ip"
+	KeyByTenant    = "tenant"
+)
+
+// 并发数超限时建议客户端的重试间隔
+const concurrencyRetryDelay = time.Second
+
+// Limiter 限流状态存储
+// MemoryLimiter 是单实例的内存实现，多实例部署时可以实现该接口接入 redis 等共享存储
+type Limiter interface {
+	// Allow 从 key 对应的令牌桶中取一个令牌，取不到时返回需要等待的时间
+	Allow(ctx context.Context, key string, rate float64, burst int) (ok bool, retryAfter time.Duration, err error)
+	// Acquire 占用 key 的一个并发名额，成功时返回释放名额的函数
+	Acquire(ctx context.Context, key string, limit int) (release func(), ok bool, err error)
+}
+
+// 空闲超过该时间的令牌桶会被清理
+const bucketIdleTimeout = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter 基于内存的令牌桶和并发计数
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	active  map[string]int
+	sweep   time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[string]*bucket),
+		active:  make(map[string]int),
+		sweep:   time.Now(),
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	// 按流逝的时间补充令牌
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+func (l *MemoryLimiter) Acquire(ctx context.Context, key string, limit int) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[key] >= limit {
+		return nil, false, nil
+	}
+	l.active[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.active[key]--; l.active[key] <= 0 {
+				delete(l.active, key)
+			}
+		})
+	}, true, nil
+}
+
+// sweepLocked 定期清理长时间没有访问的令牌桶，避免按IP限流时内存无限增长
+func (l *MemoryLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.sweep) < bucketIdleTimeout {
+		return
+	}
+	l.sweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ServerInterceptorRateLimit 一元拦截器实现限流，需要放在认证和租户拦截器之后才能按用户、租户限流，健康检查不限流
+func ServerInterceptorRateLimit(cfg config.RateLimit, limiter Limiter) grpc.UnaryServerInterceptor {
+	proxies := parseProxies(cfg.TrustedProxies)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if !cfg.Enabled || isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		release, err := checkRateLimit(ctx, cfg, proxies, limiter, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptorRateLimit 流式拦截器实现限流，并发名额在流结束时释放
+func StreamServerInterceptorRateLimit(cfg config.RateLimit, limiter Limiter) grpc.StreamServerInterceptor {
+	proxies := parseProxies(cfg.TrustedProxies)
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !cfg.Enabled || isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		release, err := checkRateLimit(ss.Context(), cfg, proxies, limiter, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+// checkRateLimit 依次检查命中的规则，任意一条被拒绝都会释放已经占用的并发名额
+func checkRateLimit(ctx context.Context, cfg config.RateLimit, proxies []*net.IPNet, limiter Limiter, method string) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for i, rule := range cfg.Rules {
+		if rule.Method != "" && rule.Method != "*" && rule.Method != method {
+			continue
+		}
+		if rule.Tenant != "" && rule.Tenant != TenantFromContext(ctx) {
+			continue
+		}
+		// 存储中的 key 带上规则的下标，返回给客户端的只有限流对象
+		subject := rateLimitSubject(ctx, rule.KeyBy, method, proxies)
+		key := fmt.Sprintf("%d|%s", i, subject)
+		if rule.Rate > 0 {
+			ok, wait, err := limiter.Allow(ctx, key, rule.Rate, rule.Burst)
+			if err != nil {
+				// 限流存储不可用时放行，不影响正常业务
+				log.Printf("rate limit backend error: tenant %s %s: %v", TenantFromContext(ctx), method, err)
+				continue
+			}
+			if !ok {
+				release()
+				return nil, resourceExhausted(subject, fmt.Sprintf("超过速率限制 %v/s", rule.Rate), wait)
+			}
+		}
+		if rule.MaxConcurrent > 0 {
+			r, ok, err := limiter.Acquire(ctx, key, rule.MaxConcurrent)
+			if err != nil {
+				log.Printf("rate limit backend error: tenant %s %s: %v", TenantFromContext(ctx), method, err)
+				continue
+			}
+			if !ok {
+				release()
+				return nil, resourceExhausted(subject, fmt.Sprintf("超过最大并发数 %d", rule.MaxConcurrent), concurrencyRetryDelay)
+			}
+			releases = append(releases, r)
+		}
+	}
+	return release, nil
+}
+
+// rateLimitSubject 根据限流维度计算限流对象
+func rateLimitSubject(ctx context.Context, keyBy, method string, proxies []*net.IPNet) string {
+	switch keyBy {
+	case KeyByPrincipal:
+		// 不同租户中的同名用户分别限流
+		if claims, ok := ClaimsFromContext(ctx); ok {
+			return "principal:" + TenantFromContext(ctx) + "/" + claims.Username
+		}
+		return "principal:" + TenantFromContext(ctx) + "/anonymous"
+	case KeyByTenant:
+		return "tenant:" + TenantFromContext(ctx)
+	case KeyByIP:
+		return "ip:" + clientIP(ctx, proxies)
+	default:
+		return "method:" + method
+	}
+}
+
+// clientIP 调用方的IP，来自可信代理的调用使用代理转发的 x-client-ip
+func clientIP(ctx context.Context, proxies []*net.IPNet) string {
+	addr, err := getClientIP(ctx)
+	if err != nil {
+		return "unknown"
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !containsIP(proxies, ip) {
+		return addr
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	// 多个值时使用最后一个，即离服务端最近的代理设置的值
+	if v := md.Get(MetadataClientIP); len(v) > 0 && net.ParseIP(v[len(v)-1]) != nil {
+		return v[len(v)-1]
+	}
+	return addr
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxies 解析可信代理，限流和令牌接口共用，单个IP按 /32 或 /128 处理，不合法的配置记录日志后忽略
+func parseProxies(proxies []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Printf("invalid trusted proxy %q", p)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// resourceExhausted 构造限流错误，附带重试间隔和配额信息
+func resourceExhausted(subject, description string, retryAfter time.Duration) error {
+	return errs.ErrRateLimited.WithDetails(
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter),
+		},
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     subject,
+				Description: description,
+			}},
+		},
+	)
+}
@@ -0,0 +1,225 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterAllow(t *testing.T) {
+	l := handler.NewMemoryLimiter()
+	ctx := context.Background()
+	// 突发 2 次，之后每 10ms 补充一个令牌
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow(ctx, "a", 100, 2); !ok {
+			t.Fatalf("call %d limited", i)
+		}
+	}
+	ok, wait, err := l.Allow(ctx, "a", 100, 2)
+	if ok || err != nil {
+		t.Fatalf("third call = %v, %v, want limited", ok, err)
+	}
+	if wait <= 0 || wait > 10*time.Millisecond {
+		t.Errorf("retry after %v, want (0, 10ms]", wait)
+	}
+	// 不同的 key 互不影响
+	if ok, _, _ := l.Allow(ctx, "b", 100, 2); !ok {
+		t.Error("other key limited")
+	}
+	time.Sleep(wait + 5*time.Millisecond)
+	if ok, _, _ := l.Allow(ctx, "a", 100, 2); !ok {
+		t.Error("token not refilled")
+	}
+}
+
+func TestMemoryLimiterAcquire(t *testing.T) {
+	l := handler.NewMemoryLimiter()
+	ctx := context.Background()
+	r1, ok, _ := l.Acquire(ctx, "a", 2)
+	if !ok {
+		t.Fatal("first acquire failed")
+	}
+	if _, ok, _ := l.Acquire(ctx, "a", 2); !ok {
+		t.Fatal("second acquire failed")
+	}
+	if _, ok, _ := l.Acquire(ctx, "a", 2); ok {
+		t.Fatal("third acquire succeeded")
+	}
+	// 重复释放只生效一次
+	r1()
+	r1()
+	if _, ok, _ := l.Acquire(ctx, "a", 2); !ok {
+		t.Fatal("acquire after release failed")
+	}
+	if _, ok, _ := l.Acquire(ctx, "a", 2); ok {
+		t.Error("double release freed two slots")
+	}
+}
+
+// peerContext 模拟来自 addr 的调用
+func peerContext(addr string, md metadata.MD) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 5000}})
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func okHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return "ok", nil
+}
+
+func TestRateLimitDetails(t *testing.T) {
+	cfg := config.RateLimit{Enabled: true, Rules: []config.RateLimitRule{
+		{Method: "*", KeyBy: handler.KeyByIP, Rate: 0.5, Burst: 1},
+	}}
+	interceptor := handler.ServerInterceptorRateLimit(cfg, handler.NewMemoryLimiter())
+	info := &grpc.UnaryServerInfo{FullMethod: "/hello.v1.HelloService/SayHello"}
+	ctx := peerContext("10.0.0.1", nil)
+	if _, err := interceptor(ctx, nil, info, okHandler); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	_, err := interceptor(ctx, nil, info, okHandler)
+	if status.Code(err) != codes.ResourceExhausted || !errs.IsReason(err, errs.ReasonRateLimited) {
+		t.Fatalf("second call = %v, want %s", err, errs.ReasonRateLimited)
+	}
+	var retry *errdetails.RetryInfo
+	var quota *errdetails.QuotaFailure
+	for _, d := range status.Convert(err).Details() {
+		switch d := d.(type) {
+		case *errdetails.RetryInfo:
+			retry = d
+		case *errdetails.QuotaFailure:
+			quota = d
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() <= 0 || retry.GetRetryDelay().AsDuration() > 2*time.Second {
+		t.Errorf("RetryInfo = %v, want (0, 2s]", retry)
+	}
+	// 返回给客户端的只有限流对象，没有规则的下标
+	if quota == nil || len(quota.GetViolations()) != 1 || quota.GetViolations()[0].GetSubject() != "ip:10.0.0.1" {
+		t.Errorf("QuotaFailure = %v, want subject ip:10.0.0.1", quota)
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		peer    string
+		md      metadata.MD
+		want    string
+	}{
+		{name: "direct", peer: "10.0.0.1", want: "ip:10.0.0.1"},
+		// 默认配置不信任任何地址，本机连接也不能伪造客户端IP
+		{name: "default config", proxies: config.Default().RateLimit.TrustedProxies, peer: "127.0.0.1",
+			md: metadata.Pairs(handler.MetadataClientIP, "1.2.3.4"), want: "ip:127.0.0.1"},
+		{name: "untrusted peer", proxies: []string{"127.0.0.1"}, peer: "10.0.0.1",
+			md: metadata.Pairs(handler.MetadataClientIP, "1.2.3.4"), want: "ip:10.0.0.1"},
+		{name: "trusted proxy", proxies: []string{"127.0.0.1"}, peer: "127.0.0.1",
+			md: metadata.Pairs(handler.MetadataClientIP, "1.2.3.4"), want: "ip:1.2.3.4"},
+		{name: "trusted cidr", proxies: []string{"192.168.0.0/16"}, peer: "192.168.2.166",
+			md: metadata.Pairs(handler.MetadataClientIP, "1.2.3.4"), want: "ip:1.2.3.4"},
+		{name: "last value wins", proxies: []string{"127.0.0.1"}, peer: "127.0.0.1",
+			md: metadata.Pairs(handler.MetadataClientIP, "9.9.9.9", handler.MetadataClientIP, "1.2.3.4"), want: "ip:1.2.3.4"},
+		{name: "invalid client ip", proxies: []string{"127.0.0.1"}, peer: "127.0.0.1",
+			md: metadata.Pairs(handler.MetadataClientIP, "not-an-ip"), want: "ip:127.0.0.1"},
+		{name: "proxy without client ip", proxies: []string{"127.0.0.1", "bad/cidr"}, peer: "127.0.0.1", want: "ip:127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.RateLimit{Enabled: true, TrustedProxies: tt.proxies, Rules: []config.RateLimitRule{
+				{KeyBy: handler.KeyByIP, Rate: 0.001, Burst: 1},
+			}}
+			interceptor := handler.ServerInterceptorRateLimit(cfg, handler.NewMemoryLimiter())
+			info := &grpc.UnaryServerInfo{FullMethod: "/m"}
+			ctx := peerContext(tt.peer, tt.md)
+			interceptor(ctx, nil, info, okHandler)
+			_, err := interceptor(ctx, nil, info, okHandler)
+			var subject string
+			for _, d := range status.Convert(err).Details() {
+				if q, ok := d.(*errdetails.QuotaFailure); ok && len(q.GetViolations()) > 0 {
+					subject = q.GetViolations()[0].GetSubject()
+				}
+			}
+			if subject != tt.want {
+				t.Errorf("subject = %q, want %q (err %v)", subject, tt.want, err)
+			}
+		})
+	}
+}
+
+// 后面的规则拒绝时，前面规则占用的并发名额要释放
+func TestRateLimitReleaseOnReject(t *testing.T) {
+	cfg := config.RateLimit{Enabled: true, Rules: []config.RateLimitRule{
+		{Method: "*", KeyBy: handler.KeyByIP, MaxConcurrent: 1},
+		{Method: "/a", KeyBy: handler.KeyByIP, Rate: 0.001, Burst: 1},
+	}}
+	interceptor := handler.ServerInterceptorRateLimit(cfg, handler.NewMemoryLimiter())
+	ctx := peerContext("10.0.0.1", nil)
+	call := func(method string) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, okHandler)
+		return err
+	}
+	if err := call("/a"); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if err := call("/a"); !errs.IsReason(err, errs.ReasonRateLimited) {
+		t.Fatalf("second call = %v, want limited", err)
+	}
+	if err := call("/b"); err != nil {
+		t.Errorf("concurrency slot leaked: %v", err)
+	}
+}
+
+func TestRateLimitStreamConcurrency(t *testing.T) {
+	cfg := config.RateLimit{Enabled: true, Rules: []config.RateLimitRule{
+		{Method: "/hello.v1.HelloService/BidiHello", KeyBy: handler.KeyByPrincipal, MaxConcurrent: 1},
+	}}
+	s := servertest.Start(t, servertest.WithAuth(),
+		servertest.WithStreamInterceptors(handler.StreamServerInterceptorRateLimit(cfg, handler.NewMemoryLimiter())))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	open := func() (hello.HelloService_BidiHelloClient, error) {
+		stream, err := s.Hello.BidiHello(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&hello.HelloRequest{Name: "a"}); err != nil {
+			return nil, err
+		}
+		_, err = stream.Recv()
+		return stream, err
+	}
+	first, err := open()
+	if err != nil {
+		t.Fatalf("first stream: %v", err)
+	}
+	if _, err := open(); !errs.IsReason(err, errs.ReasonRateLimited) {
+		t.Fatalf("second stream = %v, want limited", err)
+	}
+	// 流结束后释放名额
+	first.CloseSend()
+	first.Recv()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := open()
+		if err == nil {
+			break
+		}
+		if !errs.IsReason(err, errs.ReasonRateLimited) || time.Now().After(deadline) {
+			t.Fatalf("stream after release: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
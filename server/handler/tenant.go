@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"expvar"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"regexp"
+)
+
+const (
+	// MetadataTenant 请求元数据中的租户，token 中有租户时必须和 token 一致
+	MetadataTenant = "tenant"
+	// DefaultTenant token 和元数据中都没有租户时使用的租户
+	DefaultTenant = "default"
+)
+
+// tenantPattern 租户名称会作为目录名，只允许字母、数字和 . _ -，且不能以 . 开头
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+var (
+	// tenantRequestsTotal 每个租户每个方法的调用次数，key 为 "租户 方法"
+	tenantRequestsTotal = expvar.NewMap("grpc_server_requests_total")
+	// tenantErrorsTotal 每个租户每个方法失败的调用次数，key 为 "租户 方法 状态码"
+	tenantErrorsTotal = expvar.NewMap("grpc_server_errors_total")
+)
+
+type tenantKey struct{}
+
+// NewContextWithTenant 将租户放入上下文
+func NewContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext 取出请求的租户，没有经过租户拦截器时返回 DefaultTenant
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// ServerInterceptorTenant 一元拦截器，确定请求的租户放入上下文，并按租户统计调用次数。
+// 需要放在认证拦截器之后、限流拦截器之前
+func ServerInterceptorTenant(cfg config.Tenants) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		tenant, err := resolveTenant(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { countTenant(tenant, info.FullMethod, err) }()
+		return handler(NewContextWithTenant(ctx, tenant), req)
+	}
+}
+
+// StreamServerInterceptorTenant 流式拦截器，流结束时统计
+func StreamServerInterceptorTenant(cfg config.Tenants) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		tenant, err := resolveTenant(ss.Context(), cfg)
+		if err != nil {
+			return err
+		}
+		defer func() { countTenant(tenant, info.FullMethod, err) }()
+		return handler(srv, newContextStream(ss, NewContextWithTenant(ss.Context(), tenant)))
+	}
+}
+
+// resolveTenant 按 token、元数据（TrustMetadata 时）、DefaultTenant 的顺序确定租户，
+// 元数据中的租户和确定的租户不一致时拒绝，避免调用方误以为访问的是元数据中的租户
+func resolveTenant(ctx context.Context, cfg config.Tenants) (string, error) {
+	var requested string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(MetadataTenant); len(v) > 0 {
+			requested = v[0]
+		}
+	}
+	tenant := DefaultTenant
+	if claims, ok := ClaimsFromContext(ctx); ok && claims.Tenant != "" {
+		tenant = claims.Tenant
+	} else if cfg.TrustMetadata && requested != "" {
+		tenant = requested
+	}
+	if !tenantPattern.MatchString(tenant) {
+		return "", errs.ErrTenantInvalid
+	}
+	if requested != "" && requested != tenant {
+		return "", errs.ErrTenantDenied.WithMetadata("tenant", tenant)
+	}
+	return tenant, nil
+}
+
+func countTenant(tenant, method string, err error) {
+	tenantRequestsTotal.Add(tenant+" "+method, 1)
+	if err != nil {
+		tenantErrorsTotal.Add(tenant+" "+method+" "+status.Code(err).String(), 1)
+	}
+}
@@ -0,0 +1,198 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tenantToken(t *testing.T, tenant string) string {
+	j := util.NewJWT([]byte(servertest.SigningKey))
+	claims := j.CreateClaims(util.BaseClaims{ID: 1, Username: "tenant-user"})
+	claims.Tenant = tenant
+	token, err := j.CreateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTenant(t *testing.T) {
+	tests := []struct {
+		name       string
+		trust      bool
+		token      string
+		tenant     string
+		want       string
+		wantReason string
+	}{
+		{name: "default", want: handler.DefaultTenant},
+		{name: "from token", token: "team-a", want: "team-a"},
+		{name: "metadata matches token", token: "team-a", tenant: "team-a", want: "team-a"},
+		{name: "metadata differs from token", token: "team-a", tenant: "team-b", trust: true, wantReason: errs.ReasonTenantDenied},
+		{name: "untrusted metadata", tenant: "team-b", wantReason: errs.ReasonTenantDenied},
+		{name: "trusted metadata", tenant: "team-b", trust: true, want: "team-b"},
+		{name: "invalid name", tenant: "../etc", trust: true, wantReason: errs.ReasonTenantInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			capture := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+				got = handler.TenantFromContext(ctx)
+				return h(ctx, req)
+			}
+			s := servertest.Start(t, servertest.WithAuth(), servertest.WithUnaryInterceptors(capture),
+				servertest.WithTenantsConfig(config.Tenants{TrustMetadata: tt.trust}))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			token := s.Token()
+			if tt.token != "" {
+				token = tenantToken(t, tt.token)
+			}
+			md := metadata.Pairs("token", token)
+			if tt.tenant != "" {
+				md.Set(handler.MetadataTenant, tt.tenant)
+			}
+			client := hello.NewHelloServiceClient(s.Dial(t))
+			_, err := client.SayHello(metadata.NewOutgoingContext(ctx, md), &hello.HelloRequest{Name: "a"})
+			if tt.wantReason != "" {
+				if !errs.IsReason(err, tt.wantReason) {
+					t.Errorf("SayHello = %v, want %s", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("tenant = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantRateLimit(t *testing.T) {
+	const method = "/hello.v1.HelloService/SayHello"
+	cfg := config.RateLimit{Enabled: true, Rules: []config.RateLimitRule{
+		// 每个租户单独的令牌桶
+		{Method: method, KeyBy: handler.KeyByTenant, Rate: 0.001, Burst: 2},
+		// 只限制 small 租户
+		{Method: method, KeyBy: handler.KeyByTenant, Rate: 0.001, Burst: 1, Tenant: "small"},
+	}}
+	s := servertest.Start(t,
+		servertest.WithTenantsConfig(config.Tenants{TrustMetadata: true}),
+		servertest.WithUnaryInterceptors(handler.ServerInterceptorRateLimit(cfg, handler.NewMemoryLimiter())))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 指标是全局的，比较调用前后的差值
+	requests := expvar.Get("grpc_server_requests_total").(*expvar.Map)
+	failures := expvar.Get("grpc_server_errors_total").(*expvar.Map)
+	count := func(m *expvar.Map, key string) int64 {
+		if v, ok := m.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	requestsBefore := count(requests, "small "+method)
+	failuresBefore := count(failures, "small "+method+" ResourceExhausted")
+
+	call := func(tenant string) error {
+		ctx := metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, tenant)
+		_, err := s.Hello.SayHello(ctx, &hello.HelloRequest{Name: "a"})
+		return err
+	}
+	tests := []struct {
+		tenant  string
+		limited bool
+	}{
+		{tenant: "big"},
+		{tenant: "big"},
+		{tenant: "big", limited: true},
+		// 其他租户用完令牌不影响
+		{tenant: "small"},
+		{tenant: "small", limited: true},
+	}
+	for i, tt := range tests {
+		if err := call(tt.tenant); errs.IsReason(err, errs.ReasonRateLimited) != tt.limited {
+			t.Errorf("call %d for %s = %v, want limited %v", i, tt.tenant, err, tt.limited)
+		}
+	}
+
+	if n := count(requests, "small "+method) - requestsBefore; n != 2 {
+		t.Errorf("requests for small = %d, want 2", n)
+	}
+	if n := count(failures, "small "+method+" ResourceExhausted") - failuresBefore; n != 1 {
+		t.Errorf("errors for small = %d, want 1", n)
+	}
+}
+
+// failingLimiter 模拟不可用的限流存储
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, float64, int) (bool, time.Duration, error) {
+	return false, 0, errors.New("backend down")
+}
+
+func (failingLimiter) Acquire(context.Context, string, int) (func(), bool, error) {
+	return nil, false, errors.New("backend down")
+}
+
+// 拦截器的日志中带有租户和方法
+func TestTenantInLogs(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/hello.v1.HelloService/SayHello"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+	tests := []struct {
+		name        string
+		interceptor grpc.UnaryServerInterceptor
+		req         interface{}
+		handler     grpc.UnaryHandler
+		want        string
+	}{
+		{
+			name:        "panic",
+			interceptor: handler.GrpcRecover(),
+			handler:     func(context.Context, interface{}) (interface{}, error) { panic("boom") },
+			want:        "server panic in /hello.v1.HelloService/SayHello, tenant team-a: boom",
+		},
+		{
+			name: "rate limit backend",
+			interceptor: handler.ServerInterceptorRateLimit(config.RateLimit{Enabled: true, Rules: []config.RateLimitRule{
+				{Method: "*", KeyBy: handler.KeyByTenant, Rate: 1, Burst: 1},
+			}}, failingLimiter{}),
+			handler: ok,
+			want:    "rate limit backend error: tenant team-a /hello.v1.HelloService/SayHello: backend down",
+		},
+		{
+			name:        "validate",
+			interceptor: handler.ServerInterceptorValidate(),
+			req:         &hello.HelloRequest{},
+			handler:     ok,
+			want:        "validate: tenant team-a /hello.v1.HelloService/SayHello: name:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			ctx := handler.NewContextWithTenant(context.Background(), "team-a")
+			tt.interceptor(ctx, tt.req, info, tt.handler)
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("log = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/util"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenResponse 令牌接口的响应，字段与 OAuth2 client_credentials 一致
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// TokenHandler 令牌接口，客户端用 HTTP Basic 认证传递 client_id 和 client_secret，
+// 签名密钥只保存在服务端，客户端不再自己签发token。
+// client_secret 是明文传输的，没有开启 AllowInsecure 时拒绝不经过 TLS 的请求
+func TokenHandler(cfg config.Auth) http.Handler {
+	j := util.NewJWT([]byte(cfg.SigningKey))
+	proxies := parseProxies(cfg.TrustedProxies)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.AllowInsecure && !isHTTPS(r, proxies) {
+			writeTokenError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeTokenError(w, http.StatusMethodNotAllowed, "invalid_request")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeTokenError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		if gt := r.PostForm.Get("grant_type"); gt != "client_credentials" {
+			writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+		id, secret, ok := r.BasicAuth()
+		if ok {
+			// RFC 6749 要求 Basic 认证中的 client_id、client_secret 先做表单编码
+			id, _ = url.QueryUnescape(id)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		client, ok := findClient(cfg.Clients, id, secret)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+
+		claims := j.CreateClaims(util.BaseClaims{ID: client.UserID, Username: client.Username})
+		claims.Tenant = client.Tenant
+		ttl := cfg.TokenTTL
+		if ttl <= 0 {
+			ttl = time.Hour
+		}
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl))
+		token, err := j.CreateToken(claims)
+		if err != nil {
+			log.Printf("create token for client %s failed: %v", id, err)
+			writeTokenError(w, http.StatusInternalServerError, "server_error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(tokenResponse{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(ttl / time.Second),
+		})
+	})
+}
+
+// isHTTPS 请求通过 TLS 到达网关，或者由可信的终止 TLS 的代理转发。
+// 任何客户端都可以设置 X-Forwarded-Proto，只有来自 proxies 的请求才使用
+func isHTTPS(r *http.Request, proxies []*net.IPNet) bool {
+	if r.TLS != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && containsIP(proxies, ip) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// findClient 比较密钥时使用固定时间的比较，避免通过响应时间猜测密钥
+func findClient(clients []config.AuthClient, id, secret string) (config.AuthClient, bool) {
+	for _, c := range clients {
+		if c.ID == id && subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1 {
+			return c, true
+		}
+	}
+	return config.AuthClient{}, false
+}
+
+func writeTokenError(w http.ResponseWriter, code int, errCode string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": errCode})
+}
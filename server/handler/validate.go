@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/gen/hello/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"log"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+)
+
+// ServerInterceptorValidate 按 proto 中的 (validate.v1.field) 规则校验请求，
+// 不通过时返回 InvalidArgument 和 errdetails.BadRequest
+func ServerInterceptorValidate() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if err := validateRequest(ctx, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptorValidate 校验流中收到的每一条消息
+func StreamServerInterceptorValidate() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validateStream{ServerStream: ss, method: info.FullMethod})
+	}
+}
+
+type validateStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s *validateStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return validateRequest(s.Context(), s.method, m)
+}
+
+// validateRequest 校验不通过时记录租户、方法和第一个不符合规则的字段
+func validateRequest(ctx context.Context, method string, req interface{}) error {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	violations := Validate(msg)
+	if len(violations) == 0 {
+		return nil
+	}
+	log.Printf("validate: tenant %s %s: %s: %s", TenantFromContext(ctx), method, violations[0].Field, violations[0].Description)
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("请求参数错误: %s: %s", violations[0].Field, violations[0].Description))
+	ds, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return ds.Err()
+}
+
+// Validate 返回消息中所有不符合规则的字段，字段名为 proto 中的名字，嵌套字段用 . 连接
+func Validate(msg proto.Message) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	validateMessage(msg.ProtoReflect(), "", &violations)
+	return violations
+}
+
+func validateMessage(m protoreflect.Message, prefix string, violations *[]*errdetails.BadRequest_FieldViolation) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		path := prefix + string(fd.Name())
+		rules, _ := proto.GetExtension(fd.Options(), validate.E_Field).(*validate.FieldConstraints)
+		if rules != nil {
+			for _, desc := range checkField(m, fd, rules) {
+				*violations = append(*violations, &errdetails.BadRequest_FieldViolation{Field: path, Description: desc})
+			}
+		}
+		// 继续校验嵌套的消息，skip 的字段由服务自己校验
+		if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind || !m.Has(fd) || rules.GetSkip() {
+			continue
+		}
+		switch {
+		case fd.IsList():
+			list := m.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				validateMessage(list.Get(j).Message(), fmt.Sprintf("%s[%d].", path, j), violations)
+			}
+		case fd.IsMap():
+			// map 的值暂不校验
+		default:
+			validateMessage(m.Get(fd).Message(), path+".", violations)
+		}
+	}
+}
+
+func checkField(m protoreflect.Message, fd protoreflect.FieldDescriptor, rules *validate.FieldConstraints) []string {
+	if fd.IsList() || fd.IsMap() {
+		if rules.GetRequired() && !m.Has(fd) {
+			return []string{"不能为空"}
+		}
+		return nil
+	}
+	var desc []string
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		v := m.Get(fd).String()
+		if rules.GetRequired() && v == "" {
+			return []string{"不能为空"}
+		}
+		if r := rules.GetString_(); r != nil {
+			desc = checkString(fd, v, r)
+		}
+	case protoreflect.BytesKind:
+		v := m.Get(fd).Bytes()
+		if rules.GetRequired() && len(v) == 0 {
+			return []string{"不能为空"}
+		}
+		if r := rules.GetBytes(); r != nil {
+			if r.MinLen != nil && uint64(len(v)) < r.GetMinLen() {
+				desc = append(desc, fmt.Sprintf("长度不能少于 %d 字节", r.GetMinLen()))
+			}
+			if r.MaxLen != nil && uint64(len(v)) > r.GetMaxLen() {
+				desc = append(desc, fmt.Sprintf("长度不能超过 %d 字节", r.GetMaxLen()))
+			}
+		}
+	default:
+		if rules.GetRequired() && !m.Has(fd) {
+			return []string{"不能为空"}
+		}
+	}
+	return desc
+}
+
+func checkString(fd protoreflect.FieldDescriptor, v string, r *validate.StringRules) []string {
+	var desc []string
+	n := uint64(utf8.RuneCountInString(v))
+	if r.MinLen != nil && n < r.GetMinLen() {
+		desc = append(desc, fmt.Sprintf("长度不能少于 %d 个字符", r.GetMinLen()))
+	}
+	if r.MaxLen != nil && n > r.GetMaxLen() {
+		desc = append(desc, fmt.Sprintf("长度不能超过 %d 个字符", r.GetMaxLen()))
+	}
+	if r.MaxBytes != nil && uint64(len(v)) > r.GetMaxBytes() {
+		desc = append(desc, fmt.Sprintf("长度不能超过 %d 字节", r.GetMaxBytes()))
+	}
+	if r.Pattern != nil {
+		if re := compilePattern(fd, r.GetPattern()); re != nil && !re.MatchString(v) {
+			desc = append(desc, fmt.Sprintf("格式不正确，需要匹配 %s", r.GetPattern()))
+		}
+	}
+	for _, s := range r.GetNotIn() {
+		if v == s {
+			desc = append(desc, fmt.Sprintf("不能是 %q", s))
+			break
+		}
+	}
+	return desc
+}
+
+// patterns 按字段缓存编译后的正则
+var patterns sync.Map
+
+func compilePattern(fd protoreflect.FieldDescriptor, pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(fd.FullName()); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		// proto 中的规则写错了，不能因此拒绝所有请求
+		log.Printf("validate: invalid pattern %q on %s: %v", pattern, fd.FullName(), err)
+		return nil
+	}
+	patterns.Store(fd.FullName(), re)
+	return re
+}
@@ -0,0 +1,134 @@
+package handler_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  proto.Message
+		want []string
+	}{
+		{name: "valid hello", msg: &hello.HelloRequest{Name: "鲁迪", Message: "ok"}},
+		{name: "missing name", msg: &hello.HelloRequest{Message: "ok"}, want: []string{"name"}},
+		{name: "name too long", msg: &hello.HelloRequest{Name: strings.Repeat("名", 65)}, want: []string{"name"}},
+		{name: "name with 64 runes", msg: &hello.HelloRequest{Name: strings.Repeat("名", 64)}},
+		{name: "control character", msg: &hello.HelloRequest{Name: "a\nb"}, want: []string{"name"}},
+		{name: "message too long", msg: &hello.HelloRequest{Name: "a", Message: strings.Repeat("x", 1025)}, want: []string{"message"}},
+		{name: "both invalid", msg: &hello.HelloRequest{Message: strings.Repeat("x", 1025)}, want: []string{"name", "message"}},
+		{name: "valid file", msg: &hello.FileRequest{FileName: "upload.txt"}},
+		{name: "missing file name", msg: &hello.FileRequest{Content: []byte("x")}, want: []string{"file_name"}},
+		{name: "path in file name", msg: &hello.FileRequest{FileName: "../etc/passwd"}, want: []string{"file_name"}},
+		{name: "windows path", msg: &hello.FileRequest{FileName: `a\b`}, want: []string{"file_name"}},
+		{name: "dot dot", msg: &hello.FileRequest{FileName: ".."}, want: []string{"file_name"}},
+		{name: "no rules", msg: &hello.HelloResponse{}},
+		{name: "skipped nested message", msg: &hello.BatchGreetingRequest{Item: &hello.HelloRequest{}}},
+		{name: "field next to skipped message", msg: &hello.BatchGreetingRequest{IdempotencyKey: "a\nb", Item: &hello.HelloRequest{}}, want: []string{"idempotency_key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range handler.Validate(tt.msg) {
+				got = append(got, v.GetField())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("violations on %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// badRequestFields 返回错误中 BadRequest 详情的字段
+func badRequestFields(t *testing.T, err error) []string {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("code = %s, want %s (err %v)", st.Code(), codes.InvalidArgument, err)
+	}
+	var fields []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	if len(fields) == 0 {
+		t.Fatalf("no BadRequest details in %v", err)
+	}
+	return fields
+}
+
+func TestValidateInterceptor(t *testing.T) {
+	s := servertest.Start(t,
+		servertest.WithUnaryInterceptors(handler.ServerInterceptorValidate()),
+		servertest.WithStreamInterceptors(handler.StreamServerInterceptorValidate()),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("unary", func(t *testing.T) {
+		if _, err := s.Hello.SayHello(ctx, &hello.HelloRequest{Name: "a"}); err != nil {
+			t.Fatalf("valid request: %v", err)
+		}
+		_, err := s.Hello.SayHello(ctx, &hello.HelloRequest{})
+		if got := badRequestFields(t, err); got[0] != "name" {
+			t.Errorf("fields = %q", got)
+		}
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		stream, err := s.Hello.LotsOfReplies(ctx, &hello.HelloRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		badRequestFields(t, err)
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		stream, err := s.File.UploadFile(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 第一条合法，第二条的文件名包含路径
+		for _, name := range []string{"a.txt", "../a.txt"} {
+			if err := stream.Send(&hello.FileRequest{FileName: name}); err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		if got := badRequestFields(t, err); got[0] != "file_name" {
+			t.Errorf("fields = %q", got)
+		}
+	})
+
+	t.Run("bidi stream", func(t *testing.T) {
+		stream, err := s.Hello.BidiHello(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(&hello.HelloRequest{Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatalf("valid message: %v", err)
+		}
+		if err := stream.Send(&hello.HelloRequest{Message: "no name"}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Recv()
+		badRequestFields(t, err)
+	})
+}
@@ -3,106 +3,39 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"flag"
 	"fmt"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	clientconn "github.com/keepon-online/go-grpc-example/client/conn"
+	"github.com/keepon-online/go-grpc-example/config"
 	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/admin"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
 	"github.com/keepon-online/go-grpc-example/server/handler"
 	"github.com/keepon-online/go-grpc-example/server/service"
 	"github.com/keepon-online/go-grpc-example/util"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
-	"io"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"log"
 	"net"
 	"net/http"
+	"time"
 )
 
-// HelloServer HelloServer 实现HelloServiceServer
-type HelloServer struct {
-	hello.UnimplementedHelloServiceServer
-}
-
-func (s HelloServer) SayHello(ctx context.Context, request *hello.HelloRequest) (pd *hello.HelloResponse, err error) {
-	fmt.Println("入参：", request.Name, request.Message)
-	return &hello.HelloResponse{
-		Name:    request.Name,
-		Message: request.Message,
-	}, nil
-}
-
-// LotsOfReplies 服务端返回流
-func (s HelloServer) LotsOfReplies(in *hello.HelloRequest, stream hello.HelloService_LotsOfRepliesServer) error {
-	words := []string{
-		"你好",
-		"hello",
-		"こんにちは",
-		"안녕하세요",
-	}
-	for _, word := range words {
-		data := &hello.HelloResponse{
-			Name: in.Name + word,
-		}
-		// 使用Send方法返回多个数据
-		if err := stream.Send(data); err != nil {
-			return err
-		}
-	}
-	return nil
-}
+var configFile = flag.String("config", "conf/config.yaml", "配置文件路径")
 
-// LotsOfGreetings 接收流式数据
-func (s HelloServer) LotsOfGreetings(stream hello.HelloService_LotsOfGreetingsServer) error {
-	reply := "你好："
-	for {
-		// 接收客户端发来的流式数据
-		res, err := stream.Recv()
-		if err == io.EOF {
-			// 最终统一回复
-			return stream.SendAndClose(&hello.HelloResponse{
-				Name: reply,
-			})
-		}
-		if err != nil {
-			return err
-		}
-		reply += res.GetName()
+func main() {
+	flag.Parse()
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config %s: %v", *configFile, err)
 	}
-}
-
-// BidiHello 双向流数据
-func (s HelloServer) BidiHello(stream hello.HelloService_BidiHelloServer) error {
-	for {
-		// 接收流式请求
-		in, err := stream.Recv()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-		reply := in.GetName() // 对收到的数据做些处理
-
-		// 返回流式响应
-		if err := stream.Send(&hello.HelloResponse{Name: reply}); err != nil {
-			return err
-		}
+	if len(cfg.Auth.SigningKey) < util.MinSigningKeyLen {
+		log.Fatalf("auth.signingKey must be at least %d bytes", util.MinSigningKeyLen)
 	}
-}
-
-type GateWayServer struct {
-	hello.UnimplementedGatewayServiceServer
-}
-
-func (g GateWayServer) SayMessage(ctx context.Context, request *hello.HelloRequest) (*hello.HelloResponse, error) {
-	log.Printf("SayMessage %v\n", request)
-	return &hello.HelloResponse{
-		Message: request.GetMessage(),
-		Name:    request.GetName(),
-	}, nil
-}
-
-func main() {
 	// 监听端口
 	listen, err := net.Listen("tcp", ":8080")
 	if err != nil {
@@ -110,31 +43,63 @@ func main() {
 	}
 	creds, _ := credentials.NewServerTLSFromFile("conf/server.crt", "conf/server.key")
 
+	// 限流状态，多实例部署时可替换为共享存储实现
+	limiter := handler.NewMemoryLimiter()
+	// 进行中的流，管理端口中按方法列出
+	streams := admin.NewStreams()
+
 	// 创建一个gRPC服务器实例。
-	s := grpc.NewServer(
+	s := grpc.NewServer(append(handler.ServerOptions(cfg.Server),
 		grpc.Creds(creds),
 		grpc.ChainUnaryInterceptor(
-			handler.ServerInterceptorCheckToken(),
+			handler.ServerInterceptorCheckToken(cfg.Auth),
+			handler.ServerInterceptorTenant(cfg.Tenants),
 			handler.AuthenticateInterceptor,
+			handler.ServerInterceptorRateLimit(cfg.RateLimit, limiter),
+			handler.ServerInterceptorValidate(),
+			handler.ServerInterceptorDeadline(cfg.Deadline),
 			handler.GrpcRecover(),
 			//handler.UnaryServerInterceptor()
 		),
-		//grpc.StreamInterceptor(handler.StreamServerInterceptor()),
-	)
+		grpc.ChainStreamInterceptor(
+			handler.StreamServerInterceptorCheckToken(cfg.Auth),
+			handler.StreamServerInterceptorTenant(cfg.Tenants),
+			streams.StreamServerInterceptor(),
+			handler.StreamServerInterceptorRateLimit(cfg.RateLimit, limiter),
+			handler.StreamServerInterceptorValidate(),
+			handler.StreamServerInterceptorDeadline(cfg.Deadline),
+			//handler.StreamServerInterceptor(),
+		),
+	)...)
 	// 将server结构体注册为gRPC服务。
-	hello.RegisterHelloServiceServer(s, &HelloServer{})
-	hello.RegisterGatewayServiceServer(s, &GateWayServer{})
-	hello.RegisterFileServiceServer(s, &service.FileServer{})
+	hello.RegisterHelloServiceServer(s, &service.HelloServer{
+		Rooms: service.NewRooms(cfg.Chat),
+		Batch: service.NewBatch(cfg.Batch),
+	})
+	hello.RegisterGatewayServiceServer(s, &service.GateWayServer{})
+	// 上传的文件按租户分目录保存
+	hello.RegisterFileServiceServer(s, &service.FileServer{Root: cfg.Files.Root, Quotas: cfg.Tenants.Quotas})
+	// 事件保存在内存中，需要持久化或多实例共享时传入其他 EventStore 实现
+	hello.RegisterEventServiceServer(s, service.NewEventServer(nil, cfg.Events))
+	// 标准健康检查服务，客户端负载均衡根据它剔除不健康的后端
+	healthpb.RegisterHealthServer(s, health.NewServer())
 	fmt.Println("grpc server running :8080")
 	//tlsConfig := util.GetTLSConfig("conf/server.crt", "conf/server.key")
 	// NewListener将会创建一个Listener
 	// 它接受两个参数，第一个是来自内部Listener的监听器，第二个参数是tls.Config（必须包含至少一个证书）
 	go func() {
 		//httpServer(s, ":8081", tlsConfig)
-		httpSe()
+		httpSe(s, cfg.Gateway, cfg.Auth)
 		log.Printf("----go httpServer---")
 
 	}()
+	if cfg.Admin.Enabled {
+		adminHandler, err := admin.NewHandler(cfg, streams)
+		if err != nil {
+			log.Fatalln("Failed to create admin handler:", err)
+		}
+		go serveAdmin(cfg.Admin.WithDefaults().Addr, adminHandler)
+	}
 	if err = s.Serve(listen); err != nil {
 		log.Printf("ListenAndServe: %v\n", err)
 	}
@@ -181,34 +146,46 @@ func httpServer(grpcServer *grpc.Server, EndPoint string, tlsConfig *tls.Config)
 
 }
 
-func httpSe() {
+func httpSe(grpcServer *grpc.Server, cfg config.Gateway, auth config.Auth) {
 	// 2. 启动 HTTP 服务
 	// Create a client connection to the gRPC server we just started
 	// This is where the gRPC-Gateway proxies the requests
 
 	// 从客户端的输入证书文件构造TLS凭证
-	dcreds, err := credentials.NewClientTLSFromFile("conf/server.crt", "")
+	dcreds, err := credentials.NewClientTLSFromFile("conf/server.crt", cfg.Backend.ServerName)
 	if err != nil {
 		log.Printf("Failed to create client TLS credentials %v", err)
 	}
-	conn, err := grpc.Dial(
-		fmt.Sprintf("192.168.2.166:%d", 8080),
+	// 和客户端一样支持多个后端的地址解析、负载均衡和健康检查
+	conn, err := clientconn.Dial(cfg.Backend,
 		grpc.WithTransportCredentials(dcreds),
 	)
 	if err != nil {
 		log.Fatalln("Failed to dial server:", err)
 	}
-	gwmux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(handler.CustomHeaderMatcher))
-	// Register Greeter
-	err = hello.RegisterGatewayServiceHandler(context.Background(), gwmux, conn)
+	mux, err := gateway.NewHandler(context.Background(), conn, cfg, auth)
 	if err != nil {
 		log.Fatalln("Failed to register gateway:", err)
 	}
 	gwServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", 8081),
-		Handler: gwmux,
+		Addr: cfg.Addr,
+		// 限制读取请求头的时间，防止慢速连接占用资源
+		ReadHeaderTimeout: 10 * time.Second,
+		// gRPC-Web 请求直接交给 grpcServer，其他请求交给网关
+		Handler: gateway.WithGRPCWeb(grpcServer, cfg, mux),
 	}
-	log.Println("Serving gRPC-Gateway on http://0.0.0.0" + fmt.Sprintf(":%d", 8081))
+	log.Println("Serving gRPC-Gateway on http://0.0.0.0" + cfg.Addr)
 	log.Fatalln(gwServer.ListenAndServe())
 
 }
+
+// serveAdmin 管理端口和网关分开监听，默认只监听本机
+func serveAdmin(addr string, h http.Handler) {
+	adminServer := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler:           h,
+	}
+	log.Println("Serving admin on http://" + addr)
+	log.Fatalln(adminServer.ListenAndServe())
+}
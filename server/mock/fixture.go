@@ -0,0 +1,254 @@
+// Package mock 根据声明式的 fixture 文件模拟 HelloService、GatewayService 和 FileService，
+// 调用方不需要真实的存储和认证就可以在本地测试
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Fixture 模拟服务的全部规则，对应 conf/mock.yaml
+type Fixture struct {
+	// Rules 按顺序匹配，使用第一条方法和请求都匹配的规则
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule 一条模拟规则
+type Rule struct {
+	// Method 方法名，可以是 SayHello 或完整的 /hello.v1.HelloService/SayHello
+	Method string `yaml:"method"`
+	// Match 按字段匹配请求，字段名为 proto 中的名字，例如 name、file_name；为空时匹配所有请求。
+	// 客户端流只匹配第一条消息，双向流对每条消息分别匹配
+	Match map[string]*Matcher `yaml:"match"`
+	// Delay 返回响应前等待的时间
+	Delay time.Duration `yaml:"delay"`
+	// Response 一元和客户端流方法的响应，字段格式与 protojson 相同，bytes 字段为 base64
+	Response map[string]interface{} `yaml:"response"`
+	// Stream 服务端流和双向流依次发送的响应
+	Stream []*Step `yaml:"stream"`
+	// Error 返回的错误，流式方法在发送完 Stream 后返回
+	Error *Error `yaml:"error"`
+
+	fullMethod string
+	response   proto.Message
+}
+
+// Step 流中的一条响应
+type Step struct {
+	Delay    time.Duration          `yaml:"delay"`
+	Response map[string]interface{} `yaml:"response"`
+
+	response proto.Message
+}
+
+// Error 返回的 gRPC 状态
+type Error struct {
+	// Code 状态码名称，例如 NOT_FOUND
+	Code    string `yaml:"code"`
+	Message string `yaml:"message"`
+
+	err error
+}
+
+// Matcher 字段匹配条件，写成字符串时表示完全相等
+type Matcher struct {
+	Equals   *string `yaml:"equals"`
+	Prefix   string  `yaml:"prefix"`
+	Contains string  `yaml:"contains"`
+	Regex    string  `yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+// UnmarshalYAML 支持 name: 鲁迪 的简写
+func (m *Matcher) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s := value.Value
+		m.Equals = &s
+		return nil
+	}
+	type plain Matcher
+	return value.Decode((*plain)(m))
+}
+
+func (m *Matcher) match(v string) bool {
+	if m.Equals != nil && v != *m.Equals {
+		return false
+	}
+	if m.Prefix != "" && !strings.HasPrefix(v, m.Prefix) {
+		return false
+	}
+	if m.Contains != "" && !strings.Contains(v, m.Contains) {
+		return false
+	}
+	if m.re != nil && !m.re.MatchString(v) {
+		return false
+	}
+	return true
+}
+
+// Load 读取并校验 fixture 文件
+func Load(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析并校验 fixture，响应在这里就转换为 proto 消息，错误的 fixture 不会等到调用时才发现
+func Parse(data []byte) (*Fixture, error) {
+	f := &Fixture{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	for i, r := range f.Rules {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("mock: rule %d (%s): %w", i, r.Method, err)
+		}
+	}
+	return f, nil
+}
+
+func (r *Rule) compile() error {
+	md, err := findMethod(r.Method)
+	if err != nil {
+		return err
+	}
+	r.fullMethod = fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+
+	in := md.Input()
+	for field, m := range r.Match {
+		if fieldByName(in, field) == nil {
+			return fmt.Errorf("unknown field %q in %s", field, in.FullName())
+		}
+		if m == nil {
+			return fmt.Errorf("empty matcher for field %q", field)
+		}
+		if m.Regex != "" {
+			if m.re, err = regexp.Compile(m.Regex); err != nil {
+				return err
+			}
+		}
+	}
+
+	if md.IsStreamingServer() {
+		if r.Response != nil {
+			return errors.New("streaming responses must use stream, not response")
+		}
+		for _, step := range r.Stream {
+			if step.response, err = newMessage(md.Output(), step.Response); err != nil {
+				return err
+			}
+		}
+	} else {
+		if len(r.Stream) > 0 {
+			return errors.New("stream is only for server and bidi streaming methods")
+		}
+		if r.Response == nil && r.Error == nil {
+			return errors.New("response or error is required")
+		}
+		if r.response, err = newMessage(md.Output(), r.Response); err != nil {
+			return err
+		}
+	}
+
+	if r.Error != nil {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(`"` + r.Error.Code + `"`)); err != nil {
+			return err
+		}
+		r.Error.err = status.Error(code, r.Error.Message)
+	}
+	return nil
+}
+
+// findMethod 在 hello.proto 的服务中查找方法，简写的方法名在所有服务中唯一
+func findMethod(name string) (protoreflect.MethodDescriptor, error) {
+	services := hello.File_hello_proto.Services()
+	for i := 0; i < services.Len(); i++ {
+		sd := services.Get(i)
+		methods := sd.Methods()
+		for j := 0; j < methods.Len(); j++ {
+			md := methods.Get(j)
+			if name == string(md.Name()) || name == fmt.Sprintf("/%s/%s", sd.FullName(), md.Name()) {
+				return md, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown method %q", name)
+}
+
+func fieldByName(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return md.Fields().ByJSONName(name)
+}
+
+// newMessage 把 fixture 中的响应转换为 proto 消息，先转成 JSON 再用 protojson 解析
+func newMessage(md protoreflect.MessageDescriptor, fields map[string]interface{}) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if fields == nil {
+		return msg, nil
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("response %s: %w", md.FullName(), err)
+	}
+	return msg, nil
+}
+
+// find 返回第一条匹配的规则
+func (f *Fixture) find(fullMethod string, req proto.Message) (*Rule, error) {
+	for _, r := range f.Rules {
+		if r.fullMethod == fullMethod && r.matches(req) {
+			return r, nil
+		}
+	}
+	return nil, status.Errorf(codes.Unimplemented, "mock: no fixture matches %s %v", fullMethod, req)
+}
+
+func (r *Rule) matches(req proto.Message) bool {
+	if len(r.Match) == 0 {
+		return true
+	}
+	if req == nil {
+		return false
+	}
+	msg := req.ProtoReflect()
+	for field, m := range r.Match {
+		fd := fieldByName(msg.Descriptor(), field)
+		v := msg.Get(fd)
+		var s string
+		if fd.Kind() == protoreflect.BytesKind {
+			s = string(v.Bytes())
+		} else {
+			s = v.String()
+		}
+		if !m.match(s) {
+			return false
+		}
+	}
+	return true
+}
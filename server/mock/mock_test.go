@@ -0,0 +1,290 @@
+package mock_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func dial(t *testing.T, f *mock.Fixture) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	mock.Register(s, f)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func loadExample(t *testing.T) *mock.Fixture {
+	t.Helper()
+	f, err := mock.Load("../../conf/mock.yaml")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return f
+}
+
+func TestSayHello(t *testing.T) {
+	client := hello.NewHelloServiceClient(dial(t, loadExample(t)))
+	tests := []struct {
+		name     string
+		req      *hello.HelloRequest
+		wantMsg  string
+		wantCode codes.Code
+		minDelay time.Duration
+	}{
+		{name: "equals", req: &hello.HelloRequest{Name: "鲁迪"}, wantMsg: "你好"},
+		{name: "prefix with delay", req: &hello.HelloRequest{Name: "slow-1"}, wantMsg: "终于好了", minDelay: 500 * time.Millisecond},
+		{name: "regex error", req: &hello.HelloRequest{}, wantCode: codes.InvalidArgument},
+		{name: "fallback", req: &hello.HelloRequest{Name: "other"}, wantMsg: "默认响应"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			resp, err := client.SayHello(ctx, tt.req)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("code = %s, want %s (err %v)", got, tt.wantCode, err)
+			}
+			if resp.GetMessage() != tt.wantMsg {
+				t.Errorf("message = %q, want %q", resp.GetMessage(), tt.wantMsg)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("returned after %v, want at least %v", elapsed, tt.minDelay)
+			}
+		})
+	}
+}
+
+func TestLotsOfReplies(t *testing.T) {
+	client := hello.NewHelloServiceClient(dial(t, loadExample(t)))
+	tests := []struct {
+		name     string
+		want     []string
+		wantCode codes.Code
+	}{
+		{name: "any", want: []string{"你好", "hello"}},
+		{name: "broken", want: []string{"你好"}, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			stream, err := client.LotsOfReplies(ctx, &hello.HelloRequest{Name: tt.name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for {
+				resp, err := stream.Recv()
+				if err != nil {
+					if err != io.EOF {
+						if code := status.Code(err); code != tt.wantCode {
+							t.Errorf("code = %s, want %s", code, tt.wantCode)
+						}
+					} else if tt.wantCode != codes.OK {
+						t.Errorf("stream ended without %s", tt.wantCode)
+					}
+					break
+				}
+				got = append(got, resp.GetName())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replies = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("replies = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBidiHello(t *testing.T) {
+	client := hello.NewHelloServiceClient(dial(t, loadExample(t)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.BidiHello(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := stream.Send(&hello.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetName() != "收到" {
+			t.Errorf("reply = %q, want 收到", resp.GetName())
+		}
+	}
+	if err := stream.Send(&hello.HelloRequest{Name: "bye"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("Recv = %v, want code %s", err, codes.Canceled)
+	}
+}
+
+func TestFileService(t *testing.T) {
+	client := hello.NewFileServiceClient(dial(t, loadExample(t)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	download, err := client.DownLoadFile(ctx, &hello.HelloRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := download.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.GetContent()) != "hello world\n" {
+		t.Errorf("content = %q", resp.GetContent())
+	}
+
+	upload, err := client.UploadFile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := upload.Send(&hello.FileRequest{FileName: "a", Content: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply, err := upload.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.GetMessage() != "完毕了" {
+		t.Errorf("message = %q", reply.GetMessage())
+	}
+}
+
+func TestBatchGreetings(t *testing.T) {
+	client := hello.NewHelloServiceClient(dial(t, loadExample(t)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.BatchGreetings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&hello.BatchGreetingRequest{Item: &hello.HelloRequest{Name: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.GetAck().GetAccepted() != 1 {
+		t.Fatalf("ack = %v, %v", resp, err)
+	}
+	if err := stream.Send(&hello.BatchGreetingRequest{IdempotencyKey: "done-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.GetResult().GetGreeting().GetName() != "你好：大家" {
+		t.Fatalf("result = %v, %v", resp, err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv after CloseSend = %v, want EOF", err)
+	}
+}
+
+func TestEventService(t *testing.T) {
+	client := hello.NewEventServiceClient(dial(t, loadExample(t)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event, err := client.Publish(ctx, &hello.PublishRequest{Topic: "orders", Data: "created"})
+	if err != nil || event.GetSeq() != 1 {
+		t.Fatalf("Publish = %v, %v", event, err)
+	}
+
+	stream, err := client.Subscribe(ctx, &hello.SubscribeRequest{Topic: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, event.GetData())
+	}
+	if len(got) != 2 || got[0] != "created" || got[1] != "paid" {
+		t.Errorf("events = %q", got)
+	}
+
+	// 没有匹配的规则
+	stream, err = client.Subscribe(ctx, &hello.SubscribeRequest{Topic: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Errorf("Recv = %v, want code %s", err, codes.Unimplemented)
+	}
+}
+
+func TestNoMatch(t *testing.T) {
+	f, err := mock.Parse([]byte(`
+rules:
+  - method: /hello.v1.HelloService/SayHello
+    match: {name: only}
+    response: {name: only}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := hello.NewHelloServiceClient(dial(t, f))
+	if _, err := client.SayHello(context.Background(), &hello.HelloRequest{Name: "other"}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("SayHello = %v, want code %s", err, codes.Unimplemented)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+	}{
+		{name: "unknown method", fixture: "rules: [{method: Nope, response: {}}]"},
+		{name: "unknown field", fixture: "rules: [{method: SayHello, match: {age: '1'}, response: {}}]"},
+		{name: "bad regex", fixture: "rules: [{method: SayHello, match: {name: {regex: '('}}, response: {}}]"},
+		{name: "bad response field", fixture: "rules: [{method: SayHello, response: {age: 1}}]"},
+		{name: "unary without response", fixture: "rules: [{method: SayHello}]"},
+		{name: "unary with stream", fixture: "rules: [{method: SayHello, stream: [{response: {}}]}]"},
+		{name: "stream with response", fixture: "rules: [{method: LotsOfReplies, response: {}}]"},
+		{name: "bad code", fixture: "rules: [{method: SayHello, error: {code: NOPE}}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := mock.Parse([]byte(tt.fixture)); err == nil {
+				t.Errorf("Parse succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package mock
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"time"
+)
+
+// Register 把 hello.proto 中所有服务的模拟实现注册到 s
+func Register(s *grpc.Server, f *Fixture) {
+	hello.RegisterHelloServiceServer(s, NewHelloServer(f))
+	hello.RegisterGatewayServiceServer(s, NewGatewayServer(f))
+	hello.RegisterFileServiceServer(s, NewFileServer(f))
+	hello.RegisterEventServiceServer(s, NewEventServer(f))
+}
+
+// HelloServer 模拟 HelloService
+type HelloServer struct {
+	hello.UnimplementedHelloServiceServer
+	fixture *Fixture
+}
+
+// NewHelloServer 创建模拟的 HelloService
+func NewHelloServer(f *Fixture) *HelloServer {
+	return &HelloServer{fixture: f}
+}
+
+func (s *HelloServer) SayHello(ctx context.Context, req *hello.HelloRequest) (*hello.HelloResponse, error) {
+	resp, err := unary(ctx, s.fixture, "/hello.v1.HelloService/SayHello", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*hello.HelloResponse), nil
+}
+
+func (s *HelloServer) LotsOfReplies(req *hello.HelloRequest, stream hello.HelloService_LotsOfRepliesServer) error {
+	r, err := s.fixture.find("/hello.v1.HelloService/LotsOfReplies", req)
+	if err != nil {
+		return err
+	}
+	return play(stream, r)
+}
+
+func (s *HelloServer) LotsOfGreetings(stream hello.HelloService_LotsOfGreetingsServer) error {
+	resp, err := clientStream(stream, s.fixture, "/hello.v1.HelloService/LotsOfGreetings", func() proto.Message {
+		return new(hello.HelloRequest)
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp.(*hello.HelloResponse))
+}
+
+func (s *HelloServer) BatchGreetings(stream hello.HelloService_BatchGreetingsServer) error {
+	return bidiStream(stream, s.fixture, "/hello.v1.HelloService/BatchGreetings", func() proto.Message {
+		return new(hello.BatchGreetingRequest)
+	})
+}
+
+func (s *HelloServer) BidiHello(stream hello.HelloService_BidiHelloServer) error {
+	return bidiStream(stream, s.fixture, "/hello.v1.HelloService/BidiHello", func() proto.Message {
+		return new(hello.HelloRequest)
+	})
+}
+
+// GatewayServer 模拟 GatewayService
+type GatewayServer struct {
+	hello.UnimplementedGatewayServiceServer
+	fixture *Fixture
+}
+
+// NewGatewayServer 创建模拟的 GatewayService
+func NewGatewayServer(f *Fixture) *GatewayServer {
+	return &GatewayServer{fixture: f}
+}
+
+func (s *GatewayServer) SayMessage(ctx context.Context, req *hello.HelloRequest) (*hello.HelloResponse, error) {
+	resp, err := unary(ctx, s.fixture, "/hello.v1.GatewayService/SayMessage", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*hello.HelloResponse), nil
+}
+
+// FileServer 模拟 FileService
+type FileServer struct {
+	hello.UnimplementedFileServiceServer
+	fixture *Fixture
+}
+
+// NewFileServer 创建模拟的 FileService
+func NewFileServer(f *Fixture) *FileServer {
+	return &FileServer{fixture: f}
+}
+
+func (s *FileServer) DownLoadFile(req *hello.HelloRequest, stream hello.FileService_DownLoadFileServer) error {
+	r, err := s.fixture.find("/hello.v1.FileService/DownLoadFile", req)
+	if err != nil {
+		return err
+	}
+	return play(stream, r)
+}
+
+func (s *FileServer) UploadFile(stream hello.FileService_UploadFileServer) error {
+	resp, err := clientStream(stream, s.fixture, "/hello.v1.FileService/UploadFile", func() proto.Message {
+		return new(hello.FileRequest)
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp.(*hello.HelloResponse))
+}
+
+// EventServer 模拟 EventService，Subscribe 按规则发送固定的事件，不保存发布的事件
+type EventServer struct {
+	hello.UnimplementedEventServiceServer
+	fixture *Fixture
+}
+
+// NewEventServer 创建模拟的 EventService
+func NewEventServer(f *Fixture) *EventServer {
+	return &EventServer{fixture: f}
+}
+
+func (s *EventServer) Publish(ctx context.Context, req *hello.PublishRequest) (*hello.Event, error) {
+	resp, err := unary(ctx, s.fixture, "/hello.v1.EventService/Publish", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*hello.Event), nil
+}
+
+func (s *EventServer) Subscribe(req *hello.SubscribeRequest, stream hello.EventService_SubscribeServer) error {
+	r, err := s.fixture.find("/hello.v1.EventService/Subscribe", req)
+	if err != nil {
+		return err
+	}
+	return play(stream, r)
+}
+
+func unary(ctx context.Context, f *Fixture, method string, req proto.Message) (proto.Message, error) {
+	r, err := f.find(method, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := sleep(ctx, r.Delay); err != nil {
+		return nil, err
+	}
+	if r.Error != nil {
+		return nil, r.Error.err
+	}
+	return proto.Clone(r.response), nil
+}
+
+// clientStream 按第一条消息匹配规则，读完客户端发送的所有消息后返回响应
+func clientStream(stream grpc.ServerStream, f *Fixture, method string, newReq func() proto.Message) (proto.Message, error) {
+	var first proto.Message
+	for {
+		req := newReq()
+		err := stream.RecvMsg(req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = req
+		}
+	}
+	r, err := f.find(method, first)
+	if err != nil {
+		return nil, err
+	}
+	if err := sleep(stream.Context(), r.Delay); err != nil {
+		return nil, err
+	}
+	if r.Error != nil {
+		return nil, r.Error.err
+	}
+	return proto.Clone(r.response), nil
+}
+
+// bidiStream 每收到一条消息按匹配的规则发送响应，规则返回错误时结束流
+func bidiStream(stream grpc.ServerStream, f *Fixture, method string, newReq func() proto.Message) error {
+	for {
+		req := newReq()
+		err := stream.RecvMsg(req)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r, err := f.find(method, req)
+		if err != nil {
+			return err
+		}
+		if err := play(stream, r); err != nil {
+			return err
+		}
+	}
+}
+
+// play 依次发送规则中的响应，最后返回规则中的错误
+func play(stream grpc.ServerStream, r *Rule) error {
+	ctx := stream.Context()
+	if err := sleep(ctx, r.Delay); err != nil {
+		return err
+	}
+	for _, step := range r.Stream {
+		if err := sleep(ctx, step.Delay); err != nil {
+			return err
+		}
+		if err := stream.SendMsg(proto.Clone(step.response)); err != nil {
+			return err
+		}
+	}
+	if r.Error != nil {
+		return r.Error.err
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// 确保模拟服务实现了生成的服务接口
+var (
+	_ hello.HelloServiceServer   = (*HelloServer)(nil)
+	_ hello.GatewayServiceServer = (*GatewayServer)(nil)
+	_ hello.FileServiceServer    = (*FileServer)(nil)
+	_ hello.EventServiceServer   = (*EventServer)(nil)
+)
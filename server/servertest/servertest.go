@@ -0,0 +1,329 @@
+// Package servertest 在进程内通过 bufconn 启动 HelloService、GatewayService、FileService 和 EventService，
+// 供测试使用，不需要监听真实端口
+package servertest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/client/auth"
+	clienthandler "github.com/keepon-online/go-grpc-example/client/handler"
+	"github.com/keepon-online/go-grpc-example/client/sdk"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/gateway"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"github.com/keepon-online/go-grpc-example/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// bufSize bufconn 的缓冲区大小
+const bufSize = 1 << 20
+
+// ServerName 启用 TLS 时证书中的域名
+const ServerName = "bufnet"
+
+// UID 测试客户端携带的 uid
+const UID = "1234"
+
+// SigningKey WithAuthConfig 没有设置签名密钥时使用的密钥
+const SigningKey = "servertest-signing-key-0123456789"
+
+type options struct {
+	unary      []grpc.UnaryServerInterceptor
+	stream     []grpc.StreamServerInterceptor
+	tls        bool
+	auth       bool
+	authConfig config.Auth
+	gateway    config.Gateway
+	file       service.FileServer
+	chat       config.Chat
+	events     config.Events
+	batch      config.Batch
+	server     config.Server
+	tenants    config.Tenants
+	serverOpts []grpc.ServerOption
+}
+
+// Option 测试服务端选项
+type Option func(*options)
+
+// WithUnaryInterceptors 追加一元拦截器，在认证和租户拦截器之后执行
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.unary = append(o.unary, interceptors...)
+	}
+}
+
+// WithStreamInterceptors 追加流式拦截器，在认证和租户拦截器之后执行
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		o.stream = append(o.stream, interceptors...)
+	}
+}
+
+// WithTLS 使用临时生成的自签名证书，客户端信任该证书并以 ServerName 校验
+func WithTLS() Option {
+	return func(o *options) {
+		o.tls = true
+	}
+}
+
+// WithAuth 启用token认证，和 server/main.go 一样在拦截器链的最前面校验token，
+// 返回的客户端会自动携带有效的token
+func WithAuth() Option {
+	return func(o *options) {
+		o.auth = true
+	}
+}
+
+// WithAuthConfig token的签名和网关令牌接口的配置，SigningKey 为空时使用 SigningKey
+func WithAuthConfig(cfg config.Auth) Option {
+	return func(o *options) {
+		o.authConfig = cfg
+	}
+}
+
+// WithGatewayConfig 网关的配置，Addr 和 Backend 不生效
+func WithGatewayConfig(cfg config.Gateway) Option {
+	return func(o *options) {
+		o.gateway = cfg
+	}
+}
+
+// WithFileServer 自定义 FileService，例如设置下载的文件
+func WithFileServer(fs service.FileServer) Option {
+	return func(o *options) {
+		o.file = fs
+	}
+}
+
+// WithChatConfig BidiHello 聊天室的配置
+func WithChatConfig(cfg config.Chat) Option {
+	return func(o *options) {
+		o.chat = cfg
+	}
+}
+
+// WithEventsConfig EventService 的配置，例如缩短心跳间隔
+func WithEventsConfig(cfg config.Events) Option {
+	return func(o *options) {
+		o.events = cfg
+	}
+}
+
+// WithBatchConfig LotsOfGreetings、BatchGreetings 的批量限制
+func WithBatchConfig(cfg config.Batch) Option {
+	return func(o *options) {
+		o.batch = cfg
+	}
+}
+
+// WithServerConfig 服务端的保活、连接存活时间和消息大小，和 server/main.go 一样通过 handler.ServerOptions 设置
+func WithServerConfig(cfg config.Server) Option {
+	return func(o *options) {
+		o.server = cfg
+	}
+}
+
+// WithTenantsConfig 多租户配置，例如允许通过元数据指定租户
+func WithTenantsConfig(cfg config.Tenants) Option {
+	return func(o *options) {
+		o.tenants = cfg
+	}
+}
+
+// WithServerOptions 追加其他 grpc.ServerOption
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
+
+// Server 进程内的测试服务端，测试结束时自动关闭
+type Server struct {
+	GRPC     *grpc.Server
+	Listener *bufconn.Listener
+	// Conn 连接到服务端的客户端连接，启用认证时携带有效的token
+	Conn    *grpc.ClientConn
+	Hello   hello.HelloServiceClient
+	Gateway hello.GatewayServiceClient
+	File    hello.FileServiceClient
+	Events  hello.EventServiceClient
+	// SDK 使用 Conn 的 sdk 客户端
+	SDK *sdk.Client
+	// HTTP 网关，通过 Conn 转发请求
+	HTTP *httptest.Server
+	// Rooms HelloService 的聊天室
+	Rooms *service.Rooms
+
+	creds   credentials.TransportCredentials
+	token   string
+	mu      sync.Mutex
+	closers []func()
+}
+
+// Start 启动测试服务端，失败时终止测试，测试结束时自动关闭
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// New 启动服务端，没有通过 Start 启动时需要自己调用 Close
+func New(opts ...Option) (*Server, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.authConfig.SigningKey == "" {
+		o.authConfig.SigningKey = SigningKey
+	}
+
+	s := &Server{Listener: bufconn.Listen(bufSize), Rooms: service.NewRooms(o.chat)}
+	sopts := handler.ServerOptions(o.server)
+	s.creds = insecure.NewCredentials()
+	if o.tls {
+		cert, pool, err := selfSignedCert(ServerName)
+		if err != nil {
+			return nil, fmt.Errorf("create certificate: %w", err)
+		}
+		sopts = append(sopts, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+		s.creds = credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: ServerName})
+	}
+	// 和 server/main.go 一样，认证之后确定租户
+	unary := append([]grpc.UnaryServerInterceptor{handler.ServerInterceptorTenant(o.tenants)}, o.unary...)
+	stream := append([]grpc.StreamServerInterceptor{handler.StreamServerInterceptorTenant(o.tenants)}, o.stream...)
+	if o.auth {
+		unary = append([]grpc.UnaryServerInterceptor{handler.ServerInterceptorCheckToken(o.authConfig)}, unary...)
+		stream = append([]grpc.StreamServerInterceptor{handler.StreamServerInterceptorCheckToken(o.authConfig)}, stream...)
+	}
+	sopts = append(sopts, grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	s.GRPC = grpc.NewServer(append(sopts, o.serverOpts...)...)
+	hello.RegisterHelloServiceServer(s.GRPC, &service.HelloServer{Rooms: s.Rooms, Batch: service.NewBatch(o.batch)})
+	hello.RegisterGatewayServiceServer(s.GRPC, &service.GateWayServer{})
+	hello.RegisterFileServiceServer(s.GRPC, &o.file)
+	hello.RegisterEventServiceServer(s.GRPC, service.NewEventServer(nil, o.events))
+	go s.GRPC.Serve(s.Listener)
+	s.onClose(s.GRPC.Stop)
+
+	var dopts []grpc.DialOption
+	if o.auth {
+		token, err := newToken([]byte(o.authConfig.SigningKey), util.BaseClaims{ID: 1, Username: "servertest"})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.token = token
+		dopts = append(dopts, grpc.WithPerRPCCredentials(&clienthandler.Token{
+			Uid:      UID,
+			Source:   auth.StaticTokenSource(s.token),
+			Insecure: !o.tls,
+		}))
+	}
+	conn, err := s.NewConn(dopts...)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Conn = conn
+	s.Hello = hello.NewHelloServiceClient(s.Conn)
+	s.Gateway = hello.NewGatewayServiceClient(s.Conn)
+	s.File = hello.NewFileServiceClient(s.Conn)
+	s.Events = hello.NewEventServiceClient(s.Conn)
+	s.SDK = sdk.NewFromConn(s.Conn)
+
+	// 网关转发时使用 HTTP 请求中的 Authorization，不使用 Conn 上的token
+	gwConn, err := s.NewConn()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	h, err := gateway.NewHandler(context.Background(), gwConn, o.gateway, o.authConfig)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("create gateway: %w", err)
+	}
+	s.HTTP = httptest.NewServer(gateway.WithGRPCWeb(s.GRPC, o.gateway, h))
+	s.onClose(s.HTTP.Close)
+	return s, nil
+}
+
+// Close 关闭网关、所有连接和服务端
+func (s *Server) Close() {
+	s.mu.Lock()
+	closers := s.closers
+	s.closers = nil
+	s.mu.Unlock()
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i]()
+	}
+}
+
+func (s *Server) onClose(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closers = append(s.closers, f)
+}
+
+// Dial 创建新的客户端连接，opts 追加在传输层凭证之后，测试结束时自动关闭
+func (s *Server) Dial(t testing.TB, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	conn, err := s.NewConn(opts...)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	return conn
+}
+
+// NewConn 创建新的客户端连接，Close 时一起关闭
+func (s *Server) NewConn(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dopts := append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.Listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(s.creds),
+	}, opts...)
+	conn, err := grpc.Dial("bufnet", dopts...)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	s.onClose(func() { conn.Close() })
+	return conn, nil
+}
+
+// Token 返回启用认证时 Conn 携带的token，未启用时为空
+func (s *Server) Token() string {
+	return s.token
+}
+
+// NewToken 使用默认的签名密钥 SigningKey 签发token
+func NewToken(t testing.TB, base util.BaseClaims) string {
+	t.Helper()
+	token, err := newToken([]byte(SigningKey), base)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	return token
+}
+
+func newToken(key []byte, base util.BaseClaims) (string, error) {
+	j := util.NewJWT(key)
+	token, err := j.CreateToken(j.CreateClaims(base))
+	if err != nil {
+		return "", fmt.Errorf("create token: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataIdempotencyKey BatchGreetings 的幂等键，第一条消息中的 idempotency_key 优先
+const MetadataIdempotencyKey = "idempotency-key"
+
+// greetingPrefix LotsOfGreetings、BatchGreetings 返回的问候的前缀
+const greetingPrefix = "你好："
+
+// Batch 批量接收的限制和 BatchGreetings 的幂等记录
+type Batch struct {
+	cfg config.Batch
+
+	mu sync.Mutex
+	// results 幂等键 -> 处理结果，result 为 nil 表示正在处理
+	results map[string]*batchEntry
+}
+
+type batchEntry struct {
+	result  *hello.BatchResult
+	expires time.Time
+}
+
+// NewBatch 按配置创建，幂等记录只保存在内存中
+func NewBatch(cfg config.Batch) *Batch {
+	return &Batch{cfg: cfg.WithDefaults(), results: make(map[string]*batchEntry)}
+}
+
+// limits 为 nil 时使用默认限制
+func (b *Batch) limits() config.Batch {
+	if b == nil {
+		return config.Batch{}.WithDefaults()
+	}
+	return b.cfg
+}
+
+// batchCounter 统计流中的消息数和字节数，超过限制时返回 errs.ErrBatchTooLarge
+type batchCounter struct {
+	cfg   config.Batch
+	items int
+	bytes int
+}
+
+func (c *batchCounter) add(m proto.Message) error {
+	c.items++
+	c.bytes += proto.Size(m)
+	if c.items > c.cfg.MaxItems {
+		return errs.ErrBatchTooLarge.WithMetadata("limit", "maxItems="+strconv.Itoa(c.cfg.MaxItems))
+	}
+	if c.bytes > c.cfg.MaxBytes {
+		return errs.ErrBatchTooLarge.WithMetadata("limit", "maxBytes="+strconv.Itoa(c.cfg.MaxBytes))
+	}
+	return nil
+}
+
+// begin 开始处理幂等键对应的流：已经处理过时返回之前的结果，正在处理时返回 errs.ErrBatchInProgress
+func (b *Batch) begin(key string) (*hello.BatchResult, error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, e := range b.results {
+		if e.result != nil && now.After(e.expires) {
+			delete(b.results, k)
+		}
+	}
+	if e, ok := b.results[key]; ok {
+		if e.result == nil {
+			return nil, errs.ErrBatchInProgress
+		}
+		return e.result, nil
+	}
+	b.results[key] = &batchEntry{}
+	return nil, nil
+}
+
+// finish 保存处理结果，result 为 nil 表示处理失败，之后可以用相同的幂等键重试
+func (b *Batch) finish(key string, result *hello.BatchResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if result == nil {
+		delete(b.results, key)
+		return
+	}
+	b.results[key] = &batchEntry{result: result, expires: time.Now().Add(b.cfg.IdempotencyTTL)}
+}
+
+// serve 接收到客户端关闭发送，每 AckEvery 条消息返回一次确认，最后返回结果。
+// 所有消息都收到之后才算处理完成，中途失败的流不保存结果，可以用相同的幂等键重试
+func (b *Batch) serve(stream hello.HelloService_BatchGreetingsServer) error {
+	first, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	key := first.GetIdempotencyKey()
+	if key == "" {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if v := md.Get(MetadataIdempotencyKey); len(v) > 0 {
+			key = v[0]
+		}
+	}
+	if key != "" {
+		// 幂等键按租户和用户隔离
+		username := ""
+		if claims, ok := handler.ClaimsFromContext(stream.Context()); ok {
+			username = claims.Username
+		}
+		key = handler.TenantFromContext(stream.Context()) + "/" + username + "/" + key
+		done, err := b.begin(key)
+		if err != nil {
+			return err
+		}
+		if done != nil {
+			replay := proto.Clone(done).(*hello.BatchResult)
+			replay.Replayed = true
+			return stream.Send(&hello.BatchGreetingResponse{Response: &hello.BatchGreetingResponse_Result{Result: replay}})
+		}
+	}
+
+	result, err := b.receive(stream, first)
+	if key != "" {
+		b.finish(key, result)
+	}
+	if err != nil {
+		return err
+	}
+	return stream.Send(&hello.BatchGreetingResponse{Response: &hello.BatchGreetingResponse_Result{Result: result}})
+}
+
+// receive 逐条校验并拼接问候，出错时返回的结果为 nil
+func (b *Batch) receive(stream hello.HelloService_BatchGreetingsServer, first *hello.BatchGreetingRequest) (*hello.BatchResult, error) {
+	counter := &batchCounter{cfg: b.cfg}
+	result := &hello.BatchResult{}
+	var reply strings.Builder
+	reply.WriteString(greetingPrefix)
+	var index uint32
+	for in := first; in != nil; {
+		if item := in.GetItem(); item != nil {
+			if err := counter.add(item); err != nil {
+				return nil, err
+			}
+			if violations := handler.Validate(item); len(violations) > 0 {
+				itemErr := &hello.ItemError{Index: index}
+				for _, v := range violations {
+					itemErr.Violations = append(itemErr.Violations, &hello.FieldViolation{Field: "item." + v.GetField(), Description: v.GetDescription()})
+				}
+				result.Errors = append(result.Errors, itemErr)
+				result.Rejected++
+			} else {
+				reply.WriteString(item.GetName())
+				result.Accepted++
+			}
+			index++
+			if index%uint32(b.cfg.AckEvery) == 0 {
+				ack := &hello.BatchAck{Received: index, Accepted: result.Accepted, Rejected: result.Rejected}
+				if err := stream.Send(&hello.BatchGreetingResponse{Response: &hello.BatchGreetingResponse_Ack{Ack: ack}}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		var err error
+		in, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Greeting = &hello.HelloResponse{Name: reply.String()}
+	return result, nil
+}
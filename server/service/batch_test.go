@@ -0,0 +1,190 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLotsOfGreetingsLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.Batch
+		names     []string
+		wantLimit string
+	}{
+		{name: "within limits", cfg: config.Batch{MaxItems: 3}, names: []string{"a", "b", "c"}},
+		{name: "too many items", cfg: config.Batch{MaxItems: 2}, names: []string{"a", "b", "c"}, wantLimit: "maxItems=2"},
+		{name: "too many bytes", cfg: config.Batch{MaxBytes: 16}, names: []string{"aaaaaaaaaa", "bbbbbbbbbb"}, wantLimit: "maxBytes=16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithBatchConfig(tt.cfg))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			stream, err := s.Hello.LotsOfGreetings(ctx)
+			if err != nil {
+				t.Fatalf("LotsOfGreetings: %v", err)
+			}
+			for _, name := range tt.names {
+				// 服务端返回错误后 Send 返回 io.EOF，错误由 CloseAndRecv 返回
+				if err := stream.Send(&hello.HelloRequest{Name: name}); err != nil && err != io.EOF {
+					t.Fatalf("Send: %v", err)
+				}
+			}
+			resp, err := stream.CloseAndRecv()
+			if tt.wantLimit == "" {
+				if err != nil || resp.GetName() != "你好："+strings.Join(tt.names, "") {
+					t.Errorf("LotsOfGreetings = %v, %v", resp, err)
+				}
+				return
+			}
+			if status.Code(err) != codes.ResourceExhausted || !errs.IsReason(err, errs.ReasonBatchTooLarge) {
+				t.Fatalf("CloseAndRecv = %v, want BATCH_TOO_LARGE", err)
+			}
+			if got := errs.FromError(err).Metadata["limit"]; got != tt.wantLimit {
+				t.Errorf("limit = %q, want %q", got, tt.wantLimit)
+			}
+		})
+	}
+}
+
+// batch 发送所有消息后关闭发送，返回收到的确认和最终结果
+func batch(t *testing.T, ctx context.Context, s *servertest.Server, reqs []*hello.BatchGreetingRequest) ([]*hello.BatchAck, *hello.BatchResult, error) {
+	t.Helper()
+	stream, err := s.Hello.BatchGreetings(ctx)
+	if err != nil {
+		t.Fatalf("BatchGreetings: %v", err)
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err == io.EOF {
+			// 服务端已经返回结果（幂等键重复）
+			break
+		} else if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	stream.CloseSend()
+	var acks []*hello.BatchAck
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return acks, nil, err
+		}
+		if ack := resp.GetAck(); ack != nil {
+			acks = append(acks, ack)
+			continue
+		}
+		return acks, resp.GetResult(), nil
+	}
+}
+
+func items(names ...string) []*hello.BatchGreetingRequest {
+	var reqs []*hello.BatchGreetingRequest
+	for _, name := range names {
+		reqs = append(reqs, &hello.BatchGreetingRequest{Item: &hello.HelloRequest{Name: name}})
+	}
+	return reqs
+}
+
+func TestBatchGreetings(t *testing.T) {
+	s := servertest.Start(t, servertest.WithBatchConfig(config.Batch{AckEvery: 2}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 第 2 条消息没有名字，第 4 条包含控制字符，都不会中断流
+	acks, result, err := batch(t, ctx, s, items("a", "", "b", "c\n", "d"))
+	if err != nil {
+		t.Fatalf("BatchGreetings: %v", err)
+	}
+	if len(acks) != 2 || acks[0].GetReceived() != 2 || acks[0].GetRejected() != 1 || acks[1].GetReceived() != 4 || acks[1].GetAccepted() != 2 {
+		t.Errorf("acks = %v", acks)
+	}
+	if result.GetGreeting().GetName() != "你好：abd" || result.GetAccepted() != 3 || result.GetRejected() != 2 || result.GetReplayed() {
+		t.Errorf("result = %v", result)
+	}
+	var rejected []string
+	for _, e := range result.GetErrors() {
+		rejected = append(rejected, fmt.Sprintf("%s@%d", e.GetViolations()[0].GetField(), e.GetIndex()))
+	}
+	if strings.Join(rejected, ",") != "item.name@1,item.name@3" {
+		t.Errorf("errors = %q", rejected)
+	}
+}
+
+func TestBatchGreetingsIdempotency(t *testing.T) {
+	withKey := func(key string, names ...string) []*hello.BatchGreetingRequest {
+		return append([]*hello.BatchGreetingRequest{{IdempotencyKey: key}}, items(names...)...)
+	}
+
+	t.Run("retry returns first result", func(t *testing.T) {
+		s := servertest.Start(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, first, err := batch(t, ctx, s, withKey("k1", "a", "b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 重试时即使内容不同也返回第一次的结果
+		_, retry, err := batch(t, ctx, s, withKey("k1", "x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !retry.GetReplayed() || retry.GetGreeting().GetName() != first.GetGreeting().GetName() {
+			t.Errorf("retry = %v, want replay of %v", retry, first)
+		}
+		// 元数据中的幂等键与第一条消息中的相同
+		_, viaMetadata, err := batch(t, metadata.AppendToOutgoingContext(ctx, service.MetadataIdempotencyKey, "k1"), s, items("y"))
+		if err != nil || !viaMetadata.GetReplayed() {
+			t.Errorf("metadata key = %v, %v, want replay", viaMetadata, err)
+		}
+		_, other, err := batch(t, ctx, s, withKey("k2", "x"))
+		if err != nil || other.GetReplayed() || other.GetGreeting().GetName() != "你好：x" {
+			t.Errorf("other key = %v, %v", other, err)
+		}
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		s := servertest.Start(t, servertest.WithBatchConfig(config.Batch{AckEvery: 1}))
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := s.Hello.BatchGreetings(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Send(&hello.BatchGreetingRequest{IdempotencyKey: "k1", Item: &hello.HelloRequest{Name: "a"}})
+		// 收到确认说明服务端已经开始处理
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = batch(t, ctx, s, withKey("k1", "a"))
+		if status.Code(err) != codes.Aborted || !errs.IsReason(err, errs.ReasonBatchInProgress) {
+			t.Errorf("concurrent stream = %v, want BATCH_IN_PROGRESS", err)
+		}
+	})
+
+	t.Run("failed stream can be retried", func(t *testing.T) {
+		s := servertest.Start(t, servertest.WithBatchConfig(config.Batch{MaxItems: 2}))
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, _, err := batch(t, ctx, s, withKey("k1", "a", "b", "c")); !errs.IsReason(err, errs.ReasonBatchTooLarge) {
+			t.Fatalf("first = %v, want BATCH_TOO_LARGE", err)
+		}
+		_, result, err := batch(t, ctx, s, withKey("k1", "a", "b"))
+		if err != nil || result.GetReplayed() || result.GetAccepted() != 2 {
+			t.Errorf("retry = %v, %v", result, err)
+		}
+	})
+}
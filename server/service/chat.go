@@ -0,0 +1,264 @@
+package service
+
+import (
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 加入聊天室的元数据，没有 room 时使用第一条消息的 room
+const (
+	MetadataRoom = "room"
+	MetadataName = "name"
+)
+
+// maxRoomLen 聊天室名称的最大字符数，与 HelloRequest.room 的校验规则一致
+const maxRoomLen = 64
+
+// Rooms BidiHello 的聊天室，按租户隔离。每个成员有自己的发送队列，广播时不会等待，
+// 队列满了的成员以 errs.ErrSlowConsumer 断开，不影响其他成员
+type Rooms struct {
+	cfg   config.Chat
+	mu    sync.Mutex
+	rooms map[roomKey]*room
+}
+
+// roomKey 不同租户的同名聊天室互不可见
+type roomKey struct {
+	tenant string
+	name   string
+}
+
+type room struct {
+	key     roomKey
+	seq     uint64
+	members map[*member]struct{}
+	// history 最近的消息，新成员加入时重放
+	history []*hello.HelloResponse
+}
+
+type member struct {
+	name  string
+	queue chan *hello.HelloResponse
+	// kicked 成员被断开时关闭，err 为断开的原因
+	kicked chan struct{}
+	err    error
+}
+
+// NewRooms 创建聊天室，聊天室在第一个成员加入时创建，最后一个成员离开时删除
+func NewRooms(cfg config.Chat) *Rooms {
+	return &Rooms{cfg: cfg.WithDefaults(), rooms: make(map[roomKey]*room)}
+}
+
+// Members 返回租户的聊天室中的成员名称，聊天室不存在时为空
+func (r *Rooms) Members(tenant, name string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[roomKey{tenant: tenant, name: name}]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(rm.members))
+	for m := range rm.members {
+		names = append(names, m.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// join 加入聊天室，先重放历史消息，再通知其他成员
+func (r *Rooms) join(key roomKey, name string) *member {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[key]
+	if !ok {
+		rm = &room{key: key, members: make(map[*member]struct{})}
+		r.rooms[key] = rm
+	}
+	// 队列中预留历史消息的位置，重放不会使新成员断开
+	m := &member{
+		name:   name,
+		queue:  make(chan *hello.HelloResponse, r.cfg.SendQueue+r.cfg.History),
+		kicked: make(chan struct{}),
+	}
+	for _, msg := range rm.history {
+		replay := proto.Clone(msg).(*hello.HelloResponse)
+		replay.Chat.Replay = true
+		m.queue <- replay
+	}
+	r.broadcast(rm, rm.event(hello.ChatEvent_TYPE_JOIN, name, "", len(rm.members)+1))
+	rm.members[m] = struct{}{}
+	return m
+}
+
+// leave 离开聊天室并通知其他成员，已经被断开的成员不会重复通知
+func (r *Rooms) leave(key roomKey, m *member) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rm, ok := r.rooms[key]; ok {
+		r.remove(rm, m)
+	}
+}
+
+// publish 把消息广播给聊天室中的所有成员，包括发送者自己
+func (r *Rooms) publish(key roomKey, in *hello.HelloRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[key]
+	if !ok {
+		return
+	}
+	msg := rm.event(hello.ChatEvent_TYPE_MESSAGE, in.GetName(), in.GetMessage(), len(rm.members))
+	rm.history = append(rm.history, msg)
+	if len(rm.history) > r.cfg.History {
+		rm.history = rm.history[len(rm.history)-r.cfg.History:]
+	}
+	r.broadcast(rm, msg)
+}
+
+// broadcast 需要持有 r.mu。队列满了的成员被断开并移出聊天室，其余成员收到离开的通知；
+// 离开的通知也可能使其他成员的队列满，按顺序处理，不递归，每个成员只会被断开一次
+func (r *Rooms) broadcast(rm *room, msg *hello.HelloResponse) {
+	pending := []*hello.HelloResponse{msg}
+	for len(pending) > 0 {
+		msg, pending = pending[0], pending[1:]
+		var slow []*member
+		for m := range rm.members {
+			select {
+			case m.queue <- msg:
+			default:
+				slow = append(slow, m)
+			}
+		}
+		if len(slow) == 0 {
+			continue
+		}
+		for _, m := range slow {
+			m.err = errs.ErrSlowConsumer.WithMetadata("room", rm.key.name)
+			close(m.kicked)
+			delete(rm.members, m)
+		}
+		if len(rm.members) == 0 {
+			delete(r.rooms, rm.key)
+			return
+		}
+		for _, m := range slow {
+			pending = append(pending, rm.event(hello.ChatEvent_TYPE_LEAVE, m.name, "", len(rm.members)))
+		}
+	}
+}
+
+// remove 需要持有 r.mu，已经被断开的成员不在 rm.members 中，不会重复通知
+func (r *Rooms) remove(rm *room, m *member) {
+	if _, ok := rm.members[m]; !ok {
+		return
+	}
+	delete(rm.members, m)
+	if len(rm.members) == 0 {
+		delete(r.rooms, rm.key)
+		return
+	}
+	r.broadcast(rm, rm.event(hello.ChatEvent_TYPE_LEAVE, m.name, "", len(rm.members)))
+}
+
+func (rm *room) event(typ hello.ChatEvent_Type, name, message string, online int) *hello.HelloResponse {
+	rm.seq++
+	return &hello.HelloResponse{
+		Name:    name,
+		Message: message,
+		Chat: &hello.ChatEvent{
+			Type:   typ,
+			Room:   rm.key.name,
+			Seq:    rm.seq,
+			Time:   timestamppb.Now(),
+			Online: uint32(online),
+		},
+	}
+}
+
+// serve 加入当前租户的聊天室后转发双方的消息：收到的消息广播到聊天室，队列中的消息发送给客户端。
+// first 为加入时的第一条消息，内容为空时只加入不广播
+func (r *Rooms) serve(stream hello.HelloService_BidiHelloServer, roomName, name string, first *hello.HelloRequest) error {
+	key := roomKey{tenant: handler.TenantFromContext(stream.Context()), name: roomName}
+	m := r.join(key, name)
+	defer r.leave(key, m)
+	if first.GetMessage() != "" {
+		r.publish(key, first)
+	}
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			r.publish(key, in)
+		}
+	}()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case msg := <-m.queue:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-m.kicked:
+			return m.err
+		case err := <-recvErr:
+			if err != io.EOF {
+				return err
+			}
+			// 客户端关闭发送后，把已经在队列中的消息发完再结束
+			return drain(stream, m.queue)
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func drain(stream hello.HelloService_BidiHelloServer, queue <-chan *hello.HelloResponse) error {
+	for {
+		select {
+		case msg := <-queue:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// roomFromMetadata 返回元数据中的聊天室和成员名称
+func roomFromMetadata(md metadata.MD) (roomName, name string) {
+	if v := md.Get(MetadataRoom); len(v) > 0 {
+		roomName = v[0]
+	}
+	if v := md.Get(MetadataName); len(v) > 0 {
+		name = v[0]
+	}
+	return roomName, name
+}
+
+// checkRoom 元数据中的聊天室名称不经过请求校验，这里按 HelloRequest.room 的规则检查
+func checkRoom(name string) error {
+	if utf8.RuneCountInString(name) > maxRoomLen || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return status.Errorf(codes.InvalidArgument, "聊天室名称不能超过 %d 个字符，不能包含控制字符", maxRoomLen)
+	}
+	return nil
+}
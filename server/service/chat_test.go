@@ -0,0 +1,281 @@
+package service_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// joinRoom 通过元数据或第一条消息加入聊天室，ctx 中的元数据 tenant 为聊天室所属的租户
+func joinRoom(t *testing.T, ctx context.Context, s *servertest.Server, room, name string, viaMetadata bool) hello.HelloService_BidiHelloClient {
+	t.Helper()
+	tenant := handler.DefaultTenant
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(handler.MetadataTenant)) > 0 {
+		tenant = md.Get(handler.MetadataTenant)[0]
+	}
+	if viaMetadata {
+		ctx = metadata.AppendToOutgoingContext(ctx, service.MetadataRoom, room, service.MetadataName, name)
+	}
+	stream, err := s.Hello.BidiHello(ctx)
+	if err != nil {
+		t.Fatalf("BidiHello: %v", err)
+	}
+	if !viaMetadata {
+		if err := stream.Send(&hello.HelloRequest{Name: name, Room: room}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	// 等服务端加入聊天室后再继续，保证事件的顺序
+	deadline := time.Now().Add(5 * time.Second)
+	for !contains(s.Rooms.Members(tenant, room), name) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not join %s", name, room)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return stream
+}
+
+// event 把聊天室中的事件格式化为 类型:名称:消息，便于比较
+func event(t *testing.T, stream hello.HelloService_BidiHelloClient) string {
+	t.Helper()
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	ev := resp.GetChat().GetType().String() + ":" + resp.GetName() + ":" + resp.GetMessage()
+	if resp.GetChat().GetReplay() {
+		ev = "replay " + ev
+	}
+	return ev
+}
+
+func TestChatRoom(t *testing.T) {
+	tests := []struct {
+		name        string
+		viaMetadata bool
+	}{
+		{name: "metadata", viaMetadata: true},
+		{name: "first message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			alice := joinRoom(t, ctx, s, "lobby", "alice", tt.viaMetadata)
+			bob := joinRoom(t, ctx, s, "lobby", "bob", tt.viaMetadata)
+			if got := event(t, alice); got != "TYPE_JOIN:bob:" {
+				t.Errorf("alice got %s, want bob join", got)
+			}
+
+			if err := bob.Send(&hello.HelloRequest{Name: "bob", Message: "hi"}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			for _, stream := range []hello.HelloService_BidiHelloClient{alice, bob} {
+				if got := event(t, stream); got != "TYPE_MESSAGE:bob:hi" {
+					t.Errorf("got %s, want bob's message", got)
+				}
+			}
+
+			// 其他聊天室收不到消息
+			other := joinRoom(t, ctx, s, "other", "carol", tt.viaMetadata)
+			other.CloseSend()
+			if _, err := other.Recv(); err != io.EOF {
+				t.Errorf("other room Recv = %v, want io.EOF", err)
+			}
+
+			if err := bob.CloseSend(); err != nil {
+				t.Fatalf("CloseSend: %v", err)
+			}
+			if _, err := bob.Recv(); err != io.EOF {
+				t.Errorf("bob Recv after CloseSend = %v, want io.EOF", err)
+			}
+			if got := event(t, alice); got != "TYPE_LEAVE:bob:" {
+				t.Errorf("alice got %s, want bob leave", got)
+			}
+			if got := s.Rooms.Members(handler.DefaultTenant, "lobby"); strings.Join(got, ",") != "alice" {
+				t.Errorf("members = %q", got)
+			}
+		})
+	}
+}
+
+// 不同租户的同名聊天室互不可见
+func TestChatRoomTenants(t *testing.T) {
+	s := servertest.Start(t, servertest.WithTenantsConfig(config.Tenants{TrustMetadata: true}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctxA := metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, "team-a")
+	ctxB := metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, "team-b")
+
+	alice := joinRoom(t, ctxA, s, "lobby", "alice", true)
+	bob := joinRoom(t, ctxB, s, "lobby", "bob", true)
+	if err := bob.Send(&hello.HelloRequest{Name: "bob", Message: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := event(t, bob); got != "TYPE_MESSAGE:bob:hi" {
+		t.Errorf("bob got %s, want own message", got)
+	}
+	if got := s.Rooms.Members("team-a", "lobby"); strings.Join(got, ",") != "alice" {
+		t.Errorf("team-a members = %q", got)
+	}
+
+	// alice 没有收到 bob 加入的通知和消息，关闭后直接结束
+	alice.CloseSend()
+	if resp, err := alice.Recv(); err != io.EOF {
+		t.Errorf("alice Recv = %v, %v, want io.EOF", resp, err)
+	}
+}
+
+func TestChatReplay(t *testing.T) {
+	s := servertest.Start(t, servertest.WithChatConfig(config.Chat{History: 2}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice := joinRoom(t, ctx, s, "lobby", "alice", true)
+	for _, msg := range []string{"1", "2", "3"} {
+		if err := alice.Send(&hello.HelloRequest{Name: "alice", Message: msg}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		event(t, alice)
+	}
+
+	// 后加入的成员先收到最近的 2 条消息，加入时的消息在重放之后
+	ctx = metadata.AppendToOutgoingContext(ctx, service.MetadataRoom, "lobby")
+	bob, err := s.Hello.BidiHello(ctx)
+	if err != nil {
+		t.Fatalf("BidiHello: %v", err)
+	}
+	if err := bob.Send(&hello.HelloRequest{Name: "bob", Message: "4"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, event(t, bob))
+	}
+	want := []string{"replay TYPE_MESSAGE:alice:2", "replay TYPE_MESSAGE:alice:3", "TYPE_MESSAGE:bob:4"}
+	if !equal(got, want) {
+		t.Errorf("bob got %q, want %q", got, want)
+	}
+}
+
+func TestChatSlowConsumer(t *testing.T) {
+	s := servertest.Start(t, servertest.WithChatConfig(config.Chat{SendQueue: 16, History: 1}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slow := joinRoom(t, ctx, s, "lobby", "slow", true)
+	fast := joinRoom(t, ctx, s, "lobby", "fast", true)
+
+	// fast 每发送一条消息都等到自己收到后再发下一条，slow 不读取，传输层的窗口用完后 slow 的队列会被填满
+	big := strings.Repeat("x", 8<<10)
+	left := ""
+	for i := 0; left == "" && i < 1000; i++ {
+		if err := fast.Send(&hello.HelloRequest{Name: "fast", Message: big}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		for {
+			resp, err := fast.Recv()
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			if resp.GetChat().GetType() == hello.ChatEvent_TYPE_LEAVE {
+				left = resp.GetName()
+			}
+			if resp.GetChat().GetType() == hello.ChatEvent_TYPE_MESSAGE {
+				break
+			}
+		}
+	}
+	if left != "slow" {
+		t.Fatalf("leave %q, want slow", left)
+	}
+
+	for {
+		_, err := slow.Recv()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.ResourceExhausted || !errs.IsReason(err, errs.ReasonSlowConsumer) {
+			t.Errorf("slow Recv = %v, want SLOW_CONSUMER", err)
+		}
+		break
+	}
+}
+
+// 多个成员同时变慢：离开的通知不能使已经断开的成员再被断开一次
+func TestChatSlowConsumers(t *testing.T) {
+	s := servertest.Start(t, servertest.WithChatConfig(config.Chat{SendQueue: 16, History: 1}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slow := []hello.HelloService_BidiHelloClient{
+		joinRoom(t, ctx, s, "lobby", "slow1", true),
+		joinRoom(t, ctx, s, "lobby", "slow2", true),
+		joinRoom(t, ctx, s, "lobby", "slow3", true),
+	}
+	fast := joinRoom(t, ctx, s, "lobby", "fast", true)
+
+	big := strings.Repeat("x", 8<<10)
+	left := make(map[string]bool)
+	for i := 0; len(left) < len(slow) && i < 1000; i++ {
+		if err := fast.Send(&hello.HelloRequest{Name: "fast", Message: big}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		for {
+			resp, err := fast.Recv()
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			if resp.GetChat().GetType() == hello.ChatEvent_TYPE_LEAVE {
+				if left[resp.GetName()] {
+					t.Errorf("%s left twice", resp.GetName())
+				}
+				left[resp.GetName()] = true
+			}
+			if resp.GetChat().GetType() == hello.ChatEvent_TYPE_MESSAGE {
+				break
+			}
+		}
+	}
+	if len(left) != len(slow) {
+		t.Fatalf("left = %v, want all slow members", left)
+	}
+	for _, stream := range slow {
+		for {
+			_, err := stream.Recv()
+			if err == nil {
+				continue
+			}
+			if !errs.IsReason(err, errs.ReasonSlowConsumer) {
+				t.Errorf("slow Recv = %v, want SLOW_CONSUMER", err)
+			}
+			break
+		}
+	}
+	if got := s.Rooms.Members(handler.DefaultTenant, "lobby"); strings.Join(got, ",") != "fast" {
+		t.Errorf("members = %q", got)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MetadataLastEventID 网关把 SSE 重连时的 Last-Event-ID 转发为该元数据，SubscribeRequest.after 没有设置时使用
+const MetadataLastEventID = "last-event-id"
+
+// readBatch 订阅时每次从存储读取的事件数
+const readBatch = 100
+
+// EventStore 事件的持久化存储，EventServer 使用的主题为 "租户/主题"，见 storeTopic
+// MemoryEventStore 是单实例的内存实现，需要重启后恢复或多实例共享时可以实现该接口接入数据库、redis 等存储
+type EventStore interface {
+	// Append 追加事件，按主题分配从 1 开始连续递增的序号，返回保存后的事件
+	Append(ctx context.Context, topic, data string) (*hello.Event, error)
+	// Read 返回序号大于 after 的事件，最多 limit 条，after 为 0 时从保留的第一个事件开始；
+	// after 之后的事件已经被清理或者 after 超过最后一个事件时返回 errs.ErrCursorExpired
+	Read(ctx context.Context, topic string, after uint64, limit int) ([]*hello.Event, error)
+	// Last 返回主题中最后一个事件的序号，没有事件时为 0
+	Last(ctx context.Context, topic string) (uint64, error)
+}
+
+// MemoryEventStore 基于内存的事件存储，每个主题只保留最近的事件
+type MemoryEventStore struct {
+	mu        sync.Mutex
+	maxEvents int
+	topics    map[string]*eventLog
+}
+
+type eventLog struct {
+	// events 保留的事件，序号连续，第一个事件的序号为 last-len(events)+1
+	events []*hello.Event
+	last   uint64
+}
+
+// NewMemoryEventStore 创建内存存储，每个主题最多保留 maxEvents 个事件，0 表示不清理
+func NewMemoryEventStore(maxEvents int) *MemoryEventStore {
+	return &MemoryEventStore{maxEvents: maxEvents, topics: make(map[string]*eventLog)}
+}
+
+func (s *MemoryEventStore) Append(_ context.Context, topic, data string) (*hello.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.topics[topic]
+	if !ok {
+		l = &eventLog{}
+		s.topics[topic] = l
+	}
+	l.last++
+	e := &hello.Event{Topic: topic, Seq: l.last, Data: data, Time: timestamppb.Now()}
+	l.events = append(l.events, e)
+	if s.maxEvents > 0 && len(l.events) > s.maxEvents {
+		l.events = append(l.events[:0:0], l.events[len(l.events)-s.maxEvents:]...)
+	}
+	return proto.Clone(e).(*hello.Event), nil
+}
+
+func (s *MemoryEventStore) Read(_ context.Context, topic string, after uint64, limit int) ([]*hello.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.topics[topic]
+	if !ok {
+		l = &eventLog{}
+	}
+	if after == l.last {
+		return nil, nil
+	}
+	first := l.last - uint64(len(l.events)) + 1
+	// 0 表示从保留的第一个事件开始，不会过期
+	if after == 0 {
+		after = first - 1
+	}
+	if after+1 < first {
+		return nil, errs.ErrCursorExpired.WithMetadata("first", strconv.FormatUint(first, 10))
+	}
+	// after 超过最后一个事件（例如存储重启后丢失了事件、主题不存在）时同样无法从 after 继续
+	if after > l.last {
+		return nil, errs.ErrCursorExpired.WithMessage("订阅位置超过了最后一个事件").
+			WithMetadata("first", strconv.FormatUint(first, 10)).
+			WithMetadata("last", strconv.FormatUint(l.last, 10))
+	}
+	events := l.events[after+1-first:]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	// 返回副本，调用方可以修改
+	out := make([]*hello.Event, len(events))
+	for i, e := range events {
+		out[i] = proto.Clone(e).(*hello.Event)
+	}
+	return out, nil
+}
+
+func (s *MemoryEventStore) Last(_ context.Context, topic string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.topics[topic]; ok {
+		return l.last, nil
+	}
+	return 0, nil
+}
+
+// EventServer 实现 EventServiceServer，主题按租户隔离。
+// 本实例发布的事件立即通知订阅者；其他实例通过共享存储发布的事件在下一次心跳时读取
+type EventServer struct {
+	hello.UnimplementedEventServiceServer
+	store     EventStore
+	heartbeat time.Duration
+
+	mu sync.Mutex
+	// topics 有订阅者的主题，最后一个订阅者结束时删除
+	topics map[string]*topicWaiters
+}
+
+type topicWaiters struct {
+	subscribers int
+	// published 主题有新事件时关闭，等待中的订阅者被唤醒后重新读取
+	published chan struct{}
+}
+
+// NewEventServer 创建 EventService，store 为 nil 时使用内存存储
+func NewEventServer(store EventStore, cfg config.Events) *EventServer {
+	cfg = cfg.WithDefaults()
+	if store == nil {
+		store = NewMemoryEventStore(cfg.MaxEvents)
+	}
+	return &EventServer{store: store, heartbeat: cfg.Heartbeat, topics: make(map[string]*topicWaiters)}
+}
+
+func (s *EventServer) Publish(ctx context.Context, req *hello.PublishRequest) (*hello.Event, error) {
+	key := storeTopic(ctx, req.GetTopic())
+	e, err := s.store.Append(ctx, key, req.GetData())
+	if err != nil {
+		return nil, err
+	}
+	e.Topic = req.GetTopic()
+	s.mu.Lock()
+	if w, ok := s.topics[key]; ok && w.published != nil {
+		close(w.published)
+		w.published = nil
+	}
+	s.mu.Unlock()
+	return e, nil
+}
+
+// Subscribers 返回每个存储中的主题当前的订阅者数
+func (s *EventServer) Subscribers() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int, len(s.topics))
+	for key, w := range s.topics {
+		out[key] = w.subscribers
+	}
+	return out
+}
+
+func (s *EventServer) Subscribe(req *hello.SubscribeRequest, stream hello.EventService_SubscribeServer) error {
+	ctx := stream.Context()
+	topic := req.GetTopic()
+	key := storeTopic(ctx, topic)
+	cursor, err := s.cursor(ctx, key, req)
+	if err != nil {
+		return err
+	}
+	s.subscribe(key)
+	defer s.unsubscribe(key)
+	// 立即返回响应头，客户端收到响应头之后发布的事件一定会推送
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	// sent 上一次心跳之后是否发送过事件
+	sent := false
+	for {
+		// 先取通知再读取，读取之后发布的事件一定会唤醒等待
+		published := s.wait(key)
+		events, err := s.store.Read(ctx, key, cursor, readBatch)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			e.Topic = topic
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+			cursor = e.GetSeq()
+			sent = true
+		}
+		if len(events) == readBatch {
+			continue
+		}
+
+		select {
+		case <-published:
+		case <-ticker.C:
+			if !sent {
+				if err := stream.Send(&hello.Event{Topic: topic, Seq: cursor, Time: timestamppb.Now(), Heartbeat: true}); err != nil {
+					return err
+				}
+			}
+			sent = false
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// cursor 订阅的起始位置：after、Last-Event-ID，都没有时从存储中 key 的最后一个事件之后开始
+func (s *EventServer) cursor(ctx context.Context, key string, req *hello.SubscribeRequest) (uint64, error) {
+	if req.After != nil {
+		return req.GetAfter(), nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(MetadataLastEventID); len(v) > 0 {
+		after, err := strconv.ParseUint(v[0], 10, 64)
+		if err != nil {
+			return 0, status.Errorf(codes.InvalidArgument, "Last-Event-ID 不是合法的序号：%q", v[0])
+		}
+		return after, nil
+	}
+	return s.store.Last(ctx, key)
+}
+
+// storeTopic 存储中的主题带上租户，不同租户的同名主题互不可见。租户名称不包含 /，不会和其他租户冲突
+func storeTopic(ctx context.Context, topic string) string {
+	return handler.TenantFromContext(ctx) + "/" + topic
+}
+
+func (s *EventServer) subscribe(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.topics[key]
+	if !ok {
+		w = &topicWaiters{}
+		s.topics[key] = w
+	}
+	w.subscribers++
+}
+
+// unsubscribe 最后一个订阅者结束时删除主题，不同的主题不会一直占用内存
+func (s *EventServer) unsubscribe(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.topics[key]
+	if w.subscribers--; w.subscribers == 0 {
+		delete(s.topics, key)
+	}
+}
+
+// wait 返回存储中的主题下一次发布事件时关闭的通道，需要先 subscribe
+func (s *EventServer) wait(key string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.topics[key]
+	if w.published == nil {
+		w.published = make(chan struct{})
+	}
+	return w.published
+}
@@ -0,0 +1,260 @@
+package service_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func publish(t *testing.T, ctx context.Context, s *servertest.Server, topic string, data ...string) {
+	t.Helper()
+	for _, d := range data {
+		if _, err := s.Events.Publish(ctx, &hello.PublishRequest{Topic: topic, Data: d}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+// subscribe 订阅并等到服务端返回响应头，之后发布的事件一定能收到
+func subscribe(t *testing.T, ctx context.Context, s *servertest.Server, req *hello.SubscribeRequest) hello.EventService_SubscribeClient {
+	t.Helper()
+	stream, err := s.Events.Subscribe(ctx, req)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header: %v", err)
+	}
+	return stream
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *hello.SubscribeRequest
+		md   []string
+		want []string
+	}{
+		{name: "new events only", req: &hello.SubscribeRequest{Topic: "orders"}, want: []string{"4:new"}},
+		{name: "from beginning", req: &hello.SubscribeRequest{Topic: "orders", After: proto.Uint64(0)}, want: []string{"1:a", "2:b", "3:c", "4:new"}},
+		{name: "resume after cursor", req: &hello.SubscribeRequest{Topic: "orders", After: proto.Uint64(2)}, want: []string{"3:c", "4:new"}},
+		{name: "last event id", req: &hello.SubscribeRequest{Topic: "orders"}, md: []string{service.MetadataLastEventID, "1"}, want: []string{"2:b", "3:c", "4:new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			publish(t, ctx, s, "orders", "a", "b", "c")
+			publish(t, ctx, s, "other", "x")
+
+			stream := subscribe(t, metadata.AppendToOutgoingContext(ctx, tt.md...), s, tt.req)
+			publish(t, ctx, s, "orders", "new")
+			var got []string
+			for len(got) < len(tt.want) {
+				e, err := stream.Recv()
+				if err != nil {
+					t.Fatalf("Recv: %v", err)
+				}
+				if e.GetTopic() != "orders" {
+					t.Errorf("event from topic %q", e.GetTopic())
+				}
+				got = append(got, eventString(e))
+			}
+			if !equal(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeCursorExpired(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		after uint64
+		want  map[string]string
+	}{
+		{name: "events removed", topic: "orders", after: 1, want: map[string]string{"first": "3"}},
+		// 存储重启后客户端的位置可能超过最后一个事件
+		{name: "after last event", topic: "orders", after: 5, want: map[string]string{"first": "3", "last": "4"}},
+		{name: "unknown topic", topic: "missing", after: 1, want: map[string]string{"first": "1", "last": "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, servertest.WithEventsConfig(config.Events{MaxEvents: 2}))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			publish(t, ctx, s, "orders", "a", "b", "c", "d")
+
+			stream, err := s.Events.Subscribe(ctx, &hello.SubscribeRequest{Topic: tt.topic, After: proto.Uint64(tt.after)})
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			_, err = stream.Recv()
+			if status.Code(err) != codes.OutOfRange || !errs.IsReason(err, errs.ReasonCursorExpired) {
+				t.Fatalf("Recv = %v, want CURSOR_EXPIRED", err)
+			}
+			if e := errs.FromError(err); !reflect.DeepEqual(e.Metadata, tt.want) {
+				t.Errorf("metadata = %v, want %v", e.Metadata, tt.want)
+			}
+		})
+	}
+}
+
+// after 为 0 时从保留的第一个事件开始，不会因为清理了事件而过期
+func TestSubscribeFromFirstRetained(t *testing.T) {
+	s := servertest.Start(t, servertest.WithEventsConfig(config.Events{MaxEvents: 2}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	publish(t, ctx, s, "orders", "a", "b", "c")
+
+	stream := subscribe(t, ctx, s, &hello.SubscribeRequest{Topic: "orders", After: proto.Uint64(0)})
+	var got []string
+	for len(got) < 2 {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		got = append(got, eventString(e))
+	}
+	if want := []string{"2:b", "3:c"}; !equal(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+// 订阅结束后主题不再占用内存
+func TestSubscribeReleasesTopics(t *testing.T) {
+	events := service.NewEventServer(nil, config.Events{Heartbeat: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 3)
+	for _, topic := range []string{"a", "b", "b"} {
+		stream := &subscribeStream{ctx: ctx, events: make(chan *hello.Event, 10)}
+		go func(topic string) { done <- events.Subscribe(&hello.SubscribeRequest{Topic: topic}, stream) }(topic)
+	}
+	want := map[string]int{handler.DefaultTenant + "/a": 1, handler.DefaultTenant + "/b": 2}
+	deadline := time.Now().Add(5 * time.Second)
+	for got := events.Subscribers(); !reflect.DeepEqual(got, want); got = events.Subscribers() {
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribers = %v, want %v", got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := events.Publish(context.Background(), &hello.PublishRequest{Topic: "b", Data: "x"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	cancel()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if got := events.Subscribers(); len(got) != 0 {
+		t.Errorf("subscribers after cancel = %v", got)
+	}
+}
+
+// 不同租户的同名主题互不可见，序号分别计算
+func TestSubscribeTenants(t *testing.T) {
+	s := servertest.Start(t, servertest.WithTenantsConfig(config.Tenants{TrustMetadata: true}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctxA := metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, "team-a")
+	ctxB := metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, "team-b")
+	publish(t, ctxA, s, "orders", "a1", "a2")
+	publish(t, ctxB, s, "orders", "b1")
+
+	stream := subscribe(t, ctxB, s, &hello.SubscribeRequest{Topic: "orders", After: proto.Uint64(0)})
+	publish(t, ctxA, s, "orders", "a3")
+	publish(t, ctxB, s, "orders", "b2")
+	var got []string
+	for len(got) < 2 {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if e.GetTopic() != "orders" {
+			t.Errorf("event topic = %q", e.GetTopic())
+		}
+		got = append(got, eventString(e))
+	}
+	if want := []string{"1:b1", "2:b2"}; !equal(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestSubscribeHeartbeat(t *testing.T) {
+	s := servertest.Start(t, servertest.WithEventsConfig(config.Events{Heartbeat: 20 * time.Millisecond}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	publish(t, ctx, s, "orders", "a")
+
+	stream := subscribe(t, ctx, s, &hello.SubscribeRequest{Topic: "orders"})
+	for i := 0; i < 2; i++ {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if !e.GetHeartbeat() || e.GetSeq() != 1 || e.GetData() != "" {
+			t.Errorf("event = %v, want heartbeat at seq 1", e)
+		}
+	}
+}
+
+// 其他实例通过共享存储发布的事件在心跳时读取
+func TestSubscribeSharedStore(t *testing.T) {
+	store := service.NewMemoryEventStore(0)
+	a := service.NewEventServer(store, config.Events{Heartbeat: 20 * time.Millisecond})
+	b := service.NewEventServer(store, config.Events{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := make(chan *hello.Event, 10)
+	go a.Subscribe(&hello.SubscribeRequest{Topic: "orders", After: proto.Uint64(0)}, &subscribeStream{ctx: ctx, events: events})
+	// 收到第一个心跳后再发布，事件只能在之后的心跳中读到
+	if e := <-events; !e.GetHeartbeat() {
+		t.Fatalf("event = %v, want heartbeat", e)
+	}
+	if _, err := b.Publish(ctx, &hello.PublishRequest{Topic: "orders", Data: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case e := <-events:
+			if e.GetHeartbeat() {
+				continue
+			}
+			if got := eventString(e); got != "1:a" {
+				t.Errorf("event = %s", got)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("event published on another server was not delivered")
+		}
+	}
+}
+
+type subscribeStream struct {
+	hello.EventService_SubscribeServer
+	ctx    context.Context
+	events chan<- *hello.Event
+}
+
+func (s *subscribeStream) Context() context.Context     { return s.ctx }
+func (s *subscribeStream) SendHeader(metadata.MD) error { return nil }
+func (s *subscribeStream) Send(e *hello.Event) error    { s.events <- e; return nil }
+
+func eventString(e *hello.Event) string {
+	return strconv.FormatUint(e.GetSeq(), 10) + ":" + e.GetData()
+}
@@ -1,45 +1,207 @@
 package service
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
 	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io"
+	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// defaultDownloadPath DownLoadFile 默认下载的文件
+const defaultDownloadPath = "conf/server.crt"
+
 type FileServer struct {
 	hello.UnimplementedFileServiceServer
+	// DownloadPath DownLoadFile 下载的文件，为空时使用 conf/server.crt，设置了 Root 时不使用
+	DownloadPath string
+	// Root 上传的文件按租户保存在 Root/<租户>/ 下，DownLoadFile 按 name 下载当前租户的文件；为空时不保存上传的文件
+	Root string
+	// Quotas 每个租户在 Root 中的存储配额
+	Quotas config.Quotas
 }
 
-func (FileServer) DownLoadFile(request *hello.HelloRequest, stream hello.FileService_DownLoadFileServer) error {
-	fmt.Println(request)
-	file, err := os.Open("conf/server.crt")
+func (s FileServer) DownLoadFile(request *hello.HelloRequest, stream hello.FileService_DownLoadFileServer) error {
+	path := s.DownloadPath
+	if path == "" {
+		path = defaultDownloadPath
+	}
+	if s.Root != "" {
+		dir := s.tenantDir(stream.Context())
+		if err := checkFileName(request.GetName()); err != nil {
+			return err
+		}
+		path = filepath.Join(dir, request.GetName())
+	}
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return fileError(path, err)
 	}
 	defer file.Close()
 
 	for {
+		// 客户端取消或超过截止时间后停止传输
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
 		buf := make([]byte, 2048)
-		_, err = file.Read(buf)
+		n, err := file.Read(buf)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			break
+			return fileError(path, err)
+		}
+		// 只发送实际读到的数据，最后一块不足 2048 字节
+		if err := stream.Send(&hello.FileResponse{
+			Content: buf[:n],
+		}); err != nil {
+			return err
 		}
-		stream.Send(&hello.FileResponse{
-			Content: buf,
-		})
 	}
 	return nil
 }
 
-func (FileServer) UploadFile(stream hello.FileService_UploadFileServer) error {
-	for i := 0; i < 10; i++ {
-		response, err := stream.Recv()
-		fmt.Println(response, err)
+func (s FileServer) UploadFile(stream hello.FileService_UploadFileServer) error {
+	if s.Root != "" {
+		return s.store(stream)
+	}
+	// 一直接收到客户端关闭发送
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&hello.HelloResponse{Message: "完毕了"})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fileError 转换文件操作的错误，只返回文件名，不暴露服务端的目录
+func fileError(path string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return errs.ErrFileNotFound.WithCause(err).WithMetadata("file", filepath.Base(path))
+	}
+	return errs.ErrFileUnreadable.WithCause(err).WithMetadata("file", filepath.Base(path))
+}
+
+// tenantDir 当前租户的存储目录
+func (s FileServer) tenantDir(ctx context.Context) string {
+	return filepath.Join(s.Root, handler.TenantFromContext(ctx))
+}
+
+// store 把上传的文件保存到租户目录，文件名取第一条消息的 file_name。
+// 先写入临时文件，超过配额或失败时删除，收完后替换同名文件。
+// 并发上传时按各自开始时的用量检查，合计可能略微超过配额
+func (s FileServer) store(stream hello.FileService_UploadFileServer) (err error) {
+	tenant := handler.TenantFromContext(stream.Context())
+	dir := s.tenantDir(stream.Context())
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&hello.HelloResponse{Message: "完毕了"})
+	}
+	if err != nil {
+		return err
+	}
+	name := first.GetFileName()
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return storeError(tenant, err)
+	}
+	quota := s.Quotas.For(tenant)
+	used, files, err := usage(dir, name)
+	if err != nil {
+		return storeError(tenant, err)
+	}
+	if quota.Files > 0 && files >= quota.Files {
+		return quotaExceeded(tenant, "files="+strconv.Itoa(quota.Files))
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return storeError(tenant, err)
+	}
+	defer func() {
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	for in := first; ; {
+		used += int64(len(in.GetContent()))
+		if quota.StorageBytes > 0 && used > quota.StorageBytes {
+			return quotaExceeded(tenant, "storageBytes="+strconv.FormatInt(quota.StorageBytes, 10))
+		}
+		if _, err := tmp.Write(in.GetContent()); err != nil {
+			return storeError(tenant, err)
+		}
+		in, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return storeError(tenant, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return storeError(tenant, err)
+	}
+	log.Printf("tenant %s uploaded %s", tenant, name)
+	return stream.SendAndClose(&hello.HelloResponse{Message: "完毕了"})
+}
+
+// usage 统计目录中已保存文件的总字节数和文件数，不包括上传中的临时文件和将被替换的同名文件
+func usage(dir, replace string) (int64, int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var bytes int64
+	var files int
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".upload-") || e.Name() == replace {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, 0, err
+		}
+		bytes += info.Size()
+		files++
+	}
+	return bytes, files, nil
+}
+
+// checkFileName 文件名不能包含路径，校验拦截器之外再检查一次，避免访问租户目录以外的文件
+func checkFileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".upload-") {
+		return status.Errorf(codes.InvalidArgument, "文件名不合法：%q", name)
 	}
-	stream.SendAndClose(&hello.HelloResponse{Message: "完毕了"})
 	return nil
 }
+
+// storeError 保存文件失败，服务端的路径只记录在日志中
+func storeError(tenant string, err error) error {
+	log.Printf("tenant %s: store file: %v", tenant, err)
+	return status.Error(codes.Internal, "保存文件失败")
+}
+
+func quotaExceeded(tenant, limit string) error {
+	return errs.ErrQuotaExceeded.WithMetadata("tenant", tenant).WithMetadata("limit", limit)
+}
@@ -0,0 +1,189 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/keepon-online/go-grpc-example/config"
+	"github.com/keepon-online/go-grpc-example/errs"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/handler"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"github.com/keepon-online/go-grpc-example/server/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownLoadFile(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "one chunk", size: 100},
+		{name: "exact chunks", size: 2 * 2048},
+		{name: "partial last chunk", size: 3*2048 + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := bytes.Repeat([]byte("x"), tt.size)
+			path := filepath.Join(t.TempDir(), "download")
+			if err := os.WriteFile(path, want, 0600); err != nil {
+				t.Fatal(err)
+			}
+			s := servertest.Start(t, servertest.WithFileServer(service.FileServer{DownloadPath: path}))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			reader, err := s.SDK.Download(ctx, &hello.HelloRequest{Name: "1213"})
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			defer reader.Close()
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("downloaded %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestDownLoadFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing")
+	s := servertest.Start(t, servertest.WithFileServer(service.FileServer{DownloadPath: path}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := s.File.DownLoadFile(ctx, &hello.HelloRequest{})
+	if err != nil {
+		t.Fatalf("DownLoadFile: %v", err)
+	}
+	_, err = stream.Recv()
+	if status.Code(err) != codes.NotFound || !errs.IsReason(err, errs.ReasonFileNotFound) {
+		t.Errorf("Recv = %v, want %v", err, errs.ErrFileNotFound)
+	}
+	if got := errs.FromError(err).Metadata["file"]; got != "missing" {
+		t.Errorf("metadata file = %q, want %q", got, "missing")
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 10},
+		{name: "many chunks", size: 100 * 1024},
+	}
+	s := servertest.Start(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			uploader, err := s.SDK.Upload(ctx, "upload.txt")
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if _, err := uploader.Write(bytes.Repeat([]byte("y"), tt.size)); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := uploader.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if got := uploader.Response().GetMessage(); got != "完毕了" {
+				t.Errorf("UploadFile message = %q, want %q", got, "完毕了")
+			}
+		})
+	}
+}
+
+func TestUploadFileTenants(t *testing.T) {
+	root := t.TempDir()
+	s := servertest.Start(t,
+		servertest.WithTenantsConfig(config.Tenants{TrustMetadata: true}),
+		servertest.WithFileServer(service.FileServer{Root: root, Quotas: config.Quotas{
+			"*":     {StorageBytes: 100, Files: 2},
+			"large": {StorageBytes: 1 << 20},
+		}}),
+	)
+	upload := func(tenant, name string, size int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		uploader, err := s.SDK.Upload(metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, tenant), name)
+		if err != nil {
+			return err
+		}
+		if _, err := uploader.Write(bytes.Repeat([]byte("z"), size)); err != nil {
+			return err
+		}
+		return uploader.Close()
+	}
+	download := func(tenant, name string) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		reader, err := s.SDK.Download(metadata.AppendToOutgoingContext(ctx, handler.MetadataTenant, tenant),
+			&hello.HelloRequest{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+
+	tests := []struct {
+		name   string
+		tenant string
+		file   string
+		size   int
+		reason string
+		limit  string
+	}{
+		{name: "first file", tenant: "a", file: "one.txt", size: 40},
+		{name: "replace keeps usage", tenant: "a", file: "one.txt", size: 60},
+		{name: "storage quota", tenant: "a", file: "two.txt", size: 41, reason: errs.ReasonQuotaExceeded, limit: "storageBytes=100"},
+		{name: "second file", tenant: "a", file: "two.txt", size: 40},
+		{name: "files quota", tenant: "a", file: "three.txt", size: 1, reason: errs.ReasonQuotaExceeded, limit: "files=2"},
+		{name: "other tenant has own quota", tenant: "b", file: "one.txt", size: 100},
+		{name: "tenant quota overrides default", tenant: "large", file: "big.txt", size: 4096},
+		{name: "invalid tenant", tenant: "../a", file: "x.txt", size: 1, reason: errs.ReasonTenantInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := upload(tt.tenant, tt.file, tt.size)
+			if tt.reason == "" && err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+			if tt.reason != "" && !errs.IsReason(err, tt.reason) {
+				t.Fatalf("upload = %v, want reason %s", err, tt.reason)
+			}
+			if tt.limit != "" {
+				if got := errs.FromError(err).Metadata["limit"]; got != tt.limit {
+					t.Errorf("metadata limit = %q, want %q", got, tt.limit)
+				}
+			}
+		})
+	}
+
+	// 每个租户只能下载自己的文件
+	if got, err := download("a", "one.txt"); err != nil || len(got) != 60 {
+		t.Errorf("download a/one.txt = %d bytes, %v", len(got), err)
+	}
+	if _, err := download("b", "two.txt"); !errs.IsReason(err, errs.ReasonFileNotFound) {
+		t.Errorf("download b/two.txt = %v, want %s", err, errs.ReasonFileNotFound)
+	}
+	// 超过配额的上传不留下临时文件
+	matches, err := filepath.Glob(filepath.Join(root, "*", ".upload-*"))
+	if err != nil || len(matches) != 0 {
+		t.Errorf("temporary files left: %v %v", matches, err)
+	}
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+)
+
+// GateWayServer 实现GatewayServiceServer，通过 grpc-gateway 对外提供 HTTP 接口
+type GateWayServer struct {
+	hello.UnimplementedGatewayServiceServer
+}
+
+func (g GateWayServer) SayMessage(ctx context.Context, request *hello.HelloRequest) (*hello.HelloResponse, error) {
+	return &hello.HelloResponse{
+		Message: request.GetMessage(),
+		Name:    request.GetName(),
+	}, nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+)
+
+// HelloServer HelloServer 实现HelloServiceServer
+type HelloServer struct {
+	hello.UnimplementedHelloServiceServer
+	// Rooms BidiHello 的聊天室，为 nil 时不能加入聊天室
+	Rooms *Rooms
+	// Batch 批量接收的限制，为 nil 时 LotsOfGreetings 使用默认限制，不能调用 BatchGreetings
+	Batch *Batch
+}
+
+func (s HelloServer) SayHello(ctx context.Context, request *hello.HelloRequest) (pd *hello.HelloResponse, err error) {
+	return &hello.HelloResponse{
+		Name:    request.Name,
+		Message: request.Message,
+	}, nil
+}
+
+// LotsOfReplies 服务端返回流
+func (s HelloServer) LotsOfReplies(in *hello.HelloRequest, stream hello.HelloService_LotsOfRepliesServer) error {
+	words := []string{
+		"你好",
+		"hello",
+		"こんにちは",
+		"안녕하세요",
+	}
+	for _, word := range words {
+		// 客户端取消或超过截止时间后不再继续发送
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		data := &hello.HelloResponse{
+			Name: in.Name + word,
+		}
+		// 使用Send方法返回多个数据
+		if err := stream.Send(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LotsOfGreetings 接收流式数据，消息数和总大小超过限制时返回 errs.ErrBatchTooLarge
+func (s HelloServer) LotsOfGreetings(stream hello.HelloService_LotsOfGreetingsServer) error {
+	counter := &batchCounter{cfg: s.Batch.limits()}
+	var reply strings.Builder
+	reply.WriteString(greetingPrefix)
+	for {
+		// 接收客户端发来的流式数据
+		res, err := stream.Recv()
+		if err == io.EOF {
+			// 最终统一回复
+			return stream.SendAndClose(&hello.HelloResponse{
+				Name: reply.String(),
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if err := counter.add(res); err != nil {
+			return err
+		}
+		reply.WriteString(res.GetName())
+	}
+}
+
+// BatchGreetings 批量接收，见 Batch.serve
+func (s HelloServer) BatchGreetings(stream hello.HelloService_BatchGreetingsServer) error {
+	if s.Batch == nil {
+		return status.Error(codes.Unimplemented, "没有启用批量接收")
+	}
+	return s.Batch.serve(stream)
+}
+
+// BidiHello 双向流数据。元数据或第一条消息中指定了聊天室时加入聊天室，否则原样返回收到的消息
+func (s HelloServer) BidiHello(stream hello.HelloService_BidiHelloServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	roomName, name := roomFromMetadata(md)
+	var first *hello.HelloRequest
+	if roomName == "" {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if in.GetRoom() == "" {
+			return echo(stream, in)
+		}
+		roomName, name, first = in.GetRoom(), in.GetName(), in
+	} else if err := checkRoom(roomName); err != nil {
+		return err
+	}
+	if s.Rooms == nil {
+		return status.Error(codes.Unimplemented, "没有启用聊天室")
+	}
+	return s.Rooms.serve(stream, roomName, name, first)
+}
+
+// echo 不在聊天室中时原样返回收到的消息，in 为已经收到的第一条消息
+func echo(stream hello.HelloService_BidiHelloServer, in *hello.HelloRequest) error {
+	for {
+		reply := in.GetName() // 对收到的数据做些处理
+
+		// 返回流式响应
+		if err := stream.Send(&hello.HelloResponse{Name: reply}); err != nil {
+			return err
+		}
+		// 接收流式请求
+		var err error
+		in, err = stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package service_test
+
+import (
+	"context"
+	"github.com/keepon-online/go-grpc-example/gen/hello"
+	"github.com/keepon-online/go-grpc-example/server/servertest"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSayHello(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []servertest.Option
+		req  *hello.HelloRequest
+	}{
+		{name: "plain", req: &hello.HelloRequest{Name: "鲁迪", Message: "ok"}},
+		{name: "empty", req: &hello.HelloRequest{}},
+		{name: "tls", opts: []servertest.Option{servertest.WithTLS()}, req: &hello.HelloRequest{Name: "a", Message: "b"}},
+		{name: "auth", opts: []servertest.Option{servertest.WithAuth()}, req: &hello.HelloRequest{Name: "a", Message: "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := servertest.Start(t, tt.opts...)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			resp, err := s.Hello.SayHello(ctx, tt.req)
+			if err != nil {
+				t.Fatalf("SayHello: %v", err)
+			}
+			if resp.GetName() != tt.req.GetName() || resp.GetMessage() != tt.req.GetMessage() {
+				t.Errorf("SayHello = %v, want name %q message %q", resp, tt.req.GetName(), tt.req.GetMessage())
+			}
+		})
+	}
+}
+
+func TestLotsOfReplies(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "鲁迪", want: []string{"鲁迪你好", "鲁迪hello", "鲁迪こんにちは", "鲁迪안녕하세요"}},
+		{name: "", want: []string{"你好", "hello", "こんにちは", "안녕하세요"}},
+	}
+	s := servertest.Start(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			stream, err := s.Hello.LotsOfReplies(ctx, &hello.HelloRequest{Name: tt.name})
+			if err != nil {
+				t.Fatalf("LotsOfReplies: %v", err)
+			}
+			var got []string
+			for {
+				resp, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Recv: %v", err)
+				}
+				got = append(got, resp.GetName())
+			}
+			if !equal(got, tt.want) {
+				t.Errorf("LotsOfReplies = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLotsOfGreetings(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{name: "none", want: "你好："},
+		{name: "one", names: []string{"孙悟空"}, want: "你好：孙悟空"},
+		{name: "many", names: []string{"孙悟空", "齐天大圣", "弼马温"}, want: "你好：孙悟空齐天大圣弼马温"},
+	}
+	s := servertest.Start(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			stream, err := s.Hello.LotsOfGreetings(ctx)
+			if err != nil {
+				t.Fatalf("LotsOfGreetings: %v", err)
+			}
+			for _, name := range tt.names {
+				if err := stream.Send(&hello.HelloRequest{Name: name}); err != nil {
+					t.Fatalf("Send(%q): %v", name, err)
+				}
+			}
+			resp, err := stream.CloseAndRecv()
+			if err != nil {
+				t.Fatalf("CloseAndRecv: %v", err)
+			}
+			if resp.GetName() != tt.want {
+				t.Errorf("LotsOfGreetings = %q, want %q", resp.GetName(), tt.want)
+			}
+		})
+	}
+}
+
+func TestBidiHello(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+	}{
+		{name: "none"},
+		{name: "many", names: []string{"孙悟空", "齐天大圣", "弼马温"}},
+	}
+	s := servertest.Start(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			stream, err := s.Hello.BidiHello(ctx)
+			if err != nil {
+				t.Fatalf("BidiHello: %v", err)
+			}
+			// 每发送一条消息收到一条相同名字的响应
+			for _, name := range tt.names {
+				if err := stream.Send(&hello.HelloRequest{Name: name}); err != nil {
+					t.Fatalf("Send(%q): %v", name, err)
+				}
+				resp, err := stream.Recv()
+				if err != nil {
+					t.Fatalf("Recv: %v", err)
+				}
+				if resp.GetName() != name {
+					t.Errorf("reply = %q, want %q", resp.GetName(), name)
+				}
+			}
+			if err := stream.CloseSend(); err != nil {
+				t.Fatalf("CloseSend: %v", err)
+			}
+			if _, err := stream.Recv(); err != io.EOF {
+				t.Errorf("Recv after CloseSend = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestSayMessage(t *testing.T) {
+	s := servertest.Start(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := s.Gateway.SayMessage(ctx, &hello.HelloRequest{Name: "test", Message: "收到请求"})
+	if err != nil {
+		t.Fatalf("SayMessage: %v", err)
+	}
+	if resp.GetName() != "test" || resp.GetMessage() != "收到请求" {
+		t.Errorf("SayMessage = %v", resp)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -13,6 +13,8 @@ type JWT struct {
 // CustomClaims  structure
 type CustomClaims struct {
 	BaseClaims
+	// Tenant 用户所属的租户，为空时由服务端按元数据或默认租户确定
+	Tenant     string `json:",omitempty"`
 	BufferTime int64
 	jwt.RegisteredClaims
 }
@@ -29,9 +31,13 @@ var (
 	TokenInvalid     = errors.New("couldn't handle this token")
 )
 
-func NewJWT() *JWT {
+// MinSigningKeyLen HS256 签名密钥的最小长度（字节）
+const MinSigningKeyLen = 32
+
+// NewJWT 使用配置中的签名密钥签发和校验token，密钥不能写在代码中
+func NewJWT(signingKey []byte) *JWT {
 	return &JWT{
-		[]byte("12312dsdsdfdfbndassa"),
+		SigningKey: signingKey,
 	}
 }
 